@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== Album History ==========
+
+// AlbumHistoryEvent is one row of album_events, which a trigger appends to on
+// every change to an album (see migrations/015_album_event_log.sql). Type is
+// AlbumCreated, AlbumUpdated or AlbumDeleted.
+type AlbumHistoryEvent struct {
+	tableName struct{} `pg:"album_events"`
+
+	ID         int64                  `json:"id" pg:"id,pk"`
+	TenantID   string                 `json:"-" pg:"tenant_id"`
+	AlbumID    string                 `json:"album_id" pg:"album_id"`
+	Type       string                 `json:"event_type" pg:"event_type"`
+	Payload    map[string]interface{} `json:"-" pg:"payload,type:jsonb"`
+	OccurredAt time.Time              `json:"occurred_at" pg:"occurred_at,default:now()"`
+
+	// Album is Payload decoded: the album after the change, or for
+	// AlbumDeleted the album as it was when deleted
+	Album *Album `json:"payload" pg:"-"`
+	// ChangedFields names the fields an AlbumUpdated event changed; see markChangedFields
+	ChangedFields []string `json:"changed_fields,omitempty" pg:"-"`
+}
+
+// AfterScan decodes the payload, which holds the row as stored, price and all
+func (e *AlbumHistoryEvent) AfterScan(ctx context.Context) error {
+	row, err := json.Marshal(e.Payload)
+	if err != nil {
+		return err
+	}
+	album, err := albumFromRow(row)
+	if err != nil {
+		return err
+	}
+	e.Album = album
+	return nil
+}
+
+// AlbumEventRepository reads the event log of albums
+type AlbumEventRepository interface {
+	// ListAlbumEvents returns every event of the album, oldest first.
+	// An album that never existed has none.
+	ListAlbumEvents(ctx context.Context, albumID string) ([]AlbumHistoryEvent, error)
+}
+
+type sqlAlbumEventRepository struct {
+	db DB
+}
+
+func newSQLAlbumEventRepository(db DB) *sqlAlbumEventRepository {
+	return &sqlAlbumEventRepository{db: db}
+}
+
+func (r *sqlAlbumEventRepository) ListAlbumEvents(ctx context.Context, albumID string) ([]AlbumHistoryEvent, error) {
+	var events []AlbumHistoryEvent
+	err := retryDB(ctx, dbRetryAttempts, func() error {
+		events = nil
+		// occurred_at is the start of the transaction; id orders events within one
+		return r.db.Select(ctx, &events, `
+			SELECT * FROM album_events
+			WHERE tenant_id = ? AND album_id = ?
+			ORDER BY occurred_at ASC, id ASC`,
+			tenantFromContext(ctx), albumID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// markChangedFields sets ChangedFields on each AlbumUpdated event of events,
+// which must be in order, by comparing its album with the one before it.
+// version and updated_at change every time and are left out.
+func markChangedFields(events []AlbumHistoryEvent) {
+	var prev *Album
+	for i := range events {
+		e := &events[i]
+		if e.Type == "AlbumUpdated" && prev != nil && e.Album != nil {
+			e.ChangedFields = changedAlbumFields(prev, e.Album)
+		}
+		prev = e.Album
+	}
+}
+
+// changedAlbumFields lists the JSON names of the stored fields that differ between a and b
+func changedAlbumFields(a, b *Album) []string {
+	changed := []string{}
+	for _, f := range []struct {
+		name string
+		diff bool
+	}{
+		{"title", a.Title != b.Title},
+		{"artist", a.Artist != b.Artist},
+		{"price", a.Price != b.Price},
+		{"currency", a.Currency != b.Currency},
+		{"cover_url", a.CoverURL != b.CoverURL},
+	} {
+		if f.diff {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
+
+// getAlbumHistory serves GET /albums/{id}/history: the album's events in the
+// order they happened, including those after it was deleted or re-created
+func (s *Server) getAlbumHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+
+	events, err := s.history.ListAlbumEvents(r.Context(), id)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if len(events) == 0 {
+		sendError(w, APIError{Code: ErrNotFound, Message: "album not found"}, http.StatusNotFound)
+		return
+	}
+	markChangedFields(events)
+	sendJSON(w, http.StatusOK, events)
+}
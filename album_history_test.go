@@ -0,0 +1,13 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetAlbumHistoryNotFound(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/42/history", nil, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== Artist Summary ==========
+
+// ArtistSummary is one artist's entry in GET /albums/by-artist. AvgPrice and
+// Currency are null when the artist's albums are priced in several
+// currencies, since those prices can't be averaged together.
+type ArtistSummary struct {
+	Artist   string   `json:"artist"`
+	Count    int      `json:"count"`
+	AvgPrice *float64 `json:"avg_price"`
+	Currency *string  `json:"currency"`
+}
+
+// parseArtistSort reads ?sort=: "artist" (the default) orders by name,
+// "count" puts the artists with the most albums first
+func parseArtistSort(r *http.Request) (byCount bool, fe *FieldError) {
+	switch v := r.URL.Query().Get("sort"); v {
+	case "", "artist":
+		return false, nil
+	case "count":
+		return true, nil
+	default:
+		return false, &FieldError{Field: "sort", Message: "sort must be artist or count"}
+	}
+}
+
+// getArtistSummaries serves GET /albums/by-artist: album count and average
+// price per artist, so clients don't have to group every album themselves
+func (s *Server) getArtistSummaries(w http.ResponseWriter, r *http.Request) {
+	byCount, fe := parseArtistSort(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	summaries, err := s.albums.ArtistSummaries(r.Context(), byCount)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if summaries == nil {
+		summaries = []ArtistSummary{}
+	}
+	sendJSON(w, http.StatusOK, summaries)
+}
+
+// ArtistSummaries groups the tenant's albums by artist in one aggregate
+// query. Artists are grouped by artist_normalized, so "Miles Davis" and
+// "miles davis " are one artist, shown under the first of their spellings.
+// Encrypted prices can't be averaged by the database, so while
+// ENCRYPTION_KEY is set the albums are grouped here instead.
+func (r *sqlAlbumRepository) ArtistSummaries(ctx context.Context, byCount bool) ([]ArtistSummary, error) {
+	return r.artistSummaries(ctx, "", byCount)
+}
+
+// ArtistSummary summarizes the albums of one artist, matched as ?artist= is
+func (r *sqlAlbumRepository) ArtistSummary(ctx context.Context, artist string) (ArtistSummary, error) {
+	summaries, err := r.artistSummaries(ctx, artist, false)
+	if err != nil {
+		return ArtistSummary{}, err
+	}
+	if len(summaries) == 0 {
+		return ArtistSummary{}, errArtistNotFound
+	}
+	return summaries[0], nil
+}
+
+// artistSummaries is ArtistSummaries narrowed to artist, unless it is ""
+func (r *sqlAlbumRepository) artistSummaries(ctx context.Context, artist string, byCount bool) (summaries []ArtistSummary, err error) {
+	if encryptionKey != nil {
+		return r.artistSummariesFromRows(ctx, artist, byCount)
+	}
+	err = retryDB(ctx, dbRetryAttempts, func() error {
+		summaries = nil
+		db := r.reader(ctx)
+		where := applyAlbumFilters(db.dialect(), albumWhere(ctx), AlbumListOptions{Artist: artist})
+		order := " ORDER BY artist"
+		if byCount {
+			order = " ORDER BY count DESC, artist"
+		}
+		return db.Select(ctx, &summaries, `
+			SELECT min(artist) AS artist,
+				count(*) AS count,
+				CASE WHEN count(DISTINCT currency) = 1 THEN round(avg(CAST(price AS NUMERIC)), 2) END AS avg_price,
+				CASE WHEN count(DISTINCT currency) = 1 THEN min(currency) END AS currency
+			FROM albums AS album`+where.String()+`
+			GROUP BY artist_normalized`+order,
+			where.args...)
+	})
+	return summaries, err
+}
+
+// artistSummariesFromRows is ArtistSummaries computed from every album's
+// decrypted price
+func (r *sqlAlbumRepository) artistSummariesFromRows(ctx context.Context, artist string, byCount bool) ([]ArtistSummary, error) {
+	var groups artistGroups
+	err := retryDB(ctx, dbRetryAttempts, func() error {
+		groups = make(artistGroups)
+		db := r.reader(ctx)
+		where := applyAlbumFilters(db.dialect(), albumWhere(ctx), AlbumListOptions{Artist: artist})
+		return db.Select(ctx, func(a *Album) error {
+			groups.add(a)
+			return nil
+		}, "SELECT artist, currency, price FROM albums AS album"+where.String(), where.args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups.summaries(byCount), nil
+}
+
+// artistGroups adds up albums by artist_normalized, for summaries that can't
+// be computed by the database
+type artistGroups map[string]*artistTotals
+
+type artistTotals struct {
+	ArtistSummary
+	sum        float64
+	currencies map[string]bool
+}
+
+func (g artistGroups) add(a *Album) {
+	key := normalizeArtist(a.Artist)
+	t, ok := g[key]
+	if !ok {
+		t = &artistTotals{ArtistSummary: ArtistSummary{Artist: a.Artist}, currencies: make(map[string]bool)}
+		g[key] = t
+	} else if a.Artist < t.Artist {
+		t.Artist = a.Artist // the lowest spelling, as min(artist) picks
+	}
+	t.Count++
+	t.sum += a.Price
+	t.currencies[a.Currency] = true
+}
+
+// summaries returns the groups in the order ArtistSummaries promises
+func (g artistGroups) summaries(byCount bool) []ArtistSummary {
+	summaries := make([]ArtistSummary, 0, len(g))
+	for _, t := range g {
+		if len(t.currencies) == 1 {
+			avg := math.Round(t.sum/float64(t.Count)*100) / 100
+			t.AvgPrice = &avg
+			for currency := range t.currencies {
+				t.Currency = &currency
+			}
+		}
+		summaries = append(summaries, t.ArtistSummary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if byCount && summaries[i].Count != summaries[j].Count {
+			return summaries[i].Count > summaries[j].Count
+		}
+		return summaries[i].Artist < summaries[j].Artist
+	})
+	return summaries
+}
+
+// ========== Albums by Artist ==========
+
+// albumSorts are the ?sort= values of GET /artists/{name}/albums and the
+// ORDER BY each one adds before id. A leading "-" sorts descending.
+var albumSorts = map[string]string{
+	"title":       "title ASC",
+	"-title":      "title DESC",
+	"price":       "CAST(price AS NUMERIC) ASC",
+	"-price":      "CAST(price AS NUMERIC) DESC",
+	"created_at":  "created_at ASC",
+	"-created_at": "created_at DESC",
+}
+
+// parseAlbumSort reads ?sort=, one of albumSorts. Without it albums come in id order.
+func parseAlbumSort(r *http.Request) (string, *FieldError) {
+	v := r.URL.Query().Get("sort")
+	if v == "" {
+		return "", nil
+	}
+	if _, ok := albumSorts[v]; !ok {
+		return "", &FieldError{Field: "sort", Message: "sort must be title, price or created_at, optionally prefixed with -"}
+	}
+	// price is text since migrations/010_encrypt_album_prices.sql, so the
+	// database can only order it while it holds plain numbers
+	if encryptionKey != nil && strings.TrimPrefix(v, "-") == "price" {
+		return "", &FieldError{Field: "sort", Message: "sorting by price is not available while prices are encrypted"}
+	}
+	return v, nil
+}
+
+// artistAlbums is the response of GET /artists/{name}/albums. Total counts
+// the albums matching the price filters, across all pages.
+type artistAlbums struct {
+	Artist ArtistSummary `json:"artist"`
+	Albums []Album       `json:"albums"`
+	Total  int           `json:"total"`
+}
+
+// getArtistAlbums serves GET /artists/{name}/albums: the artist's summary and
+// their albums, with ?limit=/?offset=, ?sort= and ?min_price=/?max_price=.
+// The name is matched as ?artist= is, and an artist without albums is a 404.
+func (s *Server) getArtistAlbums(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	// chi routes on the escaped path when there is one, as for "AC%2FDC"
+	if r.URL.RawPath != "" {
+		unescaped, err := url.PathUnescape(name)
+		if err != nil {
+			sendError(w, APIError{Code: ErrValidation, Message: "invalid artist name", Field: "name"}, http.StatusBadRequest)
+			return
+		}
+		name = unescaped
+	}
+	if strings.TrimSpace(name) == "" {
+		sendError(w, APIError{Code: ErrValidation, Message: "artist name must not be empty", Field: "name"}, http.StatusBadRequest)
+		return
+	}
+
+	page, fe := parsePage(r)
+	if fe == nil && page.cursor {
+		fe = &FieldError{Field: "after", Message: "after is not supported here, page with offset"}
+	}
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	sortBy, fe := parseAlbumSort(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	minPrice, maxPrice, fe := parsePriceRange(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+
+	artist, err := s.albums.ArtistSummary(r.Context(), name)
+	if err == errArtistNotFound {
+		sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+
+	opts := AlbumListOptions{
+		Page:     page,
+		Artist:   name,
+		MinPrice: minPrice,
+		MaxPrice: maxPrice,
+		Sort:     sortBy,
+	}
+	albums, err := s.albums.ListAlbums(r.Context(), opts)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	total, err := s.albums.CountAlbums(r.Context(), opts)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if page.limit > 0 {
+		setPageHeaders(w, r, page, total, "")
+	}
+	if albums == nil {
+		albums = []Album{}
+	}
+	sendJSON(w, http.StatusOK, artistAlbums{Artist: artist, Albums: albums, Total: total})
+}
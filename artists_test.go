@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestGetArtistAlbums(t *testing.T) {
+	srv, _ := newTestServer(append(testAlbums,
+		Album{ID: "4", Title: "Giant Steps", Artist: "john coltrane", Price: 24.99, Currency: "USD", Tags: []string{}},
+		Album{ID: "5", Title: "Back in Black", Artist: "AC/DC", Price: 19.99, Currency: "USD", Tags: []string{}},
+	)...)
+	r := chi.NewRouter()
+	r.Get("/artists/{name}/albums", srv.getArtistAlbums)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/artists/John%20Coltrane/albums?sort=-price&limit=1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var body artistAlbums
+	decodeJSON(t, rec, &body)
+	if body.Artist.Artist != "John Coltrane" || body.Artist.Count != 2 {
+		t.Errorf("artist = %+v, want John Coltrane with 2 albums", body.Artist)
+	}
+	if body.Total != 2 || len(body.Albums) != 1 || body.Albums[0].ID != "1" {
+		t.Errorf("total %d, albums %+v; want 2 in all and the most expensive, album 1", body.Total, body.Albums)
+	}
+	if got := rec.Header().Get("X-Total-Count"); got != "2" {
+		t.Errorf("X-Total-Count = %q, want 2", got)
+	}
+
+	rec = get("/artists/john%20coltrane/albums?max_price=30")
+	body = artistAlbums{}
+	decodeJSON(t, rec, &body)
+	if body.Total != 1 || len(body.Albums) != 1 || body.Albums[0].ID != "4" {
+		t.Errorf("?max_price=30: total %d, albums %+v; want only album 4", body.Total, body.Albums)
+	}
+
+	rec = get("/artists/AC%2FDC/albums")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("escaped slash: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	body = artistAlbums{}
+	decodeJSON(t, rec, &body)
+	if body.Artist.Artist != "AC/DC" || len(body.Albums) != 1 {
+		t.Errorf("escaped slash: got %+v", body)
+	}
+
+	if rec := get("/artists/Nobody/albums"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown artist: status %d, want 404", rec.Code)
+	}
+	for _, query := range []string{"sort=artist", "after=1", "min_price=-1"} {
+		if rec := get("/artists/John%20Coltrane/albums?" + query); rec.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+// With encrypted prices the summaries are added up in Go rather than by the database
+func TestArtistSummariesEncrypted(t *testing.T) {
+	withEncryptionKey(t, make([]byte, 32))
+	h, _ := newSQLiteTestRouter(t)
+	for _, a := range []Album{
+		{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 10, Currency: "USD"},
+		{ID: "2", Title: "Night Lights", Artist: "gerry mulligan", Price: 20, Currency: "USD"},
+		{ID: "3", Title: "Time Out", Artist: "Dave Brubeck", Price: 2200, Currency: "JPY"},
+		{ID: "4", Title: "Take Five", Artist: "Dave Brubeck", Price: 22, Currency: "USD"},
+		{ID: "5", Title: "Kind of Blue", Artist: "Miles Davis", Price: 30, Currency: "USD"},
+	} {
+		serve(t, h, http.MethodPost, "/v1/albums", a, nil, nil)
+	}
+
+	var summaries []ArtistSummary
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/by-artist?sort=count", nil, nil, &summaries); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(summaries) != 3 || summaries[0].Artist != "Dave Brubeck" || summaries[1].Artist != "Gerry Mulligan" || summaries[2].Artist != "Miles Davis" {
+		t.Fatalf("summaries %+v, want Brubeck and Mulligan by name, then Davis", summaries)
+	}
+	if s := summaries[1]; s.Count != 2 || s.AvgPrice == nil || *s.AvgPrice != 15 || *s.Currency != "USD" {
+		t.Errorf("Mulligan %+v, want 2 albums averaging 15 USD", s)
+	}
+	if s := summaries[0]; s.AvgPrice != nil || s.Currency != nil {
+		t.Errorf("Brubeck %+v, want no average across currencies", s)
+	}
+
+	var artist artistAlbums
+	if rec := serve(t, h, http.MethodGet, "/v1/artists/gerry%20mulligan/albums", nil, nil, &artist); rec.Code != http.StatusOK || artist.Artist.Count != 2 {
+		t.Errorf("artist albums: status %d, %+v", rec.Code, artist.Artist)
+	}
+	if rec := serve(t, h, http.MethodGet, "/v1/artists/gerry%20mulligan/albums?sort=price", nil, nil, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("sort by encrypted price: status %d, want 400", rec.Code)
+	}
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/by-artist?sort=price", nil, nil, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("sort=price: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ========== Audit Log ==========
+
+// Audit actions
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// anonymousActor is recorded as performed_by until requests carry an authenticated user
+const anonymousActor = "anonymous"
+
+// AuditLog is one row of audit_logs: who changed which record, and how
+type AuditLog struct {
+	tableName struct{} `pg:"audit_logs"`
+
+	ID          int64       `json:"id" pg:"id,pk"`
+	TenantID    string      `json:"-" pg:"tenant_id"`
+	Table       string      `json:"table_name" pg:"table_name"`
+	RecordID    string      `json:"record_id" pg:"record_id"`
+	Action      string      `json:"action" pg:"action"`
+	OldValue    interface{} `json:"old_value" pg:"old_value,type:jsonb"`
+	NewValue    interface{} `json:"new_value" pg:"new_value,type:jsonb"`
+	PerformedBy string      `json:"performed_by" pg:"performed_by"`
+	PerformedAt time.Time   `json:"performed_at" pg:"performed_at,default:now()"`
+}
+
+// auditAlbum is an album as written to old_value and new_value: with the
+// price in its stored form, so the log doesn't keep prices the table encrypts
+type auditAlbum struct {
+	*Album
+	Price string `json:"price"`
+}
+
+// encodeAlbumPrices stores album prices the way the albums table does
+func (l *AuditLog) encodeAlbumPrices() error {
+	for _, v := range []*interface{}{&l.OldValue, &l.NewValue} {
+		if a, ok := (*v).(*Album); ok {
+			stored, err := encodePrice(a.Price)
+			if err != nil {
+				return fmt.Errorf("encrypting price: %w", err)
+			}
+			*v = auditAlbum{Album: a, Price: stored}
+		}
+	}
+	return nil
+}
+
+// AfterScan turns stored prices back into numbers. Entries written before
+// prices were stored as text already hold a number and are left alone.
+func (l *AuditLog) AfterScan(ctx context.Context) error {
+	for _, v := range []interface{}{l.OldValue, l.NewValue} {
+		album, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if stored, ok := album["price"].(string); ok {
+			price, err := decodePrice(stored)
+			if err != nil {
+				return fmt.Errorf("audit log %d: %w", l.ID, err)
+			}
+			album["price"] = price
+		}
+	}
+	return nil
+}
+
+type actorKey struct{}
+
+// withActor records who is making the request, for audit_logs.performed_by
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFromContext returns the actor stored by withActor, or anonymousActor
+func actorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return anonymousActor
+}
+
+// newAlbumAudit builds the audit row for a change to an album. Pass nil for
+// the side that doesn't exist (before a create, after a delete).
+func newAlbumAudit(ctx context.Context, action, id string, before, after *Album) *AuditLog {
+	entry := &AuditLog{
+		TenantID:    tenantFromContext(ctx),
+		Table:       "albums",
+		RecordID:    id,
+		Action:      action,
+		PerformedBy: actorFromContext(ctx),
+	}
+	// Assign only non-nil pointers so a missing side is stored as SQL NULL
+	if before != nil {
+		entry.OldValue = before
+	}
+	if after != nil {
+		entry.NewValue = after
+	}
+	return entry
+}
+
+// insertAudit writes entries using db, which is normally the transaction of the change itself
+func insertAudit(ctx context.Context, db DB, entries ...*AuditLog) error {
+	for _, l := range entries {
+		if err := l.encodeAlbumPrices(); err != nil {
+			return err
+		}
+		_, err := db.Insert(ctx, nil, `
+			INSERT INTO audit_logs (tenant_id, table_name, record_id, action, old_value, new_value, performed_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			l.TenantID, l.Table, l.RecordID, l.Action, l.OldValue, l.NewValue, l.PerformedBy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuditLogRepository reads the audit trail
+type AuditLogRepository interface {
+	ListAuditLogs(ctx context.Context, recordID string, limit int) ([]AuditLog, error)
+	// PriceHistory returns the album's price changes, oldest first
+	PriceHistory(ctx context.Context, albumID string) ([]PriceChange, error)
+}
+
+type sqlAuditLogRepository struct {
+	db DB
+}
+
+func newSQLAuditLogRepository(db DB) *sqlAuditLogRepository {
+	return &sqlAuditLogRepository{db: db}
+}
+
+// ListAuditLogs returns the newest entries first, for one record when recordID is set
+func (r *sqlAuditLogRepository) ListAuditLogs(ctx context.Context, recordID string, limit int) ([]AuditLog, error) {
+	var logs []AuditLog
+	where := new(whereClause).and("tenant_id = ?", tenantFromContext(ctx))
+	if recordID != "" {
+		where.and("record_id = ?", recordID)
+	}
+	err := r.db.Select(ctx, &logs, "SELECT * FROM audit_logs"+where.String()+" ORDER BY performed_at DESC, id DESC LIMIT ?",
+		append(where.args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// getAuditLogs serves GET /admin/audit-logs?record_id=...&limit=...
+func (s *Server) getAuditLogs(w http.ResponseWriter, r *http.Request) {
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, fe := parseLimit(v)
+		if fe != nil {
+			sendError(w, fe.APIError(), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	logs, err := s.audit.ListAuditLogs(r.Context(), r.URL.Query().Get("record_id"), limit)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSON(w, http.StatusOK, logs)
+}
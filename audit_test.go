@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetAuditLogs(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	for _, id := range []string{"1", "2"} {
+		serve(t, h, http.MethodPost, "/v1/albums", Album{ID: id, Title: "Album " + id, Artist: "Artist", Price: 10}, nil, nil)
+	}
+	serve(t, h, http.MethodDelete, "/v1/albums/1", nil, nil, nil)
+
+	var logs []AuditLog
+	if rec := serve(t, h, http.MethodGet, "/v1/admin/audit-logs?record_id=1&limit=1", nil, admin, &logs); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(logs) != 1 || logs[0].RecordID != "1" {
+		t.Errorf("logs %+v, want the newest entry of album 1", logs)
+	}
+	if rec := serve(t, h, http.MethodGet, "/v1/admin/audit-logs?limit=0", nil, admin, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("?limit=0: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// benchAlbumRepository answers lists and lookups from a prepared slice without
+// filtering or copying, so the benchmarks measure the handlers alone
+type benchAlbumRepository struct {
+	*fakeAlbumRepository
+	list []Album
+}
+
+func (r *benchAlbumRepository) ListAlbums(ctx context.Context, opts AlbumListOptions) ([]Album, error) {
+	return r.list, nil
+}
+
+func (r *benchAlbumRepository) GetAlbumByID(ctx context.Context, id string, fields []string) (Album, error) {
+	return r.list[0], nil
+}
+
+func benchAlbums(n int) []Album {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	albums := make([]Album, n)
+	for i := range albums {
+		albums[i] = Album{
+			ID:        fmt.Sprintf("album-%04d", i),
+			Title:     fmt.Sprintf("Album %d", i),
+			Artist:    fmt.Sprintf("Artist %d", i%50),
+			Price:     float64(i%100) + 0.99,
+			Currency:  "USD",
+			Tags:      []string{"jazz", "vinyl"},
+			CreatedAt: created,
+			UpdatedAt: created,
+			Version:   1,
+		}
+	}
+	return albums
+}
+
+func BenchmarkGetAlbums(b *testing.B) {
+	for _, n := range []int{0, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("albums=%d", n), func(b *testing.B) {
+			srv := &Server{albums: &benchAlbumRepository{fakeAlbumRepository: newFakeAlbumRepository(), list: benchAlbums(n)}}
+			req := httptest.NewRequest(http.MethodGet, "/albums", nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rec := httptest.NewRecorder()
+				srv.getAlbums(rec, req)
+				if rec.Code != http.StatusOK {
+					b.Fatalf("status %d", rec.Code)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetAlbumByID(b *testing.B) {
+	srv := &Server{albums: &benchAlbumRepository{fakeAlbumRepository: newFakeAlbumRepository(), list: benchAlbums(1)}}
+	req := httptest.NewRequest(http.MethodGet, "/albums/album-0000", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		srv.getAlbumByID(rec, req, "album-0000")
+		if rec.Code != http.StatusOK {
+			b.Fatalf("status %d", rec.Code)
+		}
+	}
+}
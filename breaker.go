@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// ========== Database Circuit Breaker ==========
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerTimeout  = 30 * time.Second
+)
+
+// errDatabaseUnavailable is returned without touching the database while the circuit is open
+var errDatabaseUnavailable = errors.New("database temporarily unavailable")
+
+// clientCausedError carries an error caused by the client, through its
+// X-Request-Timeout or by disconnecting, past the breaker, which must not
+// count it: a client asking for 1ms, or hanging up, is no sign of a
+// struggling database. PostgreSQL reports the query it cancelled as 57014,
+// not as context.Canceled, so the request context tells them apart.
+type clientCausedError struct {
+	err error
+}
+
+func (e *clientCausedError) Error() string { return e.err.Error() }
+func (e *clientCausedError) Unwrap() error { return e.err }
+
+// loadBreakerSettings reads DB_BREAKER_FAILURES (consecutive failures that open
+// the circuit) and DB_BREAKER_TIMEOUT_SECONDS (how long it stays open)
+func loadBreakerSettings() gobreaker.Settings {
+	failures := defaultBreakerFailures
+	if v := os.Getenv("DB_BREAKER_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("DB_BREAKER_FAILURES must be a positive integer, got %q", v)
+		}
+		failures = n
+	}
+
+	timeout := defaultBreakerTimeout
+	if v := os.Getenv("DB_BREAKER_TIMEOUT_SECONDS"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			log.Fatalf("DB_BREAKER_TIMEOUT_SECONDS must be a positive integer, got %q", v)
+		}
+		timeout = time.Duration(secs) * time.Second
+	}
+
+	return gobreaker.Settings{
+		Name:    "database",
+		Timeout: timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= uint32(failures)
+		},
+		// Only database trouble counts against the circuit, not answers like "not found"
+		// or a client that went away
+		IsSuccessful: func(err error) bool {
+			return err == nil ||
+				errors.Is(err, errAlbumNotFound) ||
+				errors.Is(err, errArtistNotFound) ||
+				errors.Is(err, errAlbumExists) ||
+				errors.Is(err, errVersionConflict) ||
+				errors.Is(err, errTagNotFound) ||
+				errors.Is(err, errReviewNotFound) ||
+				errors.Is(err, context.Canceled) ||
+				errors.As(err, new(*clientCausedError))
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			log.Printf("Circuit breaker %q changed from %s to %s", name, from, to)
+		},
+	}
+}
+
+// breakerAlbumRepository fails fast with errDatabaseUnavailable once the
+// wrapped repository keeps failing, instead of letting every request wait for
+// its own timeout against an overloaded database. It does the same without
+// trying while monitor reports the database down; a nil monitor never does.
+type breakerAlbumRepository struct {
+	next    AlbumRepository
+	cb      *gobreaker.CircuitBreaker
+	monitor *dbMonitor
+}
+
+func newBreakerAlbumRepository(next AlbumRepository, settings gobreaker.Settings, monitor *dbMonitor) *breakerAlbumRepository {
+	return &breakerAlbumRepository{next: next, cb: gobreaker.NewCircuitBreaker(settings), monitor: monitor}
+}
+
+// call runs fn through the circuit breaker
+func (r *breakerAlbumRepository) call(ctx context.Context, fn func() error) error {
+	if !r.monitor.Healthy() {
+		return errDatabaseUnavailable
+	}
+	_, err := r.cb.Execute(func() (interface{}, error) {
+		err := fn()
+		if err != nil && (clientDeadlineExceeded(ctx) || clientDisconnected(ctx)) {
+			return nil, &clientCausedError{err: err}
+		}
+		return nil, err
+	})
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return errDatabaseUnavailable
+	}
+	var clientErr *clientCausedError
+	if errors.As(err, &clientErr) {
+		return clientErr.err
+	}
+	return err
+}
+
+func (r *breakerAlbumRepository) ListAlbums(ctx context.Context, opts AlbumListOptions) (albums []Album, err error) {
+	err = r.call(ctx, func() error {
+		albums, err = r.next.ListAlbums(ctx, opts)
+		return err
+	})
+	return albums, err
+}
+
+func (r *breakerAlbumRepository) StreamAlbums(ctx context.Context, opts AlbumListOptions, fn func(Album) error) error {
+	// An error from fn is the client's side of the stream, not the database's
+	var fnErr error
+	err := r.call(ctx, func() error {
+		err := r.next.StreamAlbums(ctx, opts, func(a Album) error {
+			fnErr = fn(a)
+			return fnErr
+		})
+		if fnErr != nil {
+			return nil
+		}
+		return err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (r *breakerAlbumRepository) CountAlbums(ctx context.Context, opts AlbumListOptions) (count int, err error) {
+	err = r.call(ctx, func() error {
+		count, err = r.next.CountAlbums(ctx, opts)
+		return err
+	})
+	return count, err
+}
+
+func (r *breakerAlbumRepository) RandomAlbum(ctx context.Context, opts AlbumListOptions) (album Album, err error) {
+	err = r.call(ctx, func() error {
+		album, err = r.next.RandomAlbum(ctx, opts)
+		return err
+	})
+	return album, err
+}
+
+func (r *breakerAlbumRepository) TopAlbums(ctx context.Context, byReviews bool, limit int) (albums []Album, err error) {
+	err = r.call(ctx, func() error {
+		albums, err = r.next.TopAlbums(ctx, byReviews, limit)
+		return err
+	})
+	return albums, err
+}
+
+func (r *breakerAlbumRepository) ArtistSummaries(ctx context.Context, byCount bool) (summaries []ArtistSummary, err error) {
+	err = r.call(ctx, func() error {
+		summaries, err = r.next.ArtistSummaries(ctx, byCount)
+		return err
+	})
+	return summaries, err
+}
+
+func (r *breakerAlbumRepository) ArtistSummary(ctx context.Context, artist string) (summary ArtistSummary, err error) {
+	err = r.call(ctx, func() error {
+		summary, err = r.next.ArtistSummary(ctx, artist)
+		return err
+	})
+	return summary, err
+}
+
+func (r *breakerAlbumRepository) GetAlbumByID(ctx context.Context, id string, fields []string) (album Album, err error) {
+	err = r.call(ctx, func() error {
+		album, err = r.next.GetAlbumByID(ctx, id, fields)
+		return err
+	})
+	return album, err
+}
+
+func (r *breakerAlbumRepository) AlbumExists(ctx context.Context, id string) (exists bool, err error) {
+	err = r.call(ctx, func() error {
+		exists, err = r.next.AlbumExists(ctx, id)
+		return err
+	})
+	return exists, err
+}
+
+func (r *breakerAlbumRepository) CreateAlbum(ctx context.Context, album *Album) error {
+	return r.call(ctx, func() error {
+		return r.next.CreateAlbum(ctx, album)
+	})
+}
+
+func (r *breakerAlbumRepository) UpdateAlbum(ctx context.Context, album *Album) error {
+	return r.call(ctx, func() error {
+		return r.next.UpdateAlbum(ctx, album)
+	})
+}
+
+func (r *breakerAlbumRepository) DeleteAlbum(ctx context.Context, id string) error {
+	return r.call(ctx, func() error {
+		return r.next.DeleteAlbum(ctx, id)
+	})
+}
+
+func (r *breakerAlbumRepository) DeleteAlbums(ctx context.Context, ids []string) (deleted []string, err error) {
+	err = r.call(ctx, func() error {
+		deleted, err = r.next.DeleteAlbums(ctx, ids)
+		return err
+	})
+	return deleted, err
+}
+
+func (r *breakerAlbumRepository) DuplicateAlbum(ctx context.Context, id, newID, title string) (album Album, err error) {
+	err = r.call(ctx, func() error {
+		album, err = r.next.DuplicateAlbum(ctx, id, newID, title)
+		return err
+	})
+	return album, err
+}
+
+func (r *breakerAlbumRepository) MultiplyArtistPrices(ctx context.Context, artist string, multiplier float64) (updated []string, err error) {
+	err = r.call(ctx, func() error {
+		updated, err = r.next.MultiplyArtistPrices(ctx, artist, multiplier)
+		return err
+	})
+	return updated, err
+}
+
+func (r *breakerAlbumRepository) ImportAlbums(ctx context.Context, albums []Album, dryRun bool) (existing map[string]bool, err error) {
+	err = r.call(ctx, func() error {
+		existing, err = r.next.ImportAlbums(ctx, albums, dryRun)
+		return err
+	})
+	return existing, err
+}
+
+func (r *breakerAlbumRepository) AddAlbumTags(ctx context.Context, id string, tags []string) (all []string, err error) {
+	err = r.call(ctx, func() error {
+		all, err = r.next.AddAlbumTags(ctx, id, tags)
+		return err
+	})
+	return all, err
+}
+
+func (r *breakerAlbumRepository) RemoveAlbumTag(ctx context.Context, id, tag string) error {
+	return r.call(ctx, func() error {
+		return r.next.RemoveAlbumTag(ctx, id, tag)
+	})
+}
+
+func (r *breakerAlbumRepository) ListReviews(ctx context.Context, albumID string, page pageParams) (reviews []Review, total int, err error) {
+	err = r.call(ctx, func() error {
+		reviews, total, err = r.next.ListReviews(ctx, albumID, page)
+		return err
+	})
+	return reviews, total, err
+}
+
+func (r *breakerAlbumRepository) CreateReview(ctx context.Context, review *Review) error {
+	return r.call(ctx, func() error {
+		return r.next.CreateReview(ctx, review)
+	})
+}
+
+func (r *breakerAlbumRepository) DeleteReview(ctx context.Context, albumID string, id int64) error {
+	return r.call(ctx, func() error {
+		return r.next.DeleteReview(ctx, albumID, id)
+	})
+}
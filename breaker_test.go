@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensOnDatabaseErrors(t *testing.T) {
+	t.Setenv("DB_BREAKER_FAILURES", "2")
+	t.Setenv("DB_BREAKER_TIMEOUT_SECONDS", "60")
+	fake := newFakeAlbumRepository(testAlbums...)
+	repo := newBreakerAlbumRepository(fake, loadBreakerSettings(), nil)
+	ctx := context.Background()
+
+	// Answers about the data don't count against the database
+	for i := 0; i < 3; i++ {
+		if _, err := repo.GetAlbumByID(ctx, "42", nil); err != errAlbumNotFound {
+			t.Fatalf("missing album: %v, want errAlbumNotFound", err)
+		}
+	}
+
+	down := errors.New("connection refused")
+	fake.err = down
+	for i := 0; i < 2; i++ {
+		if _, err := repo.ListAlbums(ctx, AlbumListOptions{}); err != down {
+			t.Fatalf("failure %d: %v, want the database's error", i+1, err)
+		}
+	}
+	fake.err = nil
+	if _, err := repo.ListAlbums(ctx, AlbumListOptions{}); err != errDatabaseUnavailable {
+		t.Errorf("with the circuit open: %v, want errDatabaseUnavailable", err)
+	}
+}
+
+// A client that gives up early is no sign of a struggling database
+func TestBreakerIgnoresClientTimeouts(t *testing.T) {
+	t.Setenv("DB_BREAKER_FAILURES", "1")
+	fake := newFakeAlbumRepository(testAlbums...)
+	repo := newBreakerAlbumRepository(fake, loadBreakerSettings(), nil)
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), clientDeadlineKey{}, true), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	fake.err = ctx.Err()
+	for i := 0; i < 3; i++ {
+		if _, err := repo.CountAlbums(ctx, AlbumListOptions{}); err != context.DeadlineExceeded {
+			t.Fatalf("call %d: %v, want the client's deadline", i+1, err)
+		}
+	}
+	fake.err = nil
+	if _, err := repo.CountAlbums(context.Background(), AlbumListOptions{}); err != nil {
+		t.Errorf("after client timeouts: %v, want the circuit closed", err)
+	}
+}
+
+func TestBreakerFailsFastWhileDatabaseDown(t *testing.T) {
+	monitor := newDBMonitor(nil, 1)
+	repo := newBreakerAlbumRepository(newFakeAlbumRepository(testAlbums...), loadBreakerSettings(), monitor)
+	monitor.dbHealthy.Store(false)
+	if _, err := repo.GetAlbumByID(context.Background(), "1", nil); err != errDatabaseUnavailable {
+		t.Errorf("%v, want errDatabaseUnavailable while the monitor reports the database down", err)
+	}
+}
+
+// An error of the stream's consumer is returned as is, and isn't the database's
+func TestBreakerStreamAlbums(t *testing.T) {
+	t.Setenv("DB_BREAKER_FAILURES", "1")
+	repo := newBreakerAlbumRepository(newFakeAlbumRepository(testAlbums...), loadBreakerSettings(), nil)
+	ctx := context.Background()
+
+	gone := errors.New("client gone")
+	for i := 0; i < 2; i++ {
+		if err := repo.StreamAlbums(ctx, AlbumListOptions{}, func(Album) error { return gone }); err != gone {
+			t.Fatalf("stream %d: %v, want the consumer's error", i+1, err)
+		}
+	}
+	n := 0
+	if err := repo.StreamAlbums(ctx, AlbumListOptions{}, func(Album) error { n++; return nil }); err != nil || n != len(testAlbums) {
+		t.Errorf("streamed %d albums, %v; want all of them", n, err)
+	}
+}
+
+// Every method passes through to the wrapped repository
+func TestBreakerPassesCallsOn(t *testing.T) {
+	t.Setenv("DB_BREAKER_FAILURES", "100")
+	fake := newFakeAlbumRepository(testAlbums...)
+	repo := newBreakerAlbumRepository(fake, loadBreakerSettings(), nil)
+	ctx := context.Background()
+
+	if a, err := repo.RandomAlbum(ctx, AlbumListOptions{Artist: "jeru"}); err != errAlbumNotFound {
+		t.Errorf("RandomAlbum: %+v, %v", a, err)
+	}
+	if s, err := repo.ArtistSummary(ctx, "John Coltrane"); err != nil || s.Count != 1 {
+		t.Errorf("ArtistSummary: %+v, %v", s, err)
+	}
+	if exists, err := repo.AlbumExists(ctx, "1"); err != nil || !exists {
+		t.Errorf("AlbumExists: %v, %v", exists, err)
+	}
+	a := Album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 24.99}
+	if err := repo.CreateAlbum(ctx, &a); err != nil {
+		t.Errorf("CreateAlbum: %v", err)
+	}
+	if err := repo.UpdateAlbum(ctx, &a); err != nil || a.Version != 2 {
+		t.Errorf("UpdateAlbum: version %d, %v", a.Version, err)
+	}
+	review := Review{AlbumID: "4", AuthorName: "Ann", Rating: 5}
+	if err := repo.CreateReview(ctx, &review); err != nil {
+		t.Errorf("CreateReview: %v", err)
+	}
+	if reviews, total, err := repo.ListReviews(ctx, "4", pageParams{}); err != nil || total != 1 || len(reviews) != 1 {
+		t.Errorf("ListReviews: %+v of %d, %v", reviews, total, err)
+	}
+	if err := repo.DeleteReview(ctx, "4", review.ID); err != nil {
+		t.Errorf("DeleteReview: %v", err)
+	}
+	if err := repo.DeleteAlbum(ctx, "4"); err != nil {
+		t.Errorf("DeleteAlbum: %v", err)
+	}
+	if deleted, err := repo.DeleteAlbums(ctx, []string{"1", "2"}); err != nil || len(deleted) != 2 {
+		t.Errorf("DeleteAlbums: %v, %v", deleted, err)
+	}
+
+	// The fake doesn't implement these, which the breaker must pass on all the same
+	for name, call := range map[string]func() error{
+		"TopAlbums":            func() error { _, err := repo.TopAlbums(ctx, false, 10); return err },
+		"ArtistSummaries":      func() error { _, err := repo.ArtistSummaries(ctx, false); return err },
+		"DuplicateAlbum":       func() error { _, err := repo.DuplicateAlbum(ctx, "3", "5", ""); return err },
+		"MultiplyArtistPrices": func() error { _, err := repo.MultiplyArtistPrices(ctx, "a", 2); return err },
+		"ImportAlbums":         func() error { _, err := repo.ImportAlbums(ctx, nil, true); return err },
+		"AddAlbumTags":         func() error { _, err := repo.AddAlbumTags(ctx, "3", []string{"jazz"}); return err },
+		"RemoveAlbumTag":       func() error { return repo.RemoveAlbumTag(ctx, "3", "jazz") },
+	} {
+		if err := call(); err != errNotFaked {
+			t.Errorf("%s: %v, want the fake's error", name, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// ========== Bulk Price Update ==========
+
+type bulkUpdateRequest struct {
+	Artist          string   `json:"artist"`
+	PriceMultiplier *float64 `json:"price_multiplier"`
+}
+
+// bulkUpdateAlbums serves POST /albums/bulk-update, which multiplies the price
+// of every album by an artist, e.g. by 0.9 for a 10% sale. It answers with
+// the number of albums changed.
+func (s *Server) bulkUpdateAlbums(w http.ResponseWriter, r *http.Request) {
+	var req bulkUpdateRequest
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+	var errs ValidationErrors
+	req.Artist = strings.TrimSpace(req.Artist)
+	if req.Artist == "" {
+		errs.Add("artist", "artist is required")
+	}
+	if m := req.PriceMultiplier; m == nil || *m <= 0 || math.IsInf(*m, 0) {
+		errs.Add("price_multiplier", "price_multiplier must be a positive number")
+	}
+	if errs != nil {
+		sendError(w, errs.APIError(), http.StatusUnprocessableEntity)
+		return
+	}
+	// Encrypted prices can't be multiplied by the database
+	if encryptionKey != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "price updates are not available while prices are encrypted", Field: "price_multiplier"}, http.StatusBadRequest)
+		return
+	}
+
+	updated, err := s.albums.MultiplyArtistPrices(r.Context(), req.Artist, *req.PriceMultiplier)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if len(updated) > 0 {
+		s.listCache.invalidate()
+	}
+	sendJSON(w, http.StatusOK, map[string]int{"updated": len(updated)})
+}
+
+// MultiplyArtistPrices multiplies the prices of the albums by artist (matched
+// like ?artist=) in a single UPDATE, rounding to cents, and bumps their
+// versions. The albums are audited like any other update. It returns the ids
+// of the albums it changed. Prices must not be encrypted.
+func (r *sqlAlbumRepository) MultiplyArtistPrices(ctx context.Context, artist string, multiplier float64) ([]string, error) {
+	tenant := tenantFromContext(ctx)
+	artist = normalizeArtist(artist)
+
+	var after []Album
+	err := r.db.RunInTransaction(ctx, func(tx DB) error {
+		d := tx.dialect()
+		// The rows as they were, for the audit log; locked so they match what the update changes
+		var before []Album
+		where := albumWhere(ctx).and("artist_normalized = ?", artist)
+		if err := tx.Select(ctx, &before, selectAlbumsSQL+where.String()+d.forUpdate, where.args...); err != nil {
+			return err
+		}
+		if len(before) == 0 {
+			return nil
+		}
+
+		_, err := tx.Update(ctx, &after, `
+			UPDATE albums
+			SET price = CAST(CAST(ROUND(CAST(price AS NUMERIC) * ?, 2) AS DOUBLE PRECISION) AS TEXT),
+				version = version + 1,
+				updated_at = `+d.now+`
+			WHERE tenant_id = ? AND artist_normalized = ?
+			RETURNING *`,
+			multiplier, tenant, artist)
+		if err != nil {
+			return err
+		}
+
+		previous := make(map[string]*Album, len(before))
+		for i := range before {
+			previous[before[i].ID] = &before[i]
+		}
+		entries := make([]*AuditLog, len(after))
+		for i := range after {
+			entries[i] = newAlbumAudit(ctx, auditUpdate, after[i].ID, previous[after[i].ID], &after[i])
+		}
+		return insertAudit(ctx, tx, entries...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(after))
+	for i := range after {
+		ids[i] = after[i].ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBulkUpdateAlbums(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	for _, a := range []Album{
+		{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+		{ID: "2", Title: "Night Lights", Artist: "gerry  mulligan", Price: 10},
+		{ID: "3", Title: "Time Out", Artist: "Dave Brubeck", Price: 22},
+	} {
+		serve(t, h, http.MethodPost, "/v1/albums", a, nil, nil)
+	}
+
+	var body map[string]int
+	req := map[string]interface{}{"artist": "Gerry Mulligan", "price_multiplier": 0.9}
+	if rec := serve(t, h, http.MethodPost, "/v1/albums/bulk-update", req, nil, &body); rec.Code != http.StatusOK || body["updated"] != 2 {
+		t.Fatalf("status %d, %v; want 2 updated", rec.Code, body)
+	}
+	var albums []Album
+	serve(t, h, http.MethodGet, "/v1/albums", nil, nil, &albums)
+	if albums[0].Price != 16.19 || albums[1].Price != 9 || albums[2].Price != 22 {
+		t.Errorf("prices %v, %v, %v; want 16.19, 9 and 22 untouched", albums[0].Price, albums[1].Price, albums[2].Price)
+	}
+
+	for _, tt := range []struct {
+		body   interface{}
+		status int
+	}{
+		{"not an object", http.StatusBadRequest},
+		{map[string]interface{}{"artist": " ", "price_multiplier": 2}, http.StatusUnprocessableEntity},
+		{map[string]interface{}{"artist": "Dave Brubeck", "price_multiplier": 0}, http.StatusUnprocessableEntity},
+		{map[string]interface{}{"artist": "Dave Brubeck"}, http.StatusBadRequest},
+	} {
+		if rec := serve(t, h, http.MethodPost, "/v1/albums/bulk-update", tt.body, nil, nil); rec.Code != tt.status {
+			t.Errorf("%v: status %d, want %d: %s", tt.body, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	saved := encryptionKey
+	encryptionKey = make([]byte, 32)
+	defer func() { encryptionKey = saved }()
+	if rec := serve(t, h, http.MethodPost, "/v1/albums/bulk-update", req, nil, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("with encrypted prices: status %d, want 400", rec.Code)
+	}
+}
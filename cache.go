@@ -0,0 +1,119 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ========== Album List Cache ==========
+
+const (
+	defaultCacheTTL      = 30 * time.Second
+	albumListCacheSize   = 100
+	staleResponseWarning = `110 - "Response is Stale"`
+)
+
+// albumListCache keeps the last successful GET /albums results, keyed by query
+// string, so they can be served while the database is unreachable.
+// Entries older than ttl are never served. A nil cache is valid and caches nothing.
+type albumListCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+}
+
+type albumListCacheEntry struct {
+	key      string
+	albums   []Album
+	storedAt time.Time
+}
+
+func newAlbumListCache(capacity int, ttl time.Duration) *albumListCache {
+	return &albumListCache{
+		ttl:      ttl,
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// loadAlbumListCache builds the cache from CACHE_ENABLED and CACHE_TTL_SECONDS.
+// It returns nil when caching is disabled, which is the default.
+func loadAlbumListCache() *albumListCache {
+	enabled, _ := strconv.ParseBool(os.Getenv("CACHE_ENABLED"))
+	if !enabled {
+		return nil
+	}
+
+	ttl := defaultCacheTTL
+	if v := os.Getenv("CACHE_TTL_SECONDS"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			log.Fatalf("CACHE_TTL_SECONDS must be a positive integer, got %q", v)
+		}
+		ttl = time.Duration(secs) * time.Second
+	}
+	return newAlbumListCache(albumListCacheSize, ttl)
+}
+
+func (c *albumListCache) get(key string) ([]Album, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*albumListCacheEntry)
+	if time.Since(entry.storedAt) > c.ttl {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.albums, true
+}
+
+func (c *albumListCache) put(key string, albums []Album) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*albumListCacheEntry)
+		entry.albums = albums
+		entry.storedAt = time.Now()
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&albumListCacheEntry{key: key, albums: albums, storedAt: time.Now()})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*albumListCacheEntry).key)
+	}
+}
+
+// invalidate drops every entry; call it after any successful write
+func (c *albumListCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlbumListCache(t *testing.T) {
+	c := newAlbumListCache(2, time.Minute)
+	c.put("a", testAlbums[:1])
+	c.put("b", testAlbums[:2])
+	c.put("a", testAlbums[:3]) // replaces the entry and makes b the oldest
+	c.put("c", nil)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b is still cached past the capacity")
+	}
+	if albums, ok := c.get("a"); !ok || len(albums) != 3 {
+		t.Errorf("a = %d albums, %v; want the 3 put last", len(albums), ok)
+	}
+
+	c.invalidate()
+	if _, ok := c.get("a"); ok {
+		t.Error("a is still cached after invalidate")
+	}
+
+	c = newAlbumListCache(2, time.Nanosecond)
+	c.put("a", testAlbums)
+	time.Sleep(time.Millisecond)
+	if _, ok := c.get("a"); ok {
+		t.Error("an expired entry was served")
+	}
+
+	var none *albumListCache
+	none.put("a", testAlbums)
+	none.invalidate()
+	if _, ok := none.get("a"); ok {
+		t.Error("a nil cache served an entry")
+	}
+}
+
+func TestLoadAlbumListCache(t *testing.T) {
+	if c := loadAlbumListCache(); c != nil {
+		t.Error("cache enabled by default")
+	}
+	t.Setenv("CACHE_ENABLED", "true")
+	t.Setenv("CACHE_TTL_SECONDS", "5")
+	if c := loadAlbumListCache(); c == nil || c.ttl != 5*time.Second {
+		t.Errorf("got %+v, want a cache with a 5s TTL", c)
+	}
+}
+
+// While the database is down, GET /albums answers with the last good result
+func TestGetAlbumsServesStaleCache(t *testing.T) {
+	srv, repo := newTestServer(testAlbums...)
+	srv.listCache = newAlbumListCache(albumListCacheSize, time.Minute)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?limit=2", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Total-Count") != "3" {
+		t.Fatalf("status %d, X-Total-Count %q", rec.Code, rec.Header().Get("X-Total-Count"))
+	}
+
+	repo.err = errDatabaseUnavailable
+	rec = httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?limit=2", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Warning") != staleResponseWarning {
+		t.Fatalf("status %d, Warning %q; want the cached albums", rec.Code, rec.Header().Get("Warning"))
+	}
+	if rec.Header().Get("X-Total-Count") != "" {
+		t.Error("stale response with pagination headers")
+	}
+	var albums []Album
+	decodeJSON(t, rec, &albums)
+	if len(albums) != 2 {
+		t.Errorf("got %d albums, want the 2 cached", len(albums))
+	}
+
+	rec = httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?limit=1", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("uncached query: status %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+)
+
+// ========== Command Line ==========
+
+// newRootCommand builds the command tree. Without a sub-command the binary
+// serves the API, as it always has.
+func newRootCommand() *cobra.Command {
+	var configPath string
+	root := &cobra.Command{
+		Use:          "web-service",
+		Short:        "Album API server and admin tools",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		// Settings come from the environment, then .env, then the config file
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := godotenv.Load(); err != nil {
+				if !os.IsNotExist(err) {
+					return fmt.Errorf("reading .env: %w", err)
+				}
+				log.Println("Warning: No .env file found")
+			}
+			if path := configFilePath(configPath); path != "" {
+				cfg, err := loadConfigFile(path)
+				if err != nil {
+					return err
+				}
+				loadedConfig = cfg
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			runServer()
+		},
+	}
+	root.PersistentFlags().StringVar(&configPath, "config", "", "YAML configuration file (default $CONFIG_FILE, or config.yaml if it exists)")
+	root.AddCommand(newServeCommand(), newMigrateCommand(), newSeedCommand(), newAdminCommand())
+	return root
+}
+
+func newServeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Start the HTTP server on :8080",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runServer()
+		},
+	}
+}
+
+func newMigrateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply the database migrations that haven't run yet",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dbDriver() == driverSQLite {
+				return errors.New("migrations are for PostgreSQL; with DB_DRIVER=sqlite3 the tables are created when the server opens SQLITE_PATH")
+			}
+			db := openDB()
+			defer db.Close()
+
+			ran, err := runMigrations(cmd.Context(), db)
+			if err != nil {
+				return err
+			}
+			if len(ran) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "Database is up to date")
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Applied %d migrations\n", len(ran))
+			return nil
+		},
+	}
+}
+
+func newSeedCommand() *cobra.Command {
+	var tenant string
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Insert sample albums for development",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db := connectPrimaryDB()
+			defer db.Close()
+			loadAlbumIDFormat()
+			loadEncryptionKey()
+
+			added, err := seedAlbums(cmd.Context(), newSQLAlbumRepository(db, nil), tenant)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Added %d of %d sample albums to tenant %q\n", added, len(sampleAlbums), tenant)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&tenant, "tenant", defaultTenant, "tenant to add the albums to")
+	return cmd
+}
+
+func newAdminCommand() *cobra.Command {
+	admin := &cobra.Command{
+		Use:   "admin",
+		Short: "Operational tasks",
+	}
+	admin.AddCommand(&cobra.Command{
+		Use:   "reset-cache",
+		Short: "Remove every album from the Redis cache",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rdb := connectRedis()
+			if rdb == nil {
+				return errors.New("REDIS_URL is not set, so there is no shared cache to reset; the in-memory cache is cleared by restarting the server")
+			}
+			defer rdb.Close()
+
+			removed, err := flushAlbumCache(cmd.Context(), rdb)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Removed %d cached albums\n", removed)
+			return nil
+		},
+	})
+	admin.AddCommand(&cobra.Command{
+		Use:   "encrypt-prices",
+		Short: "Encrypt album prices still stored in plain text with ENCRYPTION_KEY",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db := connectPrimaryDB()
+			defer db.Close()
+			loadEncryptionKey()
+
+			changed, err := encryptStoredPrices(cmd.Context(), db)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Encrypted %d album prices\n", changed)
+			return nil
+		},
+	})
+	return admin
+}
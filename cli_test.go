@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCommand runs the command line with args and returns what it printed
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	cmd := newRootCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestCommandsOnSQLite(t *testing.T) {
+	withEncryptionKey(t, nil)
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("DB_DRIVER", "sqlite3")
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "albums.db"))
+	t.Setenv("ENCRYPTION_KEY", "")
+
+	out, err := runCommand(t, "seed")
+	if err != nil || !strings.Contains(out, "Added 6 of 6 sample albums") {
+		t.Fatalf("seed: %q, %v", out, err)
+	}
+	if out, _ := runCommand(t, "seed"); !strings.Contains(out, "Added 0 of 6") {
+		t.Errorf("second seed: %q", out)
+	}
+	if out, _ := runCommand(t, "seed", "--tenant", "acme"); !strings.Contains(out, `Added 6 of 6 sample albums to tenant "acme"`) {
+		t.Errorf("seed of another tenant: %q", out)
+	}
+
+	if _, err := runCommand(t, "admin", "encrypt-prices"); err == nil {
+		t.Error("encrypt-prices ran without ENCRYPTION_KEY")
+	}
+	t.Setenv("ENCRYPTION_KEY", strings.Repeat("0", 64))
+	if out, err := runCommand(t, "admin", "encrypt-prices"); err != nil || !strings.Contains(out, "Encrypted 12 album prices") {
+		t.Errorf("encrypt-prices: %q, %v", out, err)
+	}
+
+	if _, err := runCommand(t, "migrate"); err == nil || !strings.Contains(err.Error(), "PostgreSQL") {
+		t.Errorf("migrate on SQLite: %v", err)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("REDIS_URL", "")
+	if _, err := runCommand(t, "admin", "reset-cache"); err == nil || !strings.Contains(err.Error(), "REDIS_URL") {
+		t.Errorf("reset-cache without Redis: %v", err)
+	}
+	if _, err := runCommand(t, "--config", filepath.Join(t.TempDir(), "missing.yaml"), "admin", "reset-cache"); err == nil {
+		t.Error("ran with a config file that doesn't exist")
+	}
+	if _, err := runCommand(t, "serve", "extra"); err == nil {
+		t.Error("serve accepted an argument")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ========== Album Comparison ==========
+
+const (
+	minCompareIDs = 2
+	maxCompareIDs = 5
+)
+
+// albumComparison is the response of GET /albums/compare. CheapestID and
+// MostExpensiveID are null when no album was found or the albums are priced
+// in several currencies, since those prices can't be compared.
+type albumComparison struct {
+	Albums          []Album  `json:"albums"`
+	CheapestID      *string  `json:"cheapest_id"`
+	MostExpensiveID *string  `json:"most_expensive_id"`
+	NotFound        []string `json:"not_found,omitempty"`
+}
+
+// compareAlbums serves GET /albums/compare?ids=a,b: two to five albums side
+// by side, in the order asked for, fetched in one query. Unknown ids are
+// listed in not_found instead of failing the request.
+func (s *Server) compareAlbums(w http.ResponseWriter, r *http.Request) {
+	ids, fe := parseIDs(r)
+	if fe == nil && (len(ids) < minCompareIDs || len(ids) > maxCompareIDs) {
+		fe = &FieldError{Field: "ids", Message: "ids must name between " + strconv.Itoa(minCompareIDs) + " and " + strconv.Itoa(maxCompareIDs) + " albums"}
+	}
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	for _, id := range ids {
+		if !validAlbumID(id) {
+			sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format: " + id, Field: "ids"}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	found, err := s.albums.ListAlbums(r.Context(), AlbumListOptions{IDs: ids})
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	byID := make(map[string]Album, len(found))
+	for _, a := range found {
+		byID[a.ID] = a
+	}
+
+	cmp := albumComparison{Albums: []Album{}}
+	for _, id := range ids {
+		if a, ok := byID[id]; ok {
+			cmp.Albums = append(cmp.Albums, a)
+		} else {
+			cmp.NotFound = append(cmp.NotFound, id)
+		}
+	}
+	cmp.CheapestID, cmp.MostExpensiveID = priceExtremes(cmp.Albums)
+	sendJSON(w, http.StatusOK, cmp)
+}
+
+// priceExtremes returns the ids of the cheapest and most expensive albums,
+// the earlier one on a tie, or nils when there are none or their currencies differ
+func priceExtremes(albums []Album) (cheapest, mostExpensive *string) {
+	if len(albums) == 0 {
+		return nil, nil
+	}
+	lo, hi := 0, 0
+	for i, a := range albums {
+		if a.Currency != albums[0].Currency {
+			return nil, nil
+		}
+		if a.Price < albums[lo].Price {
+			lo = i
+		}
+		if a.Price > albums[hi].Price {
+			hi = i
+		}
+	}
+	return &albums[lo].ID, &albums[hi].ID
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompareAlbums(t *testing.T) {
+	srv, _ := newTestServer(append(testAlbums,
+		Album{ID: "4", Title: "Kind of Blue", Artist: "Miles Davis", Price: 3000, Currency: "JPY", Tags: []string{}},
+	)...)
+	h := newTestRouter(t, srv)
+
+	var cmp albumComparison
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/compare?ids=3,1,2,42", nil, nil, &cmp); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(cmp.Albums) != 3 || cmp.Albums[0].ID != "3" || cmp.Albums[2].ID != "2" {
+		t.Errorf("albums %+v, want 3, 1 and 2 in that order", cmp.Albums)
+	}
+	if cmp.CheapestID == nil || *cmp.CheapestID != "2" || cmp.MostExpensiveID == nil || *cmp.MostExpensiveID != "1" {
+		t.Errorf("cheapest %v, most expensive %v; want 2 and 1", cmp.CheapestID, cmp.MostExpensiveID)
+	}
+	if len(cmp.NotFound) != 1 || cmp.NotFound[0] != "42" {
+		t.Errorf("not_found %v, want [42]", cmp.NotFound)
+	}
+
+	// Prices in different currencies can't be compared
+	cmp = albumComparison{}
+	serve(t, h, http.MethodGet, "/v1/albums/compare?ids=1,4", nil, nil, &cmp)
+	if len(cmp.Albums) != 2 || cmp.CheapestID != nil || cmp.MostExpensiveID != nil {
+		t.Errorf("mixed currencies: %+v, want no cheapest or most expensive", cmp)
+	}
+	cmp = albumComparison{}
+	serve(t, h, http.MethodGet, "/v1/albums/compare?ids=41,42", nil, nil, &cmp)
+	if len(cmp.Albums) != 0 || cmp.CheapestID != nil || len(cmp.NotFound) != 2 {
+		t.Errorf("nothing found: %+v", cmp)
+	}
+
+	for _, query := range []string{"", "ids=1", "ids=1,2,3,4,5,6"} {
+		if rec := serve(t, h, http.MethodGet, "/v1/albums/compare?"+query, nil, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestCompareAlbumsErrors(t *testing.T) {
+	albumIDsAreUUIDs = true
+	srv, repo := newTestServer(testAlbums...)
+	rec := httptest.NewRecorder()
+	srv.compareAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums/compare?ids=1,2", nil))
+	albumIDsAreUUIDs = false
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("ids that aren't UUIDs: status %d, want 400", rec.Code)
+	}
+
+	repo.err = errDatabaseUnavailable
+	rec = httptest.NewRecorder()
+	srv.compareAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums/compare?ids=1,2", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("storage error: status %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ========== Configuration File ==========
+
+// defaultConfigFile is read when it exists and neither --config nor CONFIG_FILE is given
+const defaultConfigFile = "config.yaml"
+
+// knownSettings are the environment variables the server reads. A config
+// file key that maps to anything else is most likely a typo and is logged.
+var knownSettings = map[string]bool{
+	"ADMIN_TOKEN": true, "ALBUM_ID_FORMAT": true, "ALLOW_CREDENTIALS": true, "API_PREFIX": true, "AWS_ACCESS_KEY_ID": true, "AWS_REGION": true,
+	"AWS_SECRET_ACCESS_KEY": true, "CACHE_ENABLED": true, "CACHE_TTL_SECONDS": true,
+	"CORS_ALLOWED_HEADERS": true, "CORS_ALLOWED_METHODS": true, "CORS_ALLOWED_ORIGINS": true, "CORS_EXPOSED_HEADERS": true,
+	"DATABASE_URL": true, "DB_DRIVER": true, "DB_BREAKER_FAILURES": true, "DB_BREAKER_TIMEOUT_SECONDS": true, "DB_HEALTH_FAILURES": true, "DB_HEALTH_INTERVAL_SECONDS": true,
+	"DB_HOST": true, "DB_NAME": true, "DB_PASSWORD": true, "DB_PORT": true, "DB_READ_HOST": true, "DB_READ_PORT": true, "DB_READ_REPLICAS": true, "DB_SCHEMA": true,
+	"DB_SSLMODE": true, "DB_SSL_MODE": true, "DB_SSL_CERT": true, "DB_SSL_KEY": true, "DB_SSL_ROOT_CERT": true, "DB_STATEMENT_TIMEOUT_MS": true,
+	"DB_USER": true, "DEFAULT_PAGE_SIZE": true, "ENCRYPTION_KEY": true, "JSON_CASE": true, "LOG_LEVEL": true, "LOG_MASKED_FIELDS": true, "MAINTENANCE_MODE": true,
+	"MAINTENANCE_RETRY_AFTER_SECONDS": true, "MAX_CONCURRENT_REQUESTS": true, "MAX_PAGE_SIZE": true,
+	"PAGE_SIZE_OVERFLOW": true, "RATE_LIMIT_BURST": true, "RATE_LIMIT_PER_MINUTE": true, "REDIS_CACHE_TTL_SECONDS": true,
+	"REDIS_URL": true, "REQUEST_TIMEOUT_SECONDS": true, "S3_BUCKET": true, "S3_ENDPOINT": true,
+	"S3_PUBLIC_URL": true, "SHUTDOWN_TIMEOUT": true,
+	"SLOW_QUERY_MS": true, "SLOW_QUERY_THRESHOLD_MS": true, "SQLITE_PATH": true, "STATS_REFRESH_INTERVAL_SECONDS": true, "TENANT_IDS": true,
+	"TRUST_PROXY": true, "UPLOADS_DIR": true, "WEBHOOK_WORKERS": true,
+}
+
+// configFile is a YAML file whose settings are exported as environment
+// variables, so the rest of the server reads them like any other. Keys map to
+// variable names by joining the nesting with _ (db.host is DB_HOST) and lists
+// become comma-separated values (tenant_ids: [acme, globex] is TENANT_IDS=acme,globex).
+// Variables that are already set, from the environment or .env, take precedence.
+type configFile struct {
+	path string
+	// set is what the file last exported, so a reload can tell its own
+	// values apart from ones set in the environment since
+	set map[string]string
+}
+
+// loadedConfig is the file applied at startup, or nil; POST /admin/reload re-applies it
+var loadedConfig *configFile
+
+// configFilePath picks the file from --config, then CONFIG_FILE, then
+// config.yaml if it exists. It returns "" when there is no file to read.
+func configFilePath(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
+	}
+	if _, err := os.Stat(defaultConfigFile); err == nil {
+		return defaultConfigFile
+	}
+	return ""
+}
+
+// loadConfigFile reads path and exports its settings
+func loadConfigFile(path string) (*configFile, error) {
+	c := &configFile{path: path, set: make(map[string]string)}
+	if err := c.apply(); err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded configuration from %s", path)
+	return c, nil
+}
+
+// apply reads the file again and exports its settings. Anything it fails on
+// is reported before a single variable is changed.
+func (c *configFile) apply() error {
+	v := viper.New()
+	v.SetConfigFile(c.path)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("reading %s: %w", c.path, err)
+	}
+
+	values := make(map[string]string)
+	keys := v.AllKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+		value, err := settingValue(v.Get(key))
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", c.path, key, err)
+		}
+		if !knownSettings[name] && !strings.HasPrefix(name, "OTEL_") {
+			log.Printf("Warning: %s sets %s (%s), which the server doesn't use", c.path, key, name)
+		}
+		values[name] = value
+	}
+
+	for name, value := range values {
+		current, isSet := os.LookupEnv(name)
+		if prev, ours := c.set[name]; isSet && (!ours || current != prev) {
+			delete(c.set, name) // set elsewhere, which wins
+			continue
+		}
+		os.Setenv(name, value)
+		c.set[name] = value
+	}
+	// Settings removed from the file since the last apply go back to their defaults
+	for name, value := range c.set {
+		if _, ok := values[name]; !ok && os.Getenv(name) == value {
+			os.Unsetenv(name)
+			delete(c.set, name)
+		}
+	}
+	return nil
+}
+
+// settingValue turns a YAML value into the string form its environment
+// variable expects
+func settingValue(raw interface{}) (string, error) {
+	switch raw := raw.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, len(raw))
+		for i, item := range raw {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				return "", errors.New("lists may only hold plain values")
+			}
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return fmt.Sprint(raw), nil
+	}
+}
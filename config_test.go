@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigFilePath(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	if got := configFilePath("flag.yaml"); got != "flag.yaml" {
+		t.Errorf("with --config: %q", got)
+	}
+	if got := configFilePath(""); got != "" {
+		t.Errorf("without any: %q, want none", got)
+	}
+	t.Setenv("CONFIG_FILE", "env.yaml")
+	if got := configFilePath(""); got != "env.yaml" {
+		t.Errorf("with CONFIG_FILE: %q", got)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+db:
+  host: db.internal
+  port: 5433
+tenant_ids: [acme, globex]
+log_level: debug
+typo_setting: 1
+`)
+	t.Setenv("DB_HOST", "")
+	os.Unsetenv("DB_HOST")
+	t.Setenv("DB_PORT", "5432") // set in the environment, which wins
+	t.Setenv("TENANT_IDS", "")
+	os.Unsetenv("TENANT_IDS")
+	t.Setenv("LOG_LEVEL", "")
+	os.Unsetenv("LOG_LEVEL")
+	t.Setenv("TYPO_SETTING", "")
+	defer os.Unsetenv("TYPO_SETTING")
+
+	c, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"DB_HOST":    "db.internal",
+		"DB_PORT":    "5432",
+		"TENANT_IDS": "acme,globex",
+		"LOG_LEVEL":  "debug",
+	} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// Reloading drops what the file no longer sets, but not what was changed since
+	writeConfig(t, path, "db:\n  host: db2.internal\n")
+	os.Setenv("LOG_LEVEL", "warn")
+	if err := c.apply(); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("DB_HOST") != "db2.internal" || os.Getenv("LOG_LEVEL") != "warn" {
+		t.Errorf("after a reload: DB_HOST %q, LOG_LEVEL %q", os.Getenv("DB_HOST"), os.Getenv("LOG_LEVEL"))
+	}
+	if _, ok := os.LookupEnv("TENANT_IDS"); ok {
+		t.Errorf("TENANT_IDS still set to %q after it left the file", os.Getenv("TENANT_IDS"))
+	}
+}
+
+func TestLoadConfigFileRejectsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "nested.yaml")
+	writeConfig(t, nested, "tenant_ids: [[acme]]\n")
+	broken := filepath.Join(dir, "broken.yaml")
+	writeConfig(t, broken, "db: [\n")
+
+	for _, path := range []string{nested, broken, filepath.Join(dir, "missing.yaml")} {
+		if _, err := loadConfigFile(path); err == nil {
+			t.Errorf("%s: loaded", filepath.Base(path))
+		}
+	}
+}
+
+func TestSettingValue(t *testing.T) {
+	for _, tt := range []struct {
+		raw  interface{}
+		want string
+	}{
+		{nil, ""},
+		{true, "true"},
+		{30, "30"},
+		{[]interface{}{"a", 1}, "a,1"},
+	} {
+		if got, err := settingValue(tt.raw); err != nil || got != tt.want {
+			t.Errorf("settingValue(%v) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+	if _, err := settingValue([]interface{}{map[string]interface{}{}}); err == nil {
+		t.Error("settingValue accepted a list of maps")
+	}
+}
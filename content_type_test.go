@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRequireJSON(t *testing.T) {
+	h := requireJSON(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		method, contentType, body string
+		status                    int
+	}{
+		{http.MethodPost, "text/plain", "{}", http.StatusUnsupportedMediaType},
+		{http.MethodPut, "text/plain", "{}", http.StatusUnsupportedMediaType},
+		{http.MethodPatch, "text/plain", "{}", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "", "{}", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "application/json", "{}", http.StatusOK},
+		{http.MethodPost, "application/json; charset=utf-8", "{}", http.StatusOK},
+		{http.MethodPost, "Application/JSON", "{}", http.StatusOK},
+		{http.MethodPost, "", "", http.StatusOK}, // no body, nothing to decode
+		{http.MethodDelete, "text/plain", "{}", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/admin/webhooks", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s with %q: status %d, want %d", tt.method, tt.contentType, rec.Code, tt.status)
+		}
+	}
+}
+
+// Routes in openapi.yaml get the same check from the spec
+func TestUnsupportedMediaTypeOnAPIRoutes(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	srv, repo := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	album := `{"id": "4", "title": "Kind of Blue", "artist": "Miles Davis", "price": 29.99}`
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodPost, "/v1/albums"},
+		{http.MethodPatch, "/v1/albums/1"},
+		{http.MethodPut, "/v1/playlists/1"},
+		{http.MethodPost, "/v1/admin/webhooks"},
+	} {
+		rec := send(tt.method, tt.path, "text/plain", album)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("%s %s with text/plain: status %d, want 415: %s", tt.method, tt.path, rec.Code, rec.Body)
+			continue
+		}
+		var apiErr APIError
+		decodeJSON(t, rec, &apiErr)
+		if apiErr.Field != "Content-Type" {
+			t.Errorf("%s %s: error field %q, want Content-Type", tt.method, tt.path, apiErr.Field)
+		}
+	}
+	if _, ok := repo.albums["4"]; ok {
+		t.Error("album sent as text/plain was stored")
+	}
+
+	if rec := send(http.MethodPost, "/v1/albums", "application/json; charset=utf-8", album); rec.Code != http.StatusCreated {
+		t.Errorf("POST with a charset: status %d, want 201: %s", rec.Code, rec.Body)
+	}
+}
+
+// Every body PATCH takes must get past the validation of the spec
+func TestPatchMediaTypesThroughRouter(t *testing.T) {
+	srv, repo := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+
+	for _, tt := range []struct{ contentType, body string }{
+		{mergePatchContentType, `{"price": 10}`},
+		{jsonPatchContentType, `[{"op": "replace", "path": "/price", "value": 11}]`},
+		{"application/json", `{"price": 12}`},
+	} {
+		req := httptest.NewRequest(http.MethodPatch, "/v1/albums/1", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		req.Header.Set("If-Match", strconv.Quote(strconv.Itoa(repo.albums["1"].Version)))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("PATCH with %s: status %d, want 200: %s", tt.contentType, rec.Code, rec.Body)
+		}
+	}
+	if price := repo.albums["1"].Price; price != 12 {
+		t.Errorf("price %v after the patches, want 12", price)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ========== CORS ==========
+
+// corsMaxAge is how long, in seconds, browsers may reuse a preflight answer
+const corsMaxAge = 600
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	// The request headers the API reads, besides the ones browsers always allow
+	defaultCORSHeaders = []string{"Accept-Version", "Authorization", "Content-Type", "Idempotency-Key", "If-Match", "X-Request-Timeout", "X-Tenant-ID"}
+	// The response headers the API sets that scripts may want to read
+	defaultCORSExposedHeaders = []string{"API-Version", "Deprecation", "ETag", "Idempotent-Replayed", "Link", "Retry-After", "Sunset", "Warning", "X-Total-Count"}
+)
+
+// corsSettings says which browser origins may call the API and how
+type corsSettings struct {
+	origins     map[string]bool
+	anyOrigin   bool // CORS_ALLOWED_ORIGINS=*
+	methods     string
+	headers     string
+	exposed     string
+	credentials bool
+}
+
+// loadCORSSettings reads CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS,
+// CORS_ALLOWED_HEADERS, CORS_EXPOSED_HEADERS and ALLOW_CREDENTIALS. It returns
+// nil, leaving CORS off, when no origins are allowed.
+func loadCORSSettings() *corsSettings {
+	origins := commaList(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	if len(origins) == 0 {
+		return nil
+	}
+
+	c := &corsSettings{origins: make(map[string]bool)}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		c.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	if v := os.Getenv("ALLOW_CREDENTIALS"); v != "" {
+		credentials, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("ALLOW_CREDENTIALS must be true or false, got %q", v)
+		}
+		c.credentials = credentials
+	}
+	// Browsers refuse credentials with a wildcard origin, and echoing every
+	// origin instead would let any site act with the user's cookies
+	if c.anyOrigin && c.credentials {
+		log.Fatalf("ALLOW_CREDENTIALS needs CORS_ALLOWED_ORIGINS to list origins, not *")
+	}
+
+	methods := commaList(strings.ToUpper(os.Getenv("CORS_ALLOWED_METHODS")))
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := commaList(os.Getenv("CORS_ALLOWED_HEADERS"))
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	exposed := commaList(os.Getenv("CORS_EXPOSED_HEADERS"))
+	if len(exposed) == 0 {
+		exposed = defaultCORSExposedHeaders
+	}
+	c.methods = strings.Join(methods, ", ")
+	c.headers = strings.Join(headers, ", ")
+	c.exposed = strings.Join(exposed, ", ")
+	return c
+}
+
+// commaList splits a comma-separated setting, dropping blank entries
+func commaList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers their
+// preflight requests itself. Requests from other origins get no CORS headers,
+// so browsers keep the responses from their scripts. A nil c turns it off.
+func corsMiddleware(c *corsSettings) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if c == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// The answer depends on the origin, so caches must keep them apart
+			w.Header().Add("Vary", "Origin")
+			if !c.anyOrigin && !c.origins[origin] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if c.anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if c.credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", c.methods)
+				w.Header().Set("Access-Control-Allow-Headers", c.headers)
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Access-Control-Expose-Headers", c.exposed)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsRequest sends r through corsMiddleware with the CORS settings in the
+// environment and reports whether it reached the handler behind it
+func corsRequest(r *http.Request) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	h := corsMiddleware(loadCORSSettings())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec, reached
+}
+
+func preflight(origin, method string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, "/v1/albums", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	return req
+}
+
+func TestCORSPreflight(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com/, https://admin.example.com")
+	t.Setenv("ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_ALLOWED_METHODS", "get,post")
+	t.Setenv("CORS_ALLOWED_HEADERS", "Content-Type, X-Custom")
+
+	rec, reached := corsRequest(preflight("https://app.example.com", http.MethodPost))
+	if reached {
+		t.Error("preflight was passed on to the handler")
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status %d, want 204", rec.Code)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST",
+		"Access-Control-Allow-Headers":     "Content-Type, X-Custom",
+		"Access-Control-Max-Age":           "600",
+		"Vary":                             "Origin",
+	}
+	for header, value := range want {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	t.Setenv("ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_EXPOSED_HEADERS", "ETag, X-Total-Count")
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/albums", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec, reached := corsRequest(req)
+	if !reached {
+		t.Fatal("request from an allowed origin didn't reach the handler")
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request's origin", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); got != "ETag, X-Total-Count" {
+		t.Errorf("Access-Control-Expose-Headers = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("Access-Control-Allow-Methods = %q on a non-preflight request", got)
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	t.Setenv("ALLOW_CREDENTIALS", "true")
+
+	for _, req := range []*http.Request{
+		preflight("https://evil.example.com", http.MethodDelete),
+		preflight("https://app.example.com.evil.example.com", http.MethodGet),
+	} {
+		rec, reached := corsRequest(req)
+		if !reached {
+			t.Errorf("%s: request from another origin was answered by the middleware", req.Header.Get("Origin"))
+		}
+		for _, header := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Allow-Methods"} {
+			if got := rec.Header().Get(header); got != "" {
+				t.Errorf("%s: %s = %q, want no CORS headers", req.Header.Get("Origin"), header, got)
+			}
+		}
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("%s: Vary = %q, want Origin", req.Header.Get("Origin"), got)
+		}
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+
+	rec, _ := corsRequest(preflight("https://anywhere.example.com", http.MethodGet))
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q without ALLOW_CREDENTIALS", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, HEAD, POST, PUT, PATCH, DELETE" {
+		t.Errorf("Access-Control-Allow-Methods = %q, want the defaults", got)
+	}
+}
+
+func TestCORSOff(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+
+	rec, reached := corsRequest(preflight("https://app.example.com", http.MethodGet))
+	if !reached || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("CORS headers were set without CORS_ALLOWED_ORIGINS")
+	}
+}
+
+// Preflights carry no token or body, so they must be answered before
+// adminOnly and the OpenAPI validation get to them
+func TestCORSPreflightThroughRouter(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	t.Setenv("ADMIN_TOKEN", "secret")
+	srv, _ := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+
+	for _, path := range []string{"/v1/albums", "/v1/admin/webhooks"} {
+		req := preflight("https://app.example.com", http.MethodPost)
+		req.URL.Path = path
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Errorf("preflight of %s: status %d, Access-Control-Allow-Origin %q", path, rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/albums/1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("GET: status %d, Access-Control-Allow-Origin %q", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
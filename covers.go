@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== Cover Images ==========
+
+const (
+	maxCoverBytes = 5 << 20
+	// maxAlbumFormBytes bounds a multipart POST /albums: the cover plus room for the other fields
+	maxAlbumFormBytes = maxCoverBytes + 1<<20
+)
+
+// coverTypes are the image types accepted as covers, with the file extension they are stored under
+var coverTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// coverUpload is a cover image sent with a new album
+type coverUpload struct {
+	data        []byte
+	contentType string
+}
+
+// CoverStore keeps cover images and says where clients can fetch them
+type CoverStore interface {
+	// Save stores data under key and returns its URL
+	Save(ctx context.Context, key, contentType string, data []byte) (string, error)
+	Delete(ctx context.Context, key string) error
+	// Key gives the key behind a URL that Save returned
+	Key(url string) (string, bool)
+}
+
+// openCoverStore picks the store from the environment: an S3-compatible
+// bucket when S3_BUCKET is set, else the UPLOADS_DIR directory. It returns
+// nil when neither is set, and covers are then refused.
+func openCoverStore(ctx context.Context) (CoverStore, error) {
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		return newS3CoverStore(ctx, bucket)
+	}
+	if dir := os.Getenv("UPLOADS_DIR"); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating UPLOADS_DIR: %w", err)
+		}
+		return &localCoverStore{dir: dir}, nil
+	}
+	return nil, nil
+}
+
+// coverKey names the stored file for a new cover of album id. The random part
+// makes the URL unguessable and keeps an old URL from showing a new image.
+func coverKey(ctx context.Context, id, contentType string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s-%s%s", tenantFromContext(ctx), coverKeyUnsafe.ReplaceAllString(id, "_"), hex.EncodeToString(suffix), coverTypes[contentType]), nil
+}
+
+// coverKeyUnsafe matches what an album id may contain but a cover key must
+// not, such as "/" and "..", which would leave the tenant's directory
+var coverKeyUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// localCoverStore writes covers below dir, served by coverFileServer at /covers/
+type localCoverStore struct {
+	dir string
+}
+
+func (s *localCoverStore) Save(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return "/covers/" + key, nil
+}
+
+func (s *localCoverStore) Delete(ctx context.Context, key string) error {
+	return os.Remove(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+func (s *localCoverStore) Key(url string) (string, bool) {
+	return strings.CutPrefix(url, "/covers/")
+}
+
+// coverFileServer serves the files of UPLOADS_DIR below /covers/. Directory
+// listings are not served, so covers can only be fetched by their URL.
+func coverFileServer(dir string) http.Handler {
+	files := http.StripPrefix("/covers/", http.FileServer(http.Dir(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			sendError(w, APIError{Code: ErrNotFound, Message: "not found"}, http.StatusNotFound)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// s3CoverStore puts covers in an S3 bucket, or a bucket of an S3-compatible
+// service when S3_ENDPOINT is set. Credentials and region come from the usual
+// AWS settings (AWS_REGION, AWS_ACCESS_KEY_ID, ...).
+type s3CoverStore struct {
+	client    *s3.Client
+	bucket    string
+	publicURL string // covers are at publicURL/key
+}
+
+func newS3CoverStore(ctx context.Context, bucket string) (*s3CoverStore, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	endpoint := strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/")
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true // what most S3-compatible services expect
+		}
+	})
+
+	publicURL := strings.TrimSuffix(os.Getenv("S3_PUBLIC_URL"), "/")
+	switch {
+	case publicURL != "":
+	case endpoint != "":
+		publicURL = endpoint + "/" + bucket
+	default:
+		publicURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, cfg.Region)
+	}
+	return &s3CoverStore{client: client, bucket: bucket, publicURL: publicURL}, nil
+}
+
+func (s *s3CoverStore) Save(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.publicURL + "/" + key, nil
+}
+
+func (s *s3CoverStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	return err
+}
+
+func (s *s3CoverStore) Key(url string) (string, bool) {
+	return strings.CutPrefix(url, s.publicURL+"/")
+}
+
+// parseAlbumForm reads a multipart/form-data POST /albums body: the album
+// fields as form values and an optional cover file. Invalid fields, the cover
+// included, come back together in errs; err is for a body that isn't
+// multipart at all. hash identifies the request for Idempotency-Key, and
+// unlike the raw body it doesn't depend on the boundary the client picked.
+func parseAlbumForm(body []byte, boundary string) (a Album, cover *coverUpload, hash string, errs ValidationErrors, err error) {
+	form, err := multipart.NewReader(bytes.NewReader(body), boundary).ReadForm(maxAlbumFormBytes)
+	if err != nil {
+		return a, nil, "", nil, err
+	}
+	defer form.RemoveAll()
+
+	value := func(name string) string {
+		if v := form.Value[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	a = Album{ID: value("id"), Title: value("title"), Artist: value("artist"), Currency: value("currency")}
+	if raw := value("price"); raw != "" {
+		price, perr := strconv.ParseFloat(raw, 64)
+		if perr != nil {
+			errs.Add("price", "price must be a number")
+		}
+		a.Price = price
+	}
+	errs = append(errs, validateAlbum(&a)...)
+
+	if files := form.File["cover"]; len(files) > 0 {
+		var cerr error
+		if cover, cerr = readCover(files[0], "cover"); cerr != nil {
+			errs.Add("cover", cerr.Error())
+		}
+	}
+
+	canonical, err := json.Marshal(struct {
+		Values map[string][]string `json:"values"`
+		Cover  []byte              `json:"cover,omitempty"`
+	}{form.Value, coverData(cover)})
+	if err != nil {
+		return a, nil, "", nil, err
+	}
+	return a, cover, hashRequestBody(canonical), errs, nil
+}
+
+// readCover loads a cover uploaded as form field and checks it is an image we
+// accept, going by its content rather than the type the client claimed
+func readCover(fh *multipart.FileHeader, field string) (*coverUpload, error) {
+	if fh.Size > maxCoverBytes {
+		return nil, fmt.Errorf("%s must be at most %d MB", field, maxCoverBytes>>20)
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// DetectContentType looks at the first 512 bytes only
+	contentType := http.DetectContentType(data)
+	if _, ok := coverTypes[contentType]; !ok {
+		return nil, fmt.Errorf("%s must be a JPEG or PNG image", field)
+	}
+	return &coverUpload{data: data, contentType: contentType}, nil
+}
+
+func coverData(cover *coverUpload) []byte {
+	if cover == nil {
+		return nil
+	}
+	return cover.data
+}
+
+// storeCover saves cover for album and sets its CoverURL. It returns the
+// key, so the file can be removed again if the album isn't created.
+func (s *Server) storeCover(ctx context.Context, album *Album, cover *coverUpload) (string, error) {
+	key, err := coverKey(ctx, album.ID, cover.contentType)
+	if err != nil {
+		return "", err
+	}
+	url, err := s.covers.Save(ctx, key, cover.contentType, cover.data)
+	if err != nil {
+		return "", err
+	}
+	album.CoverURL = url
+	return key, nil
+}
+
+// discardCover removes a stored cover that no album refers to
+func (s *Server) discardCover(ctx context.Context, key string) {
+	if err := s.covers.Delete(ctx, key); err != nil {
+		log.Printf("Failed to remove unused cover %s: %v", key, err)
+	}
+}
+
+// uploadAlbumCover serves POST /albums/{id}/cover: a multipart/form-data body
+// with the image in an "image" field. The new cover replaces the album's
+// current one, which is then removed, and the updated album is returned.
+func (s *Server) uploadAlbumCover(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+	if s.covers == nil {
+		sendError(w, APIError{Code: ErrNotImplemented, Message: "cover images need S3_BUCKET or UPLOADS_DIR"}, http.StatusNotImplemented)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAlbumFormBytes)
+	if err := r.ParseMultipartForm(maxCoverBytes); err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "body must be multipart/form-data with an image field"}, http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		sendError(w, APIError{Code: ErrValidation, Message: "image is required", Field: "image"}, http.StatusUnprocessableEntity)
+		return
+	}
+	cover, err := readCover(files[0], "image")
+	if err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: err.Error(), Field: "image"}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// The album is written back whole, so read it from the primary
+	album, err := s.albums.GetAlbumByID(withPrimaryReads(r.Context()), id, nil)
+	if err != nil {
+		if err == errAlbumNotFound {
+			sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+			return
+		}
+		sendStorageError(w, err)
+		return
+	}
+	oldURL := album.CoverURL
+
+	key, err := s.storeCover(r.Context(), &album, cover)
+	if err != nil {
+		log.Printf("Failed to store cover of album %s: %v", id, err)
+		sendError(w, APIError{Code: ErrInternal, Message: "failed to store cover image"}, http.StatusInternalServerError)
+		return
+	}
+	if err := s.albums.UpdateAlbum(r.Context(), &album); err != nil {
+		s.discardCover(context.WithoutCancel(r.Context()), key)
+		if err == errAlbumNotFound {
+			sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+			return
+		}
+		if err == errVersionConflict {
+			sendError(w, APIError{Code: ErrConflict, Message: err.Error()}, http.StatusConflict)
+			return
+		}
+		sendStorageError(w, err)
+		return
+	}
+	if oldKey, ok := s.covers.Key(oldURL); ok {
+		s.discardCover(context.WithoutCancel(r.Context()), oldKey)
+	}
+
+	s.listCache.invalidate()
+	w.Header().Set("ETag", versionETag(album.Version))
+	sendJSON(w, http.StatusOK, album)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// multipartBody encodes fields as a multipart form, with a PNG header in the
+// file field when file isn't empty
+func multipartBody(fields map[string]string, file string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if file != "" {
+		fw, _ := mw.CreateFormFile(file, "cover.png")
+		fw.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}
+	mw.Close()
+	return &body, mw.FormDataContentType()
+}
+
+// A server without S3_BUCKET or UPLOADS_DIR can't take covers, which is not
+// the client's fault
+func TestCoverWithoutStorage(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+
+	for _, tt := range []struct {
+		path   string
+		fields map[string]string
+		file   string
+	}{
+		{"/v1/albums/1/cover", nil, "image"},
+		{"/v1/albums", map[string]string{"id": "4", "title": "Giant Steps", "artist": "John Coltrane", "price": "24.99"}, "cover"},
+	} {
+		body, contentType := multipartBody(tt.fields, tt.file)
+		req := httptest.NewRequest(http.MethodPost, tt.path, body)
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		var apiErr APIError
+		decodeJSON(t, rec, &apiErr)
+		if rec.Code != http.StatusNotImplemented || apiErr.Code != ErrNotImplemented {
+			t.Errorf("POST %s: status %d, code %q; want 501 %s: %s", tt.path, rec.Code, apiErr.Code, ErrNotImplemented, rec.Body)
+		}
+	}
+}
+
+// Form values are text, so the spec must not reject a price sent as one
+func TestMultipartCreateThroughRouter(t *testing.T) {
+	srv, repo := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+
+	body, contentType := multipartBody(map[string]string{"id": "4", "title": "Giant Steps", "artist": "John Coltrane", "price": "24.99"}, "")
+	req := httptest.NewRequest(http.MethodPost, "/v1/albums", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if a, ok := repo.albums["4"]; !ok || a.Price != 24.99 {
+		t.Errorf("stored %+v, want album 4 at 24.99", a)
+	}
+}
+
+// postMultipart sends a multipart form to h
+func postMultipart(h http.Handler, path string, fields map[string]string, file string) *httptest.ResponseRecorder {
+	body, contentType := multipartBody(fields, file)
+	req := httptest.NewRequest(http.MethodPost, path, body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLocalCovers(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("UPLOADS_DIR", dir)
+	covers, err := openCoverStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, repo := newTestServer(testAlbums...)
+	srv.covers = covers
+	h := newTestRouter(t, srv)
+
+	rec := postMultipart(h, "/v1/albums/1/cover", nil, "image")
+	var album Album
+	decodeJSON(t, rec, &album)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(album.CoverURL, "/covers/default/1-") || !strings.HasSuffix(album.CoverURL, ".png") {
+		t.Fatalf("status %d, cover_url %q: %s", rec.Code, album.CoverURL, rec.Body)
+	}
+	if stored := repo.albums["1"]; stored.CoverURL != album.CoverURL {
+		t.Errorf("stored cover_url %q, want %q", stored.CoverURL, album.CoverURL)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, album.CoverURL, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("GET %s: status %d, Content-Type %q", album.CoverURL, rec.Code, rec.Header().Get("Content-Type"))
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/covers/default/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("directory listing: status %d, want 404", rec.Code)
+	}
+
+	// A new cover replaces the old one, whose file goes
+	first := album.CoverURL
+	rec = postMultipart(h, "/v1/albums/1/cover", nil, "image")
+	decodeJSON(t, rec, &album)
+	if album.CoverURL == first {
+		t.Errorf("cover_url unchanged after a new upload")
+	}
+	if _, err := os.Stat(filepath.Join(dir, strings.TrimPrefix(first, "/covers/"))); !os.IsNotExist(err) {
+		t.Errorf("old cover still stored: %v", err)
+	}
+
+	fields := map[string]string{"id": "4", "title": "Giant Steps", "artist": "John Coltrane", "price": "24.99"}
+	rec = postMultipart(h, "/v1/albums", fields, "cover")
+	decodeJSON(t, rec, &album)
+	if rec.Code != http.StatusCreated || !strings.HasPrefix(album.CoverURL, "/covers/default/4-") {
+		t.Errorf("POST with a cover: status %d, cover_url %q", rec.Code, album.CoverURL)
+	}
+
+	// The cover of an album that isn't created is removed again
+	rec = postMultipart(h, "/v1/albums", fields, "cover")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("POST of a taken id: status %d, want 409", rec.Code)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "default", "4-*"))
+	if len(files) != 1 {
+		t.Errorf("covers of album 4: %v, want only the one it has", files)
+	}
+}
+
+func TestUploadCoverRejectsBadRequests(t *testing.T) {
+	t.Setenv("UPLOADS_DIR", t.TempDir())
+	covers, _ := openCoverStore(context.Background())
+	srv, _ := newTestServer(testAlbums...)
+	srv.covers = covers
+	r := chi.NewRouter()
+	r.Post("/albums/{id}/cover", srv.uploadAlbumCover)
+
+	notImage := func() (*bytes.Buffer, string) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("image", "cover.png")
+		fw.Write([]byte("plain text"))
+		mw.Close()
+		return &body, mw.FormDataContentType()
+	}
+	noImage := func() (*bytes.Buffer, string) { return multipartBody(map[string]string{"title": "x"}, "") }
+	withImage := func() (*bytes.Buffer, string) { return multipartBody(nil, "image") }
+	notForm := func() (*bytes.Buffer, string) { return bytes.NewBufferString(`{}`), "application/json" }
+
+	for _, tt := range []struct {
+		name   string
+		id     string
+		body   func() (*bytes.Buffer, string)
+		status int
+	}{
+		{"not multipart", "1", notForm, http.StatusBadRequest},
+		{"no image", "1", noImage, http.StatusUnprocessableEntity},
+		{"not an image", "1", notImage, http.StatusUnprocessableEntity},
+		{"missing album", "42", withImage, http.StatusNotFound},
+	} {
+		body, contentType := tt.body()
+		req := httptest.NewRequest(http.MethodPost, "/albums/"+tt.id+"/cover", body)
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+// newFakeS3 serves the PUT and DELETE requests of an S3-compatible bucket,
+// keeping the objects in memory
+func newFakeS3(t *testing.T) (endpoint string, objects map[string][]byte) {
+	t.Helper()
+	objects = make(map[string][]byte)
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = data
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, objects
+}
+
+func TestS3Covers(t *testing.T) {
+	endpoint, objects := newFakeS3(t)
+	t.Setenv("S3_BUCKET", "covers")
+	t.Setenv("S3_ENDPOINT", endpoint+"/")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "none"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "none"))
+
+	covers, err := openCoverStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	url, err := covers.Save(ctx, "default/1-abc.png", "image/png", []byte("\x89PNG\r\n\x1a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != endpoint+"/covers/default/1-abc.png" {
+		t.Errorf("URL %q, want it below the endpoint's bucket", url)
+	}
+	if _, ok := objects["/covers/default/1-abc.png"]; !ok {
+		t.Fatalf("objects %v, want the cover", objects)
+	}
+	key, ok := covers.Key(url)
+	if !ok || key != "default/1-abc.png" {
+		t.Errorf("Key(%q) = %q, %v", url, key, ok)
+	}
+	if _, ok := covers.Key("https://elsewhere.example.com/x.png"); ok {
+		t.Error("Key accepted a URL of another store")
+	}
+	if err := covers.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("objects %v after Delete", objects)
+	}
+
+	t.Setenv("S3_PUBLIC_URL", "https://cdn.example.com/")
+	covers, _ = openCoverStore(ctx)
+	if url, _ := covers.Save(ctx, "default/2.png", "image/png", nil); url != "https://cdn.example.com/default/2.png" {
+		t.Errorf("with S3_PUBLIC_URL: URL %q", url)
+	}
+}
+
+func TestCoverKey(t *testing.T) {
+	key, err := coverKey(withTenant(context.Background(), "acme"), "../a b/c", "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "acme/___a_b_c-") || !strings.HasSuffix(key, ".jpg") || strings.Count(key, "/") != 1 {
+		t.Errorf("key %q, want it in the tenant's directory without the id's slashes", key)
+	}
+}
+
+func TestOpenCoverStore(t *testing.T) {
+	t.Setenv("S3_BUCKET", "")
+	t.Setenv("UPLOADS_DIR", "")
+	if covers, err := openCoverStore(context.Background()); covers != nil || err != nil {
+		t.Errorf("without storage: %v, %v; want none", covers, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o600)
+	t.Setenv("UPLOADS_DIR", filepath.Join(file, "covers"))
+	if _, err := openCoverStore(context.Background()); err == nil {
+		t.Error("UPLOADS_DIR below a file was accepted")
+	}
+
+	t.Setenv("S3_BUCKET", "covers")
+	t.Setenv("S3_ENDPOINT", "")
+	t.Setenv("S3_PUBLIC_URL", "")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "none"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "none"))
+	covers, err := openCoverStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := covers.Key("https://covers.s3.eu-west-1.amazonaws.com/default/1.png"); !ok || key != "default/1.png" {
+		t.Errorf("AWS URL: key %q, %v", key, ok)
+	}
+}
+
+func TestS3CoversUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	t.Setenv("S3_BUCKET", "covers")
+	t.Setenv("S3_ENDPOINT", ts.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_MAX_ATTEMPTS", "1")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "none"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "none"))
+
+	covers, err := openCoverStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := newTestServer(testAlbums...)
+	srv.covers = covers
+	rec := postMultipart(newTestRouter(t, srv), "/v1/albums/1/cover", nil, "image")
+	if rec.Code < http.StatusInternalServerError {
+		t.Errorf("status %d, want a server error when the bucket can't be reached", rec.Code)
+	}
+	if err := covers.Delete(context.Background(), "default/1.png"); err == nil {
+		t.Error("Delete succeeded on an unreachable bucket")
+	}
+}
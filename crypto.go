@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// ========== Field Encryption ==========
+
+// encryptionKey is the AES-256 key from ENCRYPTION_KEY. When it is nil prices
+// are stored as plain decimal strings.
+var encryptionKey []byte
+
+// loadEncryptionKey reads ENCRYPTION_KEY: 32 bytes, hex-encoded (64 characters)
+func loadEncryptionKey() {
+	v := os.Getenv("ENCRYPTION_KEY")
+	if v == "" {
+		encryptionKey = nil
+		return
+	}
+	key, err := hex.DecodeString(v)
+	if err != nil || len(key) != 32 {
+		log.Fatalf("ENCRYPTION_KEY must be 32 bytes written as 64 hex characters")
+	}
+	encryptionKey = key
+}
+
+// encryptField seals plaintext with AES-256-GCM under key. The result is the
+// random nonce followed by the ciphertext, base64-encoded.
+func encryptField(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptField reverses encryptField. It fails if ciphertext was not sealed
+// with key or has been tampered with.
+func decryptField(key []byte, ciphertext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encodePrice gives the value stored in albums.price: the price encrypted
+// when ENCRYPTION_KEY is set, the plain number otherwise
+func encodePrice(price float64) (string, error) {
+	s := strconv.FormatFloat(price, 'f', -1, 64)
+	if encryptionKey == nil {
+		return s, nil
+	}
+	return encryptField(encryptionKey, s)
+}
+
+// decodePrice reverses encodePrice. Plain numbers are accepted with or
+// without a key, so rows written before encryption was enabled stay readable.
+func decodePrice(stored string) (float64, error) {
+	if encryptionKey != nil {
+		if s, err := decryptField(encryptionKey, stored); err == nil {
+			return strconv.ParseFloat(s, 64)
+		}
+	}
+	price, err := strconv.ParseFloat(stored, 64)
+	if err != nil {
+		return 0, errors.New("price can't be decrypted; is ENCRYPTION_KEY the key it was written with?")
+	}
+	return price, nil
+}
+
+// isEncryptedPrice reports whether stored is already ciphertext
+func isEncryptedPrice(stored string) bool {
+	_, err := strconv.ParseFloat(stored, 64)
+	return err != nil
+}
+
+// AfterScan fills Price from the stored column. Queries that don't select
+// price leave it at zero.
+func (a *Album) AfterScan(ctx context.Context) error {
+	if a.StoredPrice == "" {
+		return nil
+	}
+	price, err := decodePrice(a.StoredPrice)
+	if err != nil {
+		return fmt.Errorf("album %s: %w", a.ID, err)
+	}
+	a.Price = price
+	return nil
+}
+
+// encodePrice stores Price in its at-rest form; called before every write
+func (a *Album) encodePrice() error {
+	stored, err := encodePrice(a.Price)
+	if err != nil {
+		return fmt.Errorf("encrypting price: %w", err)
+	}
+	a.StoredPrice = stored
+	return nil
+}
+
+// albumFromRow decodes an album serialised by Postgres (row_to_json), whose
+// price is the stored column rather than a number
+func albumFromRow(data []byte) (*Album, error) {
+	var row struct {
+		Album
+		Price json.RawMessage `json:"price"`
+	}
+	if err := json.Unmarshal(data, &row); err != nil {
+		return nil, err
+	}
+	row.Album.StoredPrice = string(row.Price)
+	var s string
+	if err := json.Unmarshal(row.Price, &s); err == nil {
+		row.Album.StoredPrice = s
+	}
+	if err := row.Album.AfterScan(context.Background()); err != nil {
+		return nil, err
+	}
+	return &row.Album, nil
+}
+
+// encryptStoredPrices encrypts every price still stored in plain text, such
+// as those written before ENCRYPTION_KEY was set, and returns how many it
+// changed. It works across all tenants.
+func encryptStoredPrices(ctx context.Context, db DB) (int, error) {
+	if encryptionKey == nil {
+		return 0, errors.New("ENCRYPTION_KEY is not set")
+	}
+	var plain []*Album
+	err := db.Select(ctx, func(a *Album) error {
+		if !isEncryptedPrice(a.StoredPrice) {
+			plain = append(plain, &Album{TenantID: a.TenantID, ID: a.ID, Price: a.Price})
+		}
+		return nil
+	}, "SELECT tenant_id, id, price FROM albums")
+	if err != nil {
+		return 0, err
+	}
+
+	for i, a := range plain {
+		// encodePrice encrypts Price into the column
+		err := a.encodePrice()
+		if err == nil {
+			_, err = db.Update(ctx, nil, `UPDATE albums SET price = ? WHERE tenant_id = ? AND id = ?`, a.StoredPrice, a.TenantID, a.ID)
+		}
+		if err != nil {
+			return i, fmt.Errorf("album %s/%s: %w", a.TenantID, a.ID, err)
+		}
+	}
+	return len(plain), nil
+}
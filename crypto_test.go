@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// withEncryptionKey sets encryptionKey for the rest of the test
+func withEncryptionKey(t *testing.T, key []byte) {
+	t.Helper()
+	saved := encryptionKey
+	encryptionKey = key
+	t.Cleanup(func() { encryptionKey = saved })
+}
+
+func TestEncryptField(t *testing.T) {
+	key := make([]byte, 32)
+	sealed, err := encryptField(key, "17.99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := encryptField(key, "17.99")
+	if sealed == again {
+		t.Error("two encryptions of the same value are equal; the nonce isn't random")
+	}
+	if plain, err := decryptField(key, sealed); err != nil || plain != "17.99" {
+		t.Errorf("decryptField = %q, %v", plain, err)
+	}
+
+	other := make([]byte, 32)
+	other[0] = 1
+	for _, tt := range []struct {
+		name       string
+		key        []byte
+		ciphertext string
+	}{
+		{"another key", other, sealed},
+		{"not base64", key, "%%%"},
+		{"too short", key, "AAAA"},
+		{"tampered with", key, sealed[:len(sealed)-4] + "AAAA"},
+		{"bad key size", key[:5], sealed},
+	} {
+		if _, err := decryptField(tt.key, tt.ciphertext); err == nil {
+			t.Errorf("%s: decryptField succeeded", tt.name)
+		}
+	}
+	if _, err := encryptField(key[:5], "1"); err == nil {
+		t.Error("encryptField accepted a 5-byte key")
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	withEncryptionKey(t, nil)
+	t.Setenv("ENCRYPTION_KEY", strings.Repeat("ab", 32))
+	loadEncryptionKey()
+	if hex.EncodeToString(encryptionKey) != strings.Repeat("ab", 32) {
+		t.Errorf("key %x", encryptionKey)
+	}
+	t.Setenv("ENCRYPTION_KEY", "")
+	loadEncryptionKey()
+	if encryptionKey != nil {
+		t.Errorf("key %x without ENCRYPTION_KEY, want none", encryptionKey)
+	}
+}
+
+func TestEncodePrice(t *testing.T) {
+	withEncryptionKey(t, nil)
+	if stored, _ := encodePrice(17.99); stored != "17.99" || isEncryptedPrice(stored) {
+		t.Errorf("without a key: stored %q", stored)
+	}
+
+	withEncryptionKey(t, make([]byte, 32))
+	stored, err := encodePrice(17.99)
+	if err != nil || !isEncryptedPrice(stored) {
+		t.Fatalf("with a key: stored %q, %v", stored, err)
+	}
+	if price, err := decodePrice(stored); err != nil || price != 17.99 {
+		t.Errorf("decodePrice = %v, %v", price, err)
+	}
+	// Prices written before the key was set stay readable
+	if price, err := decodePrice("22"); err != nil || price != 22 {
+		t.Errorf("plain price: %v, %v", price, err)
+	}
+
+	sealed := stored
+	withEncryptionKey(t, nil)
+	a := &Album{ID: "1", StoredPrice: sealed}
+	if err := a.AfterScan(context.Background()); err == nil || !strings.Contains(err.Error(), "album 1") {
+		t.Errorf("AfterScan without the key: %v", err)
+	}
+}
+
+func TestAlbumFromRow(t *testing.T) {
+	withEncryptionKey(t, make([]byte, 32))
+	stored, _ := encodePrice(9.5)
+	for _, row := range []string{
+		`{"id":"1","title":"Jeru","price":"` + stored + `"}`,
+		`{"id":"1","title":"Jeru","price":9.5}`,
+	} {
+		a, err := albumFromRow([]byte(row))
+		if err != nil || a.ID != "1" || a.Price != 9.5 {
+			t.Errorf("albumFromRow(%s) = %+v, %v", row, a, err)
+		}
+	}
+	if _, err := albumFromRow([]byte(`{"id":`)); err == nil {
+		t.Error("albumFromRow accepted broken JSON")
+	}
+	if _, err := albumFromRow([]byte(`{"id":"1","price":"garbage"}`)); err == nil {
+		t.Error("albumFromRow accepted a price it can't decrypt")
+	}
+}
+
+func TestEncryptStoredPrices(t *testing.T) {
+	withEncryptionKey(t, nil)
+	h, db := newSQLiteTestRouter(t)
+	ctx := context.Background()
+	if _, err := encryptStoredPrices(ctx, db); err == nil {
+		t.Error("encryptStoredPrices ran without a key")
+	}
+	for _, a := range []Album{
+		{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+		{ID: "2", Title: "Time Out", Artist: "Dave Brubeck", Price: 22},
+	} {
+		serve(t, h, "POST", "/v1/albums", a, nil, nil)
+	}
+
+	withEncryptionKey(t, make([]byte, 32))
+	if n, err := encryptStoredPrices(ctx, db); err != nil || n != 2 {
+		t.Fatalf("encryptStoredPrices = %d, %v; want 2", n, err)
+	}
+	if n, _ := encryptStoredPrices(ctx, db); n != 0 {
+		t.Errorf("second run encrypted %d prices, want 0", n)
+	}
+
+	var stored []string
+	if err := db.Select(ctx, &stored, "SELECT price FROM albums ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stored {
+		if !isEncryptedPrice(s) {
+			t.Errorf("price %q stored in plain text", s)
+		}
+	}
+	var album Album
+	serve(t, h, "GET", "/v1/albums/1", nil, nil, &album)
+	if album.Price != 17.99 {
+		t.Errorf("price read back as %v, want 17.99", album.Price)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// ========== Database Access ==========
+
+// DB runs the repositories' SQL on whichever database DB_DRIVER chose: pgDB
+// for PostgreSQL, sqliteDB for SQLite. Queries are written in the SQL both
+// understand, with ? for arguments; what differs between them is in
+// dialect(). Besides plain values, an argument may be an inList for IN (?),
+// a stringArray for an array column, or a map, struct or json.RawMessage for
+// a JSON column.
+//
+// dest takes the rows of a query the way go-pg scans them: a pointer to a
+// struct with pg tags, which fails with sql.ErrNoRows when there is no row, a
+// pointer to a slice of such structs or of single values, a pointer to a
+// single value, or a func(*T) error called for every row, as go-pg's ForEach
+// takes. Columns are matched to fields by their pg names, and AfterScan
+// hooks run on every struct scanned.
+type DB interface {
+	Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	// Insert, Update and Delete run a statement of their kind and return how
+	// many rows it changed. dest takes its RETURNING rows, and is nil without them.
+	Insert(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error)
+	Update(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error)
+	Delete(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error)
+	Ping(ctx context.Context) error
+
+	// RunInTransaction runs fn in a transaction, committing it when fn
+	// returns nil and rolling it back otherwise. Within one, it runs fn in
+	// the transaction already open.
+	RunInTransaction(ctx context.Context, fn func(tx DB) error) error
+
+	dialect() sqlDialect
+}
+
+// dbPool is the primary database as a whole, for the server, the health
+// check and the database monitor
+type dbPool interface {
+	DB
+	PoolStats() *pg.PoolStats
+	Close() error
+}
+
+// sqlDialect is the SQL that differs between the backends
+type sqlDialect struct {
+	now        string // the current time, as stored in timestamp columns
+	ilike      string // case-insensitive LIKE
+	forUpdate  string // locks the selected rows until the transaction ends
+	skipLocked string // forUpdate, passing over rows another transaction locked
+}
+
+var (
+	postgresDialect = sqlDialect{
+		now:        "now()",
+		ilike:      "ILIKE",
+		forUpdate:  " FOR UPDATE",
+		skipLocked: " FOR UPDATE SKIP LOCKED",
+	}
+	// SQLite compares LIKE without case for ASCII, and its transactions take
+	// the whole database's write lock when they begin (see openSQLite)
+	sqliteDialect = sqlDialect{
+		now:   "strftime('%Y-%m-%d %H:%M:%f', 'now')",
+		ilike: "LIKE",
+	}
+)
+
+// inList is the argument of IN (?). An empty list matches nothing.
+type inList []string
+
+// stringArray is the value of a column holding a list of strings: a text[]
+// in PostgreSQL, JSON in SQLite
+type stringArray []string
+
+// nullString is s, or NULL when s is empty, for nullable text columns
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// pgDB is the DB of DB_DRIVER=postgres, a go-pg connection pool
+type pgDB struct {
+	db *pg.DB
+}
+
+func (d pgDB) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return pgSelect(ctx, d.db, dest, query, args)
+}
+
+func (d pgDB) Insert(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return pgExec(ctx, d.db, dest, query, args)
+}
+
+func (d pgDB) Update(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return pgExec(ctx, d.db, dest, query, args)
+}
+
+func (d pgDB) Delete(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return pgExec(ctx, d.db, dest, query, args)
+}
+
+func (d pgDB) Ping(ctx context.Context) error {
+	return d.db.Ping(ctx)
+}
+
+func (d pgDB) RunInTransaction(ctx context.Context, fn func(tx DB) error) error {
+	return d.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return fn(pgTx{tx: tx})
+	})
+}
+
+func (pgDB) dialect() sqlDialect {
+	return postgresDialect
+}
+
+func (d pgDB) PoolStats() *pg.PoolStats {
+	return d.db.PoolStats()
+}
+
+func (d pgDB) Close() error {
+	return d.db.Close()
+}
+
+// pgTx is a pgDB transaction
+type pgTx struct {
+	tx *pg.Tx
+}
+
+func (t pgTx) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return pgSelect(ctx, t.tx, dest, query, args)
+}
+
+func (t pgTx) Insert(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return pgExec(ctx, t.tx, dest, query, args)
+}
+
+func (t pgTx) Update(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return pgExec(ctx, t.tx, dest, query, args)
+}
+
+func (t pgTx) Delete(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return pgExec(ctx, t.tx, dest, query, args)
+}
+
+func (t pgTx) Ping(ctx context.Context) error {
+	_, err := t.tx.ExecContext(ctx, "SELECT 1")
+	return err
+}
+
+func (t pgTx) RunInTransaction(ctx context.Context, fn func(tx DB) error) error {
+	return fn(t)
+}
+
+func (pgTx) dialect() sqlDialect {
+	return postgresDialect
+}
+
+// pgSelect runs a query on db, a pool or a transaction, into dest
+func pgSelect(ctx context.Context, db orm.DB, dest interface{}, query string, args []interface{}) error {
+	model, one, err := pgModel(dest)
+	if err != nil {
+		return err
+	}
+	if one {
+		_, err = db.QueryOneContext(ctx, model, query, pgArgs(args)...)
+	} else {
+		_, err = db.QueryContext(ctx, model, query, pgArgs(args)...)
+	}
+	if err == pg.ErrNoRows {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// pgExec runs a statement on db, reading the rows it returns into dest when there is one
+func pgExec(ctx context.Context, db orm.DB, dest interface{}, query string, args []interface{}) (int, error) {
+	var (
+		res pg.Result
+		err error
+	)
+	if dest == nil {
+		res, err = db.ExecContext(ctx, query, pgArgs(args)...)
+	} else {
+		var model interface{}
+		if model, _, err = pgModel(dest); err != nil {
+			return 0, err
+		}
+		res, err = db.QueryContext(ctx, model, query, pgArgs(args)...)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// pgArgs turns the arguments go-pg doesn't know into its own
+func pgArgs(args []interface{}) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case inList:
+			if len(v) == 0 {
+				out[i] = nil // IN (NULL) matches nothing, where IN () is an error
+			} else {
+				out[i] = pg.In([]string(v))
+			}
+		case stringArray:
+			out[i] = pg.Array([]string(v))
+		case json.RawMessage:
+			out[i] = string(v) // go-pg would send []byte as bytea
+
+		default:
+			out[i] = arg
+		}
+	}
+	return out
+}
+
+// pgModel is the go-pg model that scans into dest. one is true when dest
+// takes a single row.
+func pgModel(dest interface{}) (model interface{}, one bool, err error) {
+	v := reflect.ValueOf(dest)
+	switch {
+	case v.Kind() == reflect.Func:
+		row, err := newPGRowFunc(v)
+		return row, false, err
+	case v.Kind() != reflect.Ptr || v.IsNil():
+		return nil, false, fmt.Errorf("pg: can't scan into %T", dest)
+	}
+	switch elem := v.Type().Elem(); {
+	case elem.Kind() == reflect.Slice && elem != reflect.TypeOf([]byte(nil)):
+		return dest, false, nil
+	case elem.Kind() == reflect.Struct && elem != reflect.TypeOf(time.Time{}):
+		return dest, true, nil
+	default:
+		return pg.Scan(dest), true, nil
+	}
+}
+
+// pgRowFunc is a go-pg model that scans every row into a fresh T and calls
+// fn with it, for a func(*T) error dest
+type pgRowFunc struct {
+	orm.Model // scans into row
+	row       reflect.Value
+	fn        reflect.Value
+	err       error // from fn, which isn't called again after it fails
+}
+
+func newPGRowFunc(fn reflect.Value) (*pgRowFunc, error) {
+	typ := fn.Type()
+	if typ.NumIn() != 1 || typ.In(0).Kind() != reflect.Ptr || typ.In(0).Elem().Kind() != reflect.Struct ||
+		typ.NumOut() != 1 || typ.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		return nil, fmt.Errorf("pg: can't scan into %s, expected func(*T) error", typ)
+	}
+	row := reflect.New(typ.In(0).Elem())
+	model, err := orm.NewModel(row.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return &pgRowFunc{Model: model, row: row, fn: fn}, nil
+}
+
+// NextColumnScanner clears the row left by the previous one
+func (m *pgRowFunc) NextColumnScanner() orm.ColumnScanner {
+	m.row.Elem().Set(reflect.Zero(m.row.Elem().Type()))
+	return m.Model.NextColumnScanner()
+}
+
+// AddColumnScanner is called with each row once it is scanned
+func (m *pgRowFunc) AddColumnScanner(orm.ColumnScanner) error {
+	if m.err == nil {
+		m.err, _ = m.fn.Call([]reflect.Value{m.row})[0].Interface().(error)
+	}
+	return m.err
+}
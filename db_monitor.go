@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ========== Database Health Monitor ==========
+
+const (
+	defaultDBHealthInterval = 5 * time.Second
+	defaultDBHealthFailures = 3
+)
+
+// dbHealthSettings reads DB_HEALTH_INTERVAL_SECONDS (how often the database is
+// pinged) and DB_HEALTH_FAILURES (failed pings in a row that mark it down)
+func dbHealthSettings() (interval time.Duration, failures int) {
+	interval = defaultDBHealthInterval
+	if v := os.Getenv("DB_HEALTH_INTERVAL_SECONDS"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			log.Fatalf("DB_HEALTH_INTERVAL_SECONDS must be a positive integer, got %q", v)
+		}
+		interval = time.Duration(secs) * time.Second
+	}
+
+	failures = defaultDBHealthFailures
+	if v := os.Getenv("DB_HEALTH_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("DB_HEALTH_FAILURES must be a positive integer, got %q", v)
+		}
+		failures = n
+	}
+	return interval, failures
+}
+
+// dbMonitor pings the primary in the background and keeps dbHealthy up to
+// date, so requests can be answered with 503 straight away while the database
+// is down instead of each waiting for its own connection error.
+//
+// go-pg drops a connection that fails and dials a new one for the next query,
+// so the monitor doesn't replace the pool: its pings are what reconnect it,
+// and the first one that gets through marks the database healthy again.
+type dbMonitor struct {
+	db        dbPool
+	failures  int // consecutive failed pings that mark the database down
+	dbHealthy atomic.Bool
+	done      chan struct{}
+}
+
+func newDBMonitor(db dbPool, failures int) *dbMonitor {
+	m := &dbMonitor{db: db, failures: failures, done: make(chan struct{})}
+	m.dbHealthy.Store(true) // connectDB has just reached it
+	return m
+}
+
+// Healthy reports whether the last pings reached the database. A nil monitor
+// is always healthy.
+func (m *dbMonitor) Healthy() bool {
+	return m == nil || m.dbHealthy.Load()
+}
+
+// Start pings the database every interval in a goroutine until ctx is
+// cancelled. Use Wait to block until it has stopped.
+func (m *dbMonitor) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		failed := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			pingCtx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+			err := m.db.Ping(pingCtx)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == nil {
+				failed = 0
+				if !m.dbHealthy.Swap(true) {
+					log.Println("Database reachable again, accepting requests")
+				}
+				continue
+			}
+			failed++
+			log.Printf("Database ping failed (%d in a row): %v", failed, err)
+			if failed >= m.failures && m.dbHealthy.Swap(false) {
+				log.Printf("Database down after %d failed pings, answering album requests with 503", failed)
+			}
+		}
+	}()
+}
+
+// Wait blocks until the goroutine started by Start has returned
+func (m *dbMonitor) Wait() {
+	<-m.done
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyDB is a database whose pings fail while down is set
+type flakyDB struct {
+	sqliteDB
+	down  atomic.Bool
+	pings atomic.Int32
+}
+
+func (d *flakyDB) Ping(ctx context.Context) error {
+	d.pings.Add(1)
+	if d.down.Load() {
+		return errors.New("connection refused")
+	}
+	return d.sqliteDB.Ping(ctx)
+}
+
+func TestDBMonitor(t *testing.T) {
+	sqlite, err := openSQLite(filepath.Join(t.TempDir(), "albums.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	db := &flakyDB{sqliteDB: sqlite}
+
+	monitor := newDBMonitor(db, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	monitor.Start(ctx, time.Millisecond)
+	defer func() {
+		cancel()
+		monitor.Wait()
+	}()
+
+	waitFor := func(healthy bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for monitor.Healthy() != healthy {
+			if time.Now().After(deadline) {
+				t.Fatalf("Healthy() stayed %v after %d pings", !healthy, db.pings.Load())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor(true)
+	db.down.Store(true)
+	waitFor(false)
+	db.down.Store(false)
+	waitFor(true)
+}
+
+func TestDBHealthSettings(t *testing.T) {
+	if interval, failures := dbHealthSettings(); interval != defaultDBHealthInterval || failures != defaultDBHealthFailures {
+		t.Errorf("defaults %v, %d", interval, failures)
+	}
+	t.Setenv("DB_HEALTH_INTERVAL_SECONDS", "2")
+	t.Setenv("DB_HEALTH_FAILURES", "7")
+	if interval, failures := dbHealthSettings(); interval != 2*time.Second || failures != 7 {
+		t.Errorf("got %v, %d; want 2s, 7", interval, failures)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ========== Deprecation ==========
+
+//go:embed deprecations.yaml
+var deprecationsConfig []byte
+
+type deprecationEntry struct {
+	Prefix string `yaml:"prefix"`
+	Sunset string `yaml:"sunset"`
+}
+
+// loadDeprecations parses the embedded deprecations.yaml into route prefix -> sunset date
+func loadDeprecations() (map[string]time.Time, error) {
+	var config struct {
+		Deprecations []deprecationEntry `yaml:"deprecations"`
+	}
+	if err := yaml.Unmarshal(deprecationsConfig, &config); err != nil {
+		return nil, fmt.Errorf("parsing deprecations.yaml: %w", err)
+	}
+
+	routes := make(map[string]time.Time, len(config.Deprecations))
+	for _, d := range config.Deprecations {
+		if !strings.HasPrefix(d.Prefix, "/") {
+			return nil, fmt.Errorf("deprecations.yaml: prefix %q must start with /", d.Prefix)
+		}
+		sunset, err := time.Parse("2006-01-02", d.Sunset)
+		if err != nil {
+			return nil, fmt.Errorf("deprecations.yaml: sunset for %s must be a YYYY-MM-DD date, got %q", d.Prefix, d.Sunset)
+		}
+		routes[strings.TrimSuffix(d.Prefix, "/")] = sunset
+	}
+	return routes, nil
+}
+
+// deprecationMiddleware marks responses on deprecated routes with
+// Deprecation: true and a Sunset date (draft-ietf-httpapi-deprecation-header, RFC 8594).
+// Routes are matched without API_PREFIX, so deprecations.yaml doesn't depend on it.
+func deprecationMiddleware(routes map[string]time.Time) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if sunset, ok := matchDeprecation(routes, strings.TrimPrefix(r.URL.Path, apiPrefix)); ok {
+				w.Header().Set("Deprecation", "true")
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// matchDeprecation finds the longest prefix in routes that covers path
+func matchDeprecation(routes map[string]time.Time, path string) (time.Time, bool) {
+	var sunset time.Time
+	best := -1
+	for prefix, date := range routes {
+		if len(prefix) <= best {
+			continue
+		}
+		if path == prefix || strings.HasPrefix(path, prefix+"/") || prefix == "" {
+			sunset, best = date, len(prefix)
+		}
+	}
+	return sunset, best >= 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchDeprecation(t *testing.T) {
+	albums := time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC)
+	batch := time.Date(2027, 1, 31, 0, 0, 0, 0, time.UTC)
+	routes := map[string]time.Time{"/v1/albums": albums, "/v1/albums/batch-delete": batch}
+
+	for _, tt := range []struct {
+		path string
+		want time.Time
+		ok   bool
+	}{
+		{"/v1/albums", albums, true},
+		{"/v1/albums/42", albums, true},
+		{"/v1/albums/batch-delete", batch, true},
+		{"/v1/albums-old", time.Time{}, false},
+		{"/v2/albums", time.Time{}, false},
+	} {
+		if got, ok := matchDeprecation(routes, tt.path); ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("matchDeprecation(%s) = %v, %v; want %v, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+	if _, ok := matchDeprecation(map[string]time.Time{"": albums}, "/anything"); !ok {
+		t.Error("the root prefix didn't match everything")
+	}
+}
+
+func TestDeprecationMiddleware(t *testing.T) {
+	routes := map[string]time.Time{"/v1/albums": time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC)}
+	h := deprecationMiddleware(routes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/albums/1", nil))
+	if rec.Header().Get("Deprecation") != "true" || rec.Header().Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" {
+		t.Errorf("deprecated route: Deprecation %q, Sunset %q", rec.Header().Get("Deprecation"), rec.Header().Get("Sunset"))
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/albums", nil))
+	if rec.Header().Get("Deprecation") != "" {
+		t.Error("current route marked deprecated")
+	}
+}
+
+func TestLoadDeprecations(t *testing.T) {
+	saved := deprecationsConfig
+	defer func() { deprecationsConfig = saved }()
+
+	deprecationsConfig = []byte("deprecations:\n  - prefix: /v1/albums/\n    sunset: 2027-06-30\n")
+	routes, err := loadDeprecations()
+	if err != nil || len(routes) != 1 || routes["/v1/albums"].IsZero() {
+		t.Errorf("loadDeprecations = %v, %v", routes, err)
+	}
+	for _, config := range []string{
+		"deprecations: {",
+		"deprecations:\n  - prefix: v1/albums\n    sunset: 2027-06-30\n",
+		"deprecations:\n  - prefix: /v1/albums\n    sunset: soon\n",
+	} {
+		deprecationsConfig = []byte(config)
+		if _, err := loadDeprecations(); err == nil {
+			t.Errorf("loadDeprecations accepted %q", config)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ========== Album Duplication ==========
+
+// duplicateAlbum serves POST /albums/{id}/duplicate: a copy of the album under
+// a new UUID, for a re-issue or another price tier. The body is optional;
+// {"title": "..."} gives the copy its own title.
+func (s *Server) duplicateAlbum(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+	var body struct {
+		Title *string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		sendError(w, APIError{Code: ErrValidation, Message: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+	var title string
+	if body.Title != nil {
+		if title = strings.TrimSpace(*body.Title); title == "" {
+			var errs ValidationErrors
+			errs.Add("title", "title must not be empty")
+			sendError(w, errs.APIError(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	album, err := s.albums.DuplicateAlbum(r.Context(), id, uuid.New().String(), title)
+	if err == errAlbumNotFound {
+		sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	s.listCache.invalidate()
+
+	w.Header().Set("Location", apiPath(r.Context(), "/albums/"+album.ID))
+	sendJSON(w, http.StatusCreated, album)
+}
+
+// DuplicateAlbum copies album id to newID in one transaction, with its tags
+// but not its reviews or cover, which belong to the original. A non-empty
+// title replaces the original's. The copy starts at version 1 with its own
+// timestamps and is audited as a create.
+func (r *sqlAlbumRepository) DuplicateAlbum(ctx context.Context, id, newID, title string) (Album, error) {
+	var album Album
+	err := r.db.RunInTransaction(ctx, func(tx DB) error {
+		where := albumWhere(ctx).and("id = ?", id)
+		if err := tx.Select(ctx, &album, selectAlbumsSQL+where.String(), where.args...); err != nil {
+			if err == sql.ErrNoRows {
+				return errAlbumNotFound
+			}
+			return err
+		}
+
+		album.ID = newID
+		if title != "" {
+			album.Title = title
+		}
+		album.CoverURL = ""
+		if err := insertAlbum(ctx, tx, &album); err != nil {
+			return err
+		}
+
+		_, err := tx.Insert(ctx, nil, `
+			INSERT INTO album_tags (tenant_id, album_id, tag_id)
+			SELECT tenant_id, ?, tag_id FROM album_tags
+			WHERE tenant_id = ? AND album_id = ?`,
+			newID, album.TenantID, id)
+		if err != nil {
+			return err
+		}
+		one := []Album{album}
+		if err := loadAlbumTags(ctx, tx, one); err != nil {
+			return err
+		}
+		album.Tags = one[0].Tags
+
+		return insertAudit(ctx, tx, newAlbumAudit(ctx, auditCreate, album.ID, nil, &album))
+	})
+	if err != nil {
+		return Album{}, err
+	}
+	return album, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDuplicateAlbum(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+
+	var dup Album
+	rec := serve(t, h, http.MethodPost, "/v1/albums/1/duplicate", map[string]string{"title": " Jeru (reissue) "}, nil, &dup)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := uuid.Parse(dup.ID); err != nil || dup.Title != "Jeru (reissue)" || dup.Price != 17.99 {
+		t.Errorf("copy %+v, want a UUID and the new title", dup)
+	}
+	if loc := rec.Header().Get("Location"); !strings.HasSuffix(loc, "/albums/"+dup.ID) {
+		t.Errorf("Location %q", loc)
+	}
+
+	// Without a body, the copy keeps the title
+	serve(t, h, http.MethodPost, "/v1/albums/1/duplicate", nil, nil, &dup)
+	if dup.Title != "Jeru" {
+		t.Errorf("copy without a body titled %q", dup.Title)
+	}
+
+	for _, tt := range []struct {
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"/v1/albums/42/duplicate", nil, http.StatusNotFound},
+		{"/v1/albums/1/duplicate", map[string]string{"title": "  "}, http.StatusUnprocessableEntity},
+		{"/v1/albums/1/duplicate", "title", http.StatusBadRequest},
+	} {
+		if rec := serve(t, h, http.MethodPost, tt.path, tt.body, nil, nil); rec.Code != tt.status {
+			t.Errorf("POST %s %v: status %d, want %d: %s", tt.path, tt.body, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Error codes returned in the "code" field of every error response.
+// Clients should switch on these rather than on the English message.
+const (
+	ErrValidation         = "VALIDATION_ERROR"
+	ErrUnauthorized       = "UNAUTHORIZED"
+	ErrForbidden          = "FORBIDDEN"
+	ErrNotFound           = "NOT_FOUND"
+	ErrConflict           = "CONFLICT"
+	ErrMethodNotAllowed   = "METHOD_NOT_ALLOWED"
+	ErrTimeout            = "TIMEOUT"
+	ErrRateLimitExceeded  = "RATE_LIMIT_EXCEEDED"
+	ErrMaintenance        = "MAINTENANCE"
+	ErrServerBusy         = "SERVER_BUSY"
+	ErrServiceUnavailable = "SERVICE_UNAVAILABLE"
+	ErrNotImplemented     = "NOT_IMPLEMENTED"
+	ErrInternal           = "INTERNAL_ERROR"
+)
+
+// APIError is the JSON body of every error response, e.g.
+// {"error": "price must be non-negative", "code": "VALIDATION_ERROR", "field": "price"}
+// Invalid albums list every failing field in "errors".
+type APIError struct {
+	Message string        `json:"error"`
+	Code    string        `json:"code"`
+	Field   string        `json:"field,omitempty"`
+	Details interface{}   `json:"details,omitempty"`
+	Errors  []*FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a validation failure tied to a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// APIError converts the validation failure into a VALIDATION_ERROR response body
+func (e *FieldError) APIError() APIError {
+	return APIError{Code: ErrValidation, Message: e.Message, Field: e.Field}
+}
+
+// ValidationErrors collects every failing field of a request body, so
+// clients can point out all of them at once
+type ValidationErrors []*FieldError
+
+// Add records that field failed with message
+func (v *ValidationErrors) Add(field, message string) {
+	*v = append(*v, &FieldError{Field: field, Message: message})
+}
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, fe := range v {
+		messages[i] = fe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// APIError converts the failures into a VALIDATION_ERROR response body. A
+// single failure also fills "field", as other validation errors do.
+func (v ValidationErrors) APIError() APIError {
+	apiErr := APIError{Code: ErrValidation, Message: v.Error(), Errors: v}
+	if len(v) == 1 {
+		apiErr.Field = v[0].Field
+	}
+	return apiErr
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique_violation
+// (23505), or SQLite's equivalent
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	pgErr, ok := err.(pg.Error)
+	return ok && pgErr.Field('C') == "23505"
+}
+
+// isTimeout reports whether err means a query ran out of time: the context
+// deadline passed, the connection timed out, or PostgreSQL cancelled the
+// statement (57014, which is how go-pg stops a query whose context is done)
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var pgErr pg.Error
+	return errors.As(err, &pgErr) && pgErr.Field('C') == "57014"
+}
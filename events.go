@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// ========== Album Change Events ==========
+
+const (
+	eventStreamContentType = "text/event-stream"
+
+	// albumEventsChannel is the Postgres NOTIFY channel fed by migrations/008_album_events.sql
+	albumEventsChannel = "album_events"
+
+	// albumEventBuffer is how many events a subscriber may fall behind before it is dropped
+	albumEventBuffer = 64
+
+	// eventStreamKeepAlive is how often an idle stream gets a comment line, so
+	// proxies don't close it and dead clients are noticed
+	eventStreamKeepAlive = 15 * time.Second
+
+	// albumEventPollInterval is how often the broker reads new rows of
+	// album_events when the database can't notify it, as SQLite can't
+	albumEventPollInterval = time.Second
+)
+
+// albumEventTypes maps the event_type of album_events to albumEvent.Type
+var albumEventTypes = map[string]string{
+	"AlbumCreated": "created",
+	"AlbumUpdated": "updated",
+	"AlbumDeleted": "deleted",
+}
+
+// albumEvent is one change to an album, as sent to clients
+type albumEvent struct {
+	Type  string `json:"type"` // created, updated or deleted
+	ID    string `json:"id"`
+	Album *Album `json:"album,omitempty"`
+}
+
+// wantsEventStream reports whether the client asked for server-sent events
+func wantsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), eventStreamContentType)
+}
+
+// albumEventBroker listens for album changes in Postgres and fans them out to
+// the connected event streams of the same tenant. Because the changes come
+// from a trigger, writes made by other server instances or directly in the
+// database show up too. With SQLite, which has no LISTEN, it polls the
+// album_events log its triggers keep instead.
+type albumEventBroker struct {
+	db   DB
+	done chan struct{}
+
+	mu     sync.Mutex
+	subs   map[chan albumEvent]string // subscriber -> tenant
+	closed bool
+}
+
+func newAlbumEventBroker(db DB) *albumEventBroker {
+	return &albumEventBroker{db: db, done: make(chan struct{}), subs: make(map[chan albumEvent]string)}
+}
+
+// Start listens for notifications in a goroutine until ctx is cancelled, then
+// ends every open stream. Use Wait to block until it has stopped.
+func (b *albumEventBroker) Start(ctx context.Context) {
+	if db, ok := b.db.(pgDB); ok {
+		b.listen(ctx, db.db)
+		return
+	}
+	b.poll(ctx)
+}
+
+func (b *albumEventBroker) listen(ctx context.Context, db *pg.DB) {
+	ln := db.Listen(ctx, albumEventsChannel)
+
+	go func() {
+		defer close(b.done)
+		defer b.closeAll()
+		defer ln.Close()
+
+		ch := ln.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n, ok := <-ch:
+				if !ok {
+					return
+				}
+				var payload struct {
+					albumEvent
+					TenantID string          `json:"tenant_id"`
+					Album    json.RawMessage `json:"album"` // the row as stored; see albumFromRow
+				}
+				if err := json.Unmarshal([]byte(n.Payload), &payload); err != nil {
+					log.Printf("Discarding unreadable album event: %v", err)
+					continue
+				}
+				if len(payload.Album) > 0 && string(payload.Album) != "null" {
+					album, err := albumFromRow(payload.Album)
+					if err != nil {
+						log.Printf("Discarding unreadable album event: %v", err)
+						continue
+					}
+					payload.albumEvent.Album = album
+				}
+				b.publish(payload.TenantID, payload.albumEvent)
+			}
+		}
+	}()
+}
+
+// poll publishes the rows added to album_events since the last poll, starting
+// from those added after Start
+func (b *albumEventBroker) poll(ctx context.Context) {
+	var last int64
+	if err := b.db.Select(ctx, &last, `SELECT COALESCE(MAX(id), 0) FROM album_events`); err != nil {
+		log.Printf("Failed to read album events: %v", err)
+	}
+
+	go func() {
+		defer close(b.done)
+		defer b.closeAll()
+
+		ticker := time.NewTicker(albumEventPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			var events []AlbumHistoryEvent
+			err := b.db.Select(ctx, &events, `SELECT * FROM album_events WHERE id > ? ORDER BY id`, last)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to read album events: %v", err)
+				}
+				continue
+			}
+			for _, e := range events {
+				last = e.ID
+				b.publish(e.TenantID, albumEvent{Type: albumEventTypes[e.Type], ID: e.AlbumID, Album: e.Album})
+			}
+		}
+	}()
+}
+
+// Wait blocks until the goroutine started by Start has returned
+func (b *albumEventBroker) Wait() {
+	<-b.done
+}
+
+// Subscribe returns a channel of events for tenant and a function to stop
+// receiving them. The channel is closed when the broker shuts down or the
+// subscriber falls too far behind.
+func (b *albumEventBroker) Subscribe(tenant string) (<-chan albumEvent, func()) {
+	ch := make(chan albumEvent, albumEventBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = tenant
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish hands event to every subscriber of tenant. A subscriber whose
+// buffer is full is dropped rather than allowed to hold up the others; its
+// client reconnects and starts from the current state.
+func (b *albumEventBroker) publish(tenant string, event albumEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, subTenant := range b.subs {
+		if subTenant != tenant {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Dropping slow album event subscriber for tenant %q", tenant)
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *albumEventBroker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// albumEvents streams album changes of the request's tenant as server-sent
+// events until the client disconnects or the server shuts down
+func (s *Server) albumEvents(w http.ResponseWriter, r *http.Request) {
+	if !wantsEventStream(r) {
+		sendError(w, APIError{Code: ErrValidation, Message: "this endpoint only serves " + eventStreamContentType + ", send it in Accept"}, http.StatusNotAcceptable)
+		return
+	}
+
+	events, unsubscribe := s.events.Subscribe(tenantFromContext(r.Context()))
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Album event stream cannot be flushed: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			err = writeEvent(w, event)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return // the client has gone away
+		}
+	}
+}
+
+// writeEvent writes event in the text/event-stream format
+func writeEvent(w http.ResponseWriter, event albumEvent) error {
+	data, err := encodeJSON(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newEventServer serves albumEvents and albumsWebSocket from a broker polling
+// db, which stops when the test ends or cancel is called
+func newEventServer(t *testing.T, db DB) (ts *httptest.Server, cancel func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &Server{events: newAlbumEventBroker(db)}
+	srv.events.Start(ctx)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", srv.albumEvents)
+	mux.HandleFunc("/ws", srv.albumsWebSocket)
+	ts = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		cancel()
+		srv.events.Wait()
+		ts.Close()
+	})
+	return ts, cancel
+}
+
+// readEvent returns the next event of the stream, skipping keep-alives
+func readEvent(t *testing.T, lines *bufio.Scanner) (typ, data string) {
+	t.Helper()
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && typ != "":
+			return typ, data
+		}
+	}
+	t.Fatalf("stream ended before an event: %v", lines.Err())
+	return "", ""
+}
+
+func TestAlbumEvents(t *testing.T) {
+	h, db := newSQLiteTestRouter(t)
+	ts, stop := newEventServer(t, db)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/events", nil)
+	req.Header.Set("Accept", eventStreamContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != eventStreamContentType {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	serve(t, h, http.MethodDelete, "/v1/albums/1", nil, nil, nil)
+	lines := bufio.NewScanner(resp.Body)
+	if typ, data := readEvent(t, lines); typ != "created" || !strings.Contains(data, `"title":"Jeru"`) {
+		t.Errorf("first event %s %s, want the album created", typ, data)
+	}
+	if typ, data := readEvent(t, lines); typ != "deleted" || !strings.Contains(data, `"id":"1"`) {
+		t.Errorf("second event %s %s, want album 1 deleted", typ, data)
+	}
+
+	// Shutting the broker down ends the stream
+	stop()
+	for lines.Scan() {
+	}
+	if err := lines.Err(); err != nil {
+		t.Errorf("stream ended with %v", err)
+	}
+}
+
+func TestAlbumEventsNeedsAccept(t *testing.T) {
+	srv := &Server{events: newAlbumEventBroker(nil)}
+	rec := httptest.NewRecorder()
+	srv.albumEvents(rec, httptest.NewRequest(http.MethodGet, "/albums/events", nil))
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("status %d, want 406", rec.Code)
+	}
+}
+
+func TestAlbumEventBroker(t *testing.T) {
+	b := newAlbumEventBroker(nil)
+	acme, stopAcme := b.Subscribe("acme")
+	globex, _ := b.Subscribe("globex")
+
+	b.publish("acme", albumEvent{Type: "created", ID: "1"})
+	if e := <-acme; e.ID != "1" {
+		t.Errorf("acme got %+v", e)
+	}
+	if len(globex) != 0 {
+		t.Error("globex got an event of acme")
+	}
+
+	// A subscriber that falls behind is dropped
+	for i := 0; i <= albumEventBuffer; i++ {
+		b.publish("acme", albumEvent{Type: "updated", ID: "1"})
+	}
+	n := 0
+	for range acme {
+		n++
+	}
+	if n != albumEventBuffer {
+		t.Errorf("slow subscriber got %d events before it was dropped, want %d", n, albumEventBuffer)
+	}
+	stopAcme() // no-op once dropped
+
+	b.closeAll()
+	if _, ok := <-globex; ok {
+		t.Error("globex still open after closeAll")
+	}
+	late, _ := b.Subscribe("acme")
+	if _, ok := <-late; ok {
+		t.Error("Subscribe after closeAll returned an open channel")
+	}
+}
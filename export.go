@@ -0,0 +1,76 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ========== ZIP Export ==========
+
+const exportFilename = "albums_export.zip"
+
+// exportAlbums serves GET /albums/export: every album of the tenant as
+// albums.json and albums.csv in one ZIP file. The CSV has the columns
+// POST /albums/import expects, so an export can be imported elsewhere.
+// Both files come from a single query and therefore list the same albums.
+func (s *Server) exportAlbums(w http.ResponseWriter, r *http.Request) {
+	albums, err := s.albums.ListAlbums(r.Context(), AlbumListOptions{})
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if albums == nil {
+		albums = []Album{}
+	}
+	data, err := encodeJSON(albums)
+	if err != nil {
+		sendError(w, APIError{Code: ErrInternal, Message: "failed to encode albums"}, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	// The status is out, so a failure from here on can only cut the file short
+	if err := writeExportZip(w, data, albums); err != nil {
+		log.Printf("Album export stopped: %v", err)
+	}
+}
+
+// writeExportZip writes the archive to w: albumsJSON as albums.json, and albums as albums.csv
+func writeExportZip(w io.Writer, albumsJSON []byte, albums []Album) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create("albums.json")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(albumsJSON, '\n')); err != nil {
+		return err
+	}
+
+	f, err = zw.Create("albums.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	if err := cw.Write(importColumns); err != nil {
+		return err
+	}
+	for _, a := range albums {
+		if err := cw.Write([]string{a.ID, a.Title, a.Artist, strconv.FormatFloat(a.Price, 'f', -1, 64), a.Currency}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
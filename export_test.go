@@ -0,0 +1,85 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportAlbums(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/export", nil, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without the admin token: status %d, want 401", rec.Code)
+	}
+
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru, Live", Artist: "Gerry Mulligan", Price: 17.5}, nil, nil)
+	rec := serve(t, h, http.MethodGet, "/v1/albums/export", nil, admin, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	var albums []Album
+	if err := json.Unmarshal([]byte(files["albums.json"]), &albums); err != nil || len(albums) != 1 || albums[0].Title != "Jeru, Live" {
+		t.Errorf("albums.json = %q, %v", files["albums.json"], err)
+	}
+	if want := "id,title,artist,price,currency\n1,\"Jeru, Live\",Gerry Mulligan,17.5,USD\n"; files["albums.csv"] != want {
+		t.Errorf("albums.csv = %q, want %q", files["albums.csv"], want)
+	}
+
+	// The CSV imports into another server as it is
+	other, _ := newSQLiteTestRouter(t)
+	var summary importSummary
+	if rec := postCSV(t, other, "", files["albums.csv"], &summary); rec.Code != http.StatusCreated || summary.Imported != 1 {
+		t.Errorf("importing the export: status %d, %+v", rec.Code, summary)
+	}
+}
+
+func TestExportAlbumsStorageError(t *testing.T) {
+	srv, repo := newTestServer()
+	repo.err = errDatabaseUnavailable
+	rec := httptest.NewRecorder()
+	srv.exportAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums/export", nil))
+	if rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Header().Get("Content-Type"), "zip") {
+		t.Errorf("status %d, Content-Type %q; want a 503 error", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestExportAlbumsEmpty(t *testing.T) {
+	srv, _ := newTestServer()
+	rec := httptest.NewRecorder()
+	srv.exportAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums/export", nil))
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open("albums.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	if strings.TrimSpace(string(data)) != "[]" {
+		t.Errorf("albums.json %q, want an empty list", data)
+	}
+}
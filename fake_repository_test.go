@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNotFaked is returned by the fakeAlbumRepository methods no test needs yet
+var errNotFaked = errors.New("not implemented by fakeAlbumRepository")
+
+// fakeAlbumRepository is an in-memory AlbumRepository for handler tests. It
+// ignores tenants and field selection, and fails every call with err when
+// that is set.
+type fakeAlbumRepository struct {
+	mu      sync.Mutex
+	albums  map[string]Album
+	reviews []Review
+	err     error
+}
+
+func newFakeAlbumRepository(albums ...Album) *fakeAlbumRepository {
+	f := &fakeAlbumRepository{albums: make(map[string]Album)}
+	for _, a := range albums {
+		f.albums[a.ID] = a
+	}
+	return f
+}
+
+// matching returns the albums opts filters down to, in the order listQuery
+// gives them, without paginating
+func (f *fakeAlbumRepository) matching(opts AlbumListOptions) []Album {
+	var ids map[string]bool
+	if opts.IDs != nil {
+		ids = make(map[string]bool, len(opts.IDs))
+		for _, id := range opts.IDs {
+			ids[id] = true
+		}
+	}
+
+	var albums []Album
+	for _, a := range f.albums {
+		switch {
+		case ids != nil && !ids[a.ID],
+			opts.Artist != "" && normalizeArtist(a.Artist) != normalizeArtist(opts.Artist),
+			opts.TitleSearch != "" && !strings.Contains(strings.ToLower(a.Title), strings.ToLower(opts.TitleSearch)),
+			opts.Tag != "" && !hasTag(a.Tags, normalizeTag(opts.Tag)),
+			opts.MinPrice != nil && a.Price < *opts.MinPrice,
+			opts.MaxPrice != nil && a.Price > *opts.MaxPrice:
+			continue
+		}
+		albums = append(albums, a)
+	}
+
+	sort.Slice(albums, func(i, j int) bool {
+		a, b := albums[i], albums[j]
+		switch strings.TrimPrefix(opts.Sort, "-") {
+		case "title":
+			if a.Title != b.Title {
+				return (a.Title < b.Title) != strings.HasPrefix(opts.Sort, "-")
+			}
+		case "price":
+			if a.Price != b.Price {
+				return (a.Price < b.Price) != strings.HasPrefix(opts.Sort, "-")
+			}
+		case "created_at":
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt) != strings.HasPrefix(opts.Sort, "-")
+			}
+		}
+		return a.ID < b.ID
+	})
+	return albums
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeAlbumRepository) ListAlbums(ctx context.Context, opts AlbumListOptions) ([]Album, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	albums := f.matching(opts)
+	page := opts.Page
+	switch {
+	case page.cursor:
+		start := sort.Search(len(albums), func(i int) bool { return albums[i].ID > page.after })
+		albums = albums[start:]
+		if len(albums) > page.limit {
+			albums = albums[:page.limit]
+		}
+	case page.limit > 0:
+		albums = albums[min(page.offset, len(albums)):]
+		if len(albums) > page.limit {
+			albums = albums[:page.limit]
+		}
+	}
+	return albums, nil
+}
+
+func (f *fakeAlbumRepository) StreamAlbums(ctx context.Context, opts AlbumListOptions, fn func(Album) error) error {
+	albums, err := f.ListAlbums(ctx, opts)
+	if err != nil {
+		return err
+	}
+	for _, a := range albums {
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeAlbumRepository) CountAlbums(ctx context.Context, opts AlbumListOptions) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	return len(f.matching(opts)), nil
+}
+
+func (f *fakeAlbumRepository) RandomAlbum(ctx context.Context, opts AlbumListOptions) (Album, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return Album{}, f.err
+	}
+	albums := f.matching(opts)
+	if len(albums) == 0 {
+		return Album{}, errAlbumNotFound
+	}
+	return albums[0], nil
+}
+
+func (f *fakeAlbumRepository) TopAlbums(ctx context.Context, byReviews bool, limit int) ([]Album, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeAlbumRepository) ArtistSummaries(ctx context.Context, byCount bool) ([]ArtistSummary, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeAlbumRepository) ArtistSummary(ctx context.Context, artist string) (ArtistSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return ArtistSummary{}, f.err
+	}
+	albums := f.matching(AlbumListOptions{Artist: artist})
+	if len(albums) == 0 {
+		return ArtistSummary{}, errArtistNotFound
+	}
+	summary := ArtistSummary{Artist: albums[0].Artist, Count: len(albums)}
+	for _, a := range albums {
+		if a.Artist < summary.Artist {
+			summary.Artist = a.Artist
+		}
+	}
+	return summary, nil
+}
+
+func (f *fakeAlbumRepository) GetAlbumByID(ctx context.Context, id string, fields []string) (Album, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return Album{}, f.err
+	}
+	a, ok := f.albums[id]
+	if !ok {
+		return Album{}, errAlbumNotFound
+	}
+	return a, nil
+}
+
+func (f *fakeAlbumRepository) AlbumExists(ctx context.Context, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return false, f.err
+	}
+	_, ok := f.albums[id]
+	return ok, nil
+}
+
+func (f *fakeAlbumRepository) CreateAlbum(ctx context.Context, album *Album) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	if _, ok := f.albums[album.ID]; ok {
+		return errAlbumExists
+	}
+	now := time.Now().UTC()
+	album.CreatedAt, album.UpdatedAt = now, now
+	album.Version = 1
+	if album.Tags == nil {
+		album.Tags = []string{}
+	}
+	f.albums[album.ID] = *album
+	return nil
+}
+
+func (f *fakeAlbumRepository) UpdateAlbum(ctx context.Context, album *Album) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	old, ok := f.albums[album.ID]
+	if !ok {
+		return errAlbumNotFound
+	}
+	if album.Version != old.Version {
+		return errVersionConflict
+	}
+	album.CreatedAt = old.CreatedAt
+	album.UpdatedAt = time.Now().UTC()
+	album.Version++
+	f.albums[album.ID] = *album
+	return nil
+}
+
+func (f *fakeAlbumRepository) DeleteAlbum(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	if _, ok := f.albums[id]; !ok {
+		return errAlbumNotFound
+	}
+	delete(f.albums, id)
+	return nil
+}
+
+func (f *fakeAlbumRepository) DuplicateAlbum(ctx context.Context, id, newID, title string) (Album, error) {
+	return Album{}, errNotFaked
+}
+
+func (f *fakeAlbumRepository) DeleteAlbums(ctx context.Context, ids []string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	var deleted []string
+	for _, id := range ids {
+		if _, ok := f.albums[id]; ok {
+			delete(f.albums, id)
+			deleted = append(deleted, id)
+		}
+	}
+	return deleted, nil
+}
+
+func (f *fakeAlbumRepository) MultiplyArtistPrices(ctx context.Context, artist string, multiplier float64) ([]string, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeAlbumRepository) ImportAlbums(ctx context.Context, albums []Album, dryRun bool) (map[string]bool, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeAlbumRepository) AddAlbumTags(ctx context.Context, id string, tags []string) ([]string, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeAlbumRepository) RemoveAlbumTag(ctx context.Context, id, tag string) error {
+	return errNotFaked
+}
+
+func (f *fakeAlbumRepository) ListReviews(ctx context.Context, albumID string, page pageParams) ([]Review, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	reviews := []Review{}
+	for _, rv := range f.reviews {
+		if rv.AlbumID == albumID {
+			reviews = append(reviews, rv)
+		}
+	}
+	total := len(reviews)
+	if page.limit > 0 {
+		reviews = reviews[min(page.offset, total):]
+		if len(reviews) > page.limit {
+			reviews = reviews[:page.limit]
+		}
+	}
+	return reviews, total, nil
+}
+
+func (f *fakeAlbumRepository) CreateReview(ctx context.Context, review *Review) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	if _, ok := f.albums[review.AlbumID]; !ok {
+		return errAlbumNotFound
+	}
+	review.ID = int64(len(f.reviews) + 1)
+	review.CreatedAt = time.Now().UTC()
+	f.reviews = append(f.reviews, *review)
+	return nil
+}
+
+func (f *fakeAlbumRepository) DeleteReview(ctx context.Context, albumID string, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	for i, rv := range f.reviews {
+		if rv.AlbumID == albumID && rv.ID == id {
+			f.reviews = append(f.reviews[:i], f.reviews[i+1:]...)
+			return nil
+		}
+	}
+	return errReviewNotFound
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// FuzzPostAlbum feeds arbitrary bodies through postAlbum and validateAlbum.
+// Whatever the client sends, the answer must be a 2xx or a 4xx, never a 5xx
+// or a panic.
+func FuzzPostAlbum(f *testing.F) {
+	seeds := []string{
+		`{"id": "1", "title": "Blue Train", "artist": "John Coltrane", "price": 56.99}`,
+		`{"id": "2", "title": "Jeru", "artist": "Gerry Mulligan", "price": 17.99, "currency": "eur", "tags": ["jazz"]}`,
+		`{"id": "3", "title": "T", "artist": "A", "price": 1e308, "currency": "usd"}`,
+		`{"id": "4", "title": "T", "artist": "A", "price": -0.001}`,
+		`{"id": " ", "title": "\u0000", "artist": "\ud800", "price": 0}`,
+		`{"id": 5, "title": ["T"], "artist": {"name": "A"}, "price": "1"}`,
+		`{"id": "6", "version": -1, "created_at": "not a time", "cover_url": "http://x"}`,
+		`{"price": 1e400}`,
+		`{"id": "7"}{"id": "8"}`,
+		`[]`,
+		`null`,
+		`{`,
+		``,
+		"\xff\xfe",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, body string) {
+		srv, _ := newTestServer()
+		req := httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.postAlbum(rec, req)
+
+		if rec.Code < 200 || rec.Code >= 500 {
+			t.Fatalf("body %q: status %d: %s", body, rec.Code, rec.Body)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import "net/http"
+
+// ========== HAL Links ==========
+
+type halLink struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+// wrapWithHAL returns the album's JSON fields plus a HAL "_links" object
+func wrapWithHAL(album Album, r *http.Request) map[string]interface{} {
+	return withHALLinks(withReviewStats(projectAlbum(album, albumFields), album), album.ID, r)
+}
+
+// withHALLinks adds "_links" for album id to an already projected body
+func withHALLinks(body map[string]interface{}, id string, r *http.Request) map[string]interface{} {
+	base := requestBaseURL(r)
+	self := base + apiPath(r.Context(), "/albums/"+id)
+
+	body["_links"] = map[string]halLink{
+		"self":       {Href: self},
+		"collection": {Href: base + apiPath(r.Context(), "/albums")},
+		"delete":     {Href: self, Method: http.MethodDelete},
+	}
+	return body
+}
+
+// requestBaseURL is the scheme and host the client used, e.g. "https://api.example.com",
+// so links stay absolute and correct behind a reverse proxy (see proxyMiddleware)
+func requestBaseURL(r *http.Request) string {
+	fwd := forwardedFromContext(r.Context())
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fwd.scheme != "" {
+		scheme = fwd.scheme
+	}
+
+	host := r.Host
+	if fwd.host != "" {
+		host = fwd.host
+	}
+	return scheme + "://" + host
+}
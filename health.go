@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// ========== Health Check ==========
+
+// healthPingTimeout bounds the database check, so a hung database shows up
+// as unhealthy instead of hanging the health check too
+const healthPingTimeout = 2 * time.Second
+
+// startTime is when the server started, for uptime_seconds; set in runServer
+var startTime time.Time
+
+type healthResponse struct {
+	Status        string   `json:"status"` // "ok", or "unavailable" when the database doesn't answer
+	UptimeSeconds int64    `json:"uptime_seconds"`
+	Goroutines    int      `json:"goroutines"`
+	DB            dbHealth `json:"db"`
+}
+
+// dbHealth is the connection pool as reported by the database driver
+type dbHealth struct {
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	StaleConns uint32 `json:"stale_conns"`
+	Hits       uint32 `json:"hits"`     // connections reused from the pool
+	Misses     uint32 `json:"misses"`   // new connections opened
+	Timeouts   uint32 `json:"timeouts"` // waits for a free connection that gave up
+	Error      string `json:"error,omitempty"`
+}
+
+// healthHandler answers GET /healthz with 200 and process and pool figures
+// while the database can be reached, and 503 otherwise
+func healthHandler(db dbPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := db.PoolStats()
+		resp := healthResponse{
+			Status:        "ok",
+			UptimeSeconds: int64(time.Since(startTime).Seconds()),
+			Goroutines:    runtime.NumGoroutine(),
+			DB: dbHealth{
+				TotalConns: stats.TotalConns,
+				IdleConns:  stats.IdleConns,
+				StaleConns: stats.StaleConns,
+				Hits:       stats.Hits,
+				Misses:     stats.Misses,
+				Timeouts:   stats.Timeouts,
+			},
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), healthPingTimeout)
+		defer cancel()
+		if err := db.Ping(ctx); err != nil {
+			resp.Status = "unavailable"
+			resp.DB.Error = err.Error()
+			sendJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+		sendJSON(w, http.StatusOK, resp)
+	}
+}
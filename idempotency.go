@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// ========== Idempotency Keys ==========
+
+const (
+	idempotencyKeyTTL    = 24 * time.Hour
+	maxIdempotencyKeyLen = 255
+)
+
+// IdempotencyRecord is the stored outcome of a request sent with an Idempotency-Key
+type IdempotencyRecord struct {
+	tableName struct{} `pg:"idempotency_keys"`
+
+	TenantID    string          `pg:"tenant_id,pk"`
+	Key         string          `pg:"key,pk"`
+	RequestHash string          `pg:"request_hash"`
+	Status      int             `pg:"status"`
+	Response    json.RawMessage `pg:"response,type:jsonb"`
+	CreatedAt   time.Time       `pg:"created_at,default:now()"`
+}
+
+// hashRequestBody fingerprints a request body so a reused key can be matched against it
+func hashRequestBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// IdempotencyRepository stores responses by idempotency key
+type IdempotencyRepository interface {
+	// FindIdempotencyRecord returns nil when the key is unknown or has expired
+	FindIdempotencyRecord(ctx context.Context, key string) (*IdempotencyRecord, error)
+	SaveIdempotencyRecord(ctx context.Context, rec *IdempotencyRecord) error
+}
+
+type sqlIdempotencyRepository struct {
+	db DB
+}
+
+func newSQLIdempotencyRepository(db DB) *sqlIdempotencyRepository {
+	return &sqlIdempotencyRepository{db: db}
+}
+
+func (r *sqlIdempotencyRepository) FindIdempotencyRecord(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	rec := new(IdempotencyRecord)
+	err := r.db.Select(ctx, rec, `
+		SELECT * FROM idempotency_keys
+		WHERE tenant_id = ? AND key = ? AND created_at > ?`,
+		tenantFromContext(ctx), key, time.Now().Add(-idempotencyKeyTTL))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// SaveIdempotencyRecord stores rec, replacing an expired record with the same key.
+// A live record is left alone, so the first response for a key always wins.
+func (r *sqlIdempotencyRepository) SaveIdempotencyRecord(ctx context.Context, rec *IdempotencyRecord) error {
+	rec.TenantID = tenantFromContext(ctx)
+	_, err := r.db.Insert(ctx, nil, `
+		INSERT INTO idempotency_keys (tenant_id, key, request_hash, status, response)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (tenant_id, key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			status = EXCLUDED.status,
+			response = EXCLUDED.response,
+			created_at = `+r.db.dialect().now+`
+		WHERE idempotency_keys.created_at <= ?`,
+		rec.TenantID, rec.Key, rec.RequestHash, rec.Status, rec.Response, time.Now().Add(-idempotencyKeyTTL))
+	return err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ========== CSV Import ==========
+
+const (
+	maxImportRows  = 10000
+	maxImportBytes = 10 << 20
+)
+
+// importColumns are the CSV header names; currency is optional
+var importColumns = []string{"id", "title", "artist", "price", "currency"}
+
+// importRow is a valid album read from line Line of an import file
+type importRow struct {
+	Line  int
+	Album Album
+}
+
+// importRowError is a problem with one line of an import file
+type importRowError struct {
+	Line  int    `json:"line"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// importSummary is the result of POST /albums/import. With errors, nothing is imported.
+type importSummary struct {
+	DryRun   bool             `json:"dry_run"`
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Errors   []importRowError `json:"errors"`
+}
+
+// importAlbums serves POST /albums/import with a CSV body. Every row is checked
+// before anything is written, and the albums are inserted in one transaction,
+// so an import either succeeds completely or changes nothing. With
+// ?dry_run=true the transaction is always rolled back and the summary says
+// what would have happened.
+func (s *Server) importAlbums(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			sendError(w, APIError{Code: ErrValidation, Message: "dry_run must be true or false", Field: "dry_run"}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, rowErrs, err := parseImportCSV(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	summary := importSummary{DryRun: dryRun, Total: len(rows) + len(rowErrs), Errors: rowErrs}
+
+	if len(rowErrs) == 0 {
+		albums := make([]Album, len(rows))
+		for i, row := range rows {
+			albums[i] = row.Album
+		}
+
+		existing, err := s.albums.ImportAlbums(r.Context(), albums, dryRun)
+		switch {
+		case errors.Is(err, errAlbumExists) && len(existing) == 0:
+			// Another request created one of the albums while we were importing
+			sendError(w, APIError{Code: ErrConflict, Message: err.Error()}, http.StatusConflict)
+			return
+		case err != nil && !errors.Is(err, errAlbumExists):
+			sendStorageError(w, err)
+			return
+		}
+		for _, row := range rows {
+			if existing[row.Album.ID] {
+				summary.Errors = append(summary.Errors, importRowError{Line: row.Line, Field: "id", Error: errAlbumExists.Error()})
+			}
+		}
+		if len(summary.Errors) == 0 {
+			summary.Imported = len(albums)
+		}
+	}
+	if summary.Errors == nil {
+		summary.Errors = []importRowError{}
+	}
+
+	switch {
+	case dryRun:
+		sendJSON(w, http.StatusOK, summary)
+	case len(summary.Errors) > 0:
+		sendError(w, APIError{
+			Code:    ErrValidation,
+			Message: fmt.Sprintf("%d of %d rows are invalid, nothing was imported", len(summary.Errors), summary.Total),
+			Details: summary,
+		}, http.StatusBadRequest)
+	default:
+		s.listCache.invalidate()
+		sendJSON(w, http.StatusCreated, summary)
+	}
+}
+
+// parseImportCSV reads albums from a CSV file with a header row naming the
+// importColumns in any order. Rows that fail validateAlbum are reported with
+// their line number instead of being returned. A malformed file is an error.
+func parseImportCSV(body io.Reader) ([]importRow, []importRowError, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("import file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	col := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !isSelected(importColumns, name) {
+			return nil, nil, fmt.Errorf("unknown CSV column %q, expected %s", name, strings.Join(importColumns, ", "))
+		}
+		col[name] = i
+	}
+	for _, name := range []string{"id", "title", "artist", "price"} {
+		if _, ok := col[name]; !ok {
+			return nil, nil, fmt.Errorf("CSV header is missing the %q column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := col[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []importRow
+	var rowErrs []importRowError
+	seen := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(rows)+len(rowErrs) >= maxImportRows {
+			return nil, nil, fmt.Errorf("import file must have at most %d rows", maxImportRows)
+		}
+
+		a := Album{
+			ID:       field(record, "id"),
+			Title:    field(record, "title"),
+			Artist:   field(record, "artist"),
+			Currency: field(record, "currency"),
+		}
+		price, err := strconv.ParseFloat(field(record, "price"), 64)
+		if err != nil || math.IsNaN(price) || math.IsInf(price, 0) {
+			rowErrs = append(rowErrs, importRowError{Line: line, Field: "price", Error: "price must be a number"})
+			continue
+		}
+		a.Price = price
+
+		if errs := validateAlbum(&a); errs != nil {
+			for _, fe := range errs {
+				rowErrs = append(rowErrs, importRowError{Line: line, Field: fe.Field, Error: fe.Message})
+			}
+			continue
+		}
+		if first, dup := seen[a.ID]; dup {
+			rowErrs = append(rowErrs, importRowError{Line: line, Field: "id", Error: fmt.Sprintf("id is already used on line %d", first)})
+			continue
+		}
+		seen[a.ID] = line
+		rows = append(rows, importRow{Line: line, Album: a})
+	}
+	return rows, rowErrs, nil
+}
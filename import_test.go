@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postCSV sends body to POST /v1/albums/import, decoding the response into out
+func postCSV(t *testing.T, h http.Handler, query, body string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/albums/import"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil {
+		decodeJSON(t, rec, out)
+	}
+	return rec
+}
+
+func TestImportAlbums(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	const csv = "title, ID, artist, price, currency\n" +
+		"Jeru, 1, Gerry Mulligan, 17.99,\n" +
+		"Time Out, 2, Dave Brubeck, 22, eur\n"
+
+	var summary importSummary
+	if rec := postCSV(t, h, "?dry_run=true", csv, &summary); rec.Code != http.StatusOK || !summary.DryRun || summary.Imported != 2 {
+		t.Fatalf("dry run: status %d, %+v", rec.Code, summary)
+	}
+	var albums []Album
+	if serve(t, h, http.MethodGet, "/v1/albums", nil, nil, &albums); len(albums) != 0 {
+		t.Fatalf("dry run stored %d albums", len(albums))
+	}
+
+	if rec := postCSV(t, h, "", csv, &summary); rec.Code != http.StatusCreated || summary.Total != 2 || summary.Imported != 2 {
+		t.Fatalf("import: status %d, %+v", rec.Code, summary)
+	}
+	serve(t, h, http.MethodGet, "/v1/albums", nil, nil, &albums)
+	if len(albums) != 2 || albums[1].Currency != "EUR" || albums[0].Currency != defaultCurrency {
+		t.Errorf("albums %+v, want both, with their currencies", albums)
+	}
+
+	// Importing them again fails on every taken id, and imports nothing
+	var apiErr struct {
+		Code    string        `json:"code"`
+		Details importSummary `json:"details"`
+	}
+	if rec := postCSV(t, h, "", csv+"Giant Steps, 3, John Coltrane, 24.99,\n", &apiErr); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reimport: status %d: %s", rec.Code, rec.Body)
+	}
+	if errs := apiErr.Details.Errors; len(errs) != 2 || errs[0].Line != 2 || errs[0].Field != "id" || apiErr.Details.Imported != 0 {
+		t.Errorf("reimport errors %+v, want lines 2 and 3 on id", apiErr.Details)
+	}
+	if rec := serve(t, h, http.MethodHead, "/v1/albums/3", nil, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("album 3 was imported along with taken ids")
+	}
+}
+
+func TestImportAlbumsRejectsBadFiles(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+
+	for _, tt := range []struct {
+		name, query, csv string
+		lines            []int // of the row errors, nil when the whole file is refused
+	}{
+		{"bad dry_run", "?dry_run=maybe", "id,title,artist,price\n", nil},
+		{"empty", "", "", nil},
+		{"unknown column", "", "id,title,artist,price,label\n", nil},
+		{"missing column", "", "id,title,artist\n", nil},
+		{"malformed", "", "id,title,artist,price\n1,\"Jeru,Gerry Mulligan,1\n", nil},
+		{"invalid rows", "", "id,title,artist,price\n1,Jeru,Gerry Mulligan,cheap\n2,,Dave Brubeck,1\n2,Time Out,Dave Brubeck,1\n2,Again,Dave Brubeck,1\n", []int{2, 3, 5}},
+	} {
+		var apiErr struct {
+			Details json.RawMessage `json:"details"`
+		}
+		rec := postCSV(t, h, tt.query, tt.csv, &apiErr)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400: %s", tt.name, rec.Code, rec.Body)
+			continue
+		}
+		var summary importSummary
+		if tt.lines != nil {
+			if err := json.Unmarshal(apiErr.Details, &summary); err != nil {
+				t.Fatalf("%s: details %s: %v", tt.name, apiErr.Details, err)
+			}
+		}
+		var lines []int
+		for _, e := range summary.Errors {
+			lines = append(lines, e.Line)
+		}
+		if len(lines) != len(tt.lines) {
+			t.Errorf("%s: errors on lines %v, want %v", tt.name, lines, tt.lines)
+		}
+	}
+}
+
+func TestParseImportCSVRowLimit(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("id,title,artist,price\n")
+	for i := 0; i <= maxImportRows; i++ {
+		b.WriteString("x,T,A,1\n")
+	}
+	if _, _, err := parseImportCSV(strings.NewReader(b.String())); err == nil {
+		t.Errorf("%d rows accepted, want at most %d", maxImportRows+1, maxImportRows)
+	}
+}
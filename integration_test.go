@@ -0,0 +1,396 @@
+//go:build integration
+
+// Integration tests run the whole HTTP stack against a real PostgreSQL, so
+// the SQL is exercised as well as the handlers. They need Docker:
+//
+//	go test -tags integration ./...
+//
+// TestMain starts one postgres container for the suite and removes it
+// afterwards; POSTGRES_IMAGE picks another image than postgres:16-alpine.
+//
+// The container is run with the docker CLI, not testcontainers-go: the one
+// release of it this module can fetch, v0.14.0, only builds against the
+// docker v20.10 client, and the oldest docker client available here is
+// v24, whose ContainerStop takes options instead of a timeout.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/google/uuid"
+)
+
+var (
+	// integrationURL is the base URL of the API under test, e.g. "http://127.0.0.1:1234/v1"
+	integrationURL string
+	// integrationDSN reaches the test database; integrationDB is the server's pool on it
+	integrationDSN string
+	integrationDB  *pg.DB
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runIntegrationTests(m))
+}
+
+func runIntegrationTests(m *testing.M) int {
+	dsn, stop, err := startPostgres()
+	if err != nil {
+		log.Printf("Failed to start PostgreSQL: %v", err)
+		return 1
+	}
+	defer stop()
+
+	os.Setenv("DATABASE_URL", dsn)
+	db := openDB()
+	defer db.Close()
+	integrationDSN, integrationDB = dsn, db
+	if _, err := runMigrations(context.Background(), db); err != nil {
+		log.Printf("Failed to migrate: %v", err)
+		return 1
+	}
+
+	pool := pgDB{db: db}
+	srv := &Server{
+		albums:      newBreakerAlbumRepository(newSQLAlbumRepository(pool, nil), loadBreakerSettings(), nil),
+		audit:       newSQLAuditLogRepository(pool),
+		history:     newSQLAlbumEventRepository(pool),
+		topAlbums:   newAlbumListCache(topAlbumsCacheSize, topAlbumsCacheTTL),
+		idempotency: newSQLIdempotencyRepository(pool),
+		stats:       newStatsWorker(pool),
+		events:      newAlbumEventBroker(pool),
+		webhooks:    newSQLWebhookRepository(pool),
+		playlists:   newSQLPlaylistRepository(pool),
+	}
+	config := &runtimeConfig{maintenance: &maintenanceMode{}, slowQueries: &slowQueryHook{}}
+	handler, err := newRouter(srv, pool, config, &inFlightRequests{})
+	if err != nil {
+		log.Printf("Failed to set up routes: %v", err)
+		return 1
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	integrationURL = ts.URL + "/v1"
+
+	return m.Run()
+}
+
+// startPostgres runs a throwaway postgres container on a free local port and
+// waits until it accepts queries. stop removes the container.
+func startPostgres() (dsn string, stop func(), err error) {
+	image := os.Getenv("POSTGRES_IMAGE")
+	if image == "" {
+		image = "postgres:16-alpine"
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_USER=albums", "-e", "POSTGRES_PASSWORD=albums", "-e", "POSTGRES_DB=albums",
+		"-p", "127.0.0.1::5432", image).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("docker run: %w", err)
+	}
+	id := strings.TrimSpace(string(out))
+	stop = func() {
+		if err := exec.Command("docker", "rm", "-f", id).Run(); err != nil {
+			log.Printf("Failed to remove container %s: %v", id, err)
+		}
+	}
+
+	out, err = exec.Command("docker", "port", id, "5432/tcp").Output()
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("docker port: %w", err)
+	}
+	// One line per address family, e.g. "127.0.0.1:49153"
+	addr := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	dsn = "postgres://albums:albums@" + addr + "/albums?sslmode=disable"
+
+	opts, err := pg.ParseURL(dsn)
+	if err != nil {
+		stop()
+		return "", nil, err
+	}
+	db := pg.Connect(opts)
+	defer db.Close()
+	deadline := time.Now().Add(time.Minute)
+	for {
+		// The image listens on TCP only once its initialization is done
+		_, err := db.Exec("SELECT 1")
+		if err == nil {
+			return dsn, stop, nil
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return "", nil, fmt.Errorf("postgres did not come up: %w", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// call sends a request to the API with a JSON body, unless body is nil, and
+// decodes the JSON response into out, unless out is nil
+func call(t *testing.T, method, path string, body interface{}, header http.Header, out interface{}) *http.Response {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, integrationURL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, data, err)
+		}
+	}
+	return resp
+}
+
+// createAlbum posts an album with a fresh id and deletes it when the test ends
+func createAlbum(t *testing.T, title, artist string, price float64) Album {
+	t.Helper()
+	in := Album{ID: uuid.New().String(), Title: title, Artist: artist, Price: price, Currency: "usd"}
+	var created Album
+	if resp := call(t, http.MethodPost, "/albums", in, nil, &created); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /albums: status %d", resp.StatusCode)
+	}
+	t.Cleanup(func() {
+		call(t, http.MethodDelete, "/albums/"+created.ID, nil, nil, nil)
+	})
+	return created
+}
+
+func TestIntegrationAlbumRoundTrip(t *testing.T) {
+	created := createAlbum(t, "Blue Train", "John Coltrane", 56.99)
+	if created.Currency != "USD" || created.Version != 1 || created.CreatedAt.IsZero() {
+		t.Errorf("created %+v, want USD, version 1 and a creation time", created)
+	}
+
+	var got Album
+	resp := call(t, http.MethodGet, "/albums/"+created.ID, nil, nil, &got)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET: status %d", resp.StatusCode)
+	}
+	if got.Title != "Blue Train" || got.Artist != "John Coltrane" || got.Price != 56.99 {
+		t.Errorf("GET returned %+v", got)
+	}
+	etag := resp.Header.Get("ETag")
+
+	var patched Album
+	header := http.Header{"If-Match": {etag}, "Content-Type": {mergePatchContentType}}
+	resp = call(t, http.MethodPatch, "/albums/"+created.ID, map[string]interface{}{"price": 39.5}, header, &patched)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH: status %d", resp.StatusCode)
+	}
+	if patched.Price != 39.5 || patched.Version != 2 {
+		t.Errorf("PATCH returned %+v, want price 39.5 at version 2", patched)
+	}
+	// The old version is gone, so patching it again is a conflict
+	if resp := call(t, http.MethodPatch, "/albums/"+created.ID, map[string]interface{}{"price": 1}, header, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("stale PATCH: status %d, want 409", resp.StatusCode)
+	}
+
+	var history []PriceChange
+	call(t, http.MethodGet, "/albums/"+created.ID+"/price-history", nil, nil, &history)
+	if len(history) != 1 || history[0].From != 56.99 || history[0].To != 39.5 {
+		t.Errorf("price history %+v, want one change from 56.99 to 39.5", history)
+	}
+
+	if resp := call(t, http.MethodDelete, "/albums/"+created.ID, nil, nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d", resp.StatusCode)
+	}
+	if resp := call(t, http.MethodGet, "/albums/"+created.ID, nil, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after DELETE: status %d, want 404", resp.StatusCode)
+	}
+}
+
+// POST answers with the row as INSERT ... RETURNING * read it back, so the
+// defaults PostgreSQL filled in reach the client
+func TestIntegrationPostReturnsDatabaseDefaults(t *testing.T) {
+	var now time.Time
+	if _, err := integrationDB.QueryOne(pg.Scan(&now), "SELECT now()"); err != nil {
+		t.Fatal(err)
+	}
+
+	in := map[string]interface{}{
+		"id": uuid.New().String(), "title": "Ballads", "artist": "John Coltrane", "price": 19.99,
+		"created_at": "2000-01-01T00:00:00Z", "version": 9,
+	}
+	var created Album
+	if resp := call(t, http.MethodPost, "/albums", in, nil, &created); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: status %d", resp.StatusCode)
+	}
+	t.Cleanup(func() {
+		call(t, http.MethodDelete, "/albums/"+created.ID, nil, nil, nil)
+	})
+
+	if created.CreatedAt.Sub(now).Abs() > time.Minute {
+		t.Errorf("created_at = %v, want the database's now(), %v", created.CreatedAt, now)
+	}
+	if !created.UpdatedAt.Equal(created.CreatedAt) || created.Version != 1 {
+		t.Errorf("updated_at %v, version %d; want created_at and 1", created.UpdatedAt, created.Version)
+	}
+
+	var stored Album
+	call(t, http.MethodGet, "/albums/"+created.ID, nil, nil, &stored)
+	if !stored.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("stored created_at %v differs from the returned %v", stored.CreatedAt, created.CreatedAt)
+	}
+}
+
+func TestIntegrationCreateDuplicate(t *testing.T) {
+	created := createAlbum(t, "Jeru", "Gerry Mulligan", 17.99)
+	in := Album{ID: created.ID, Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}
+	if resp := call(t, http.MethodPost, "/albums", in, nil, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("duplicate POST: status %d, want 409", resp.StatusCode)
+	}
+}
+
+func TestIntegrationListFilters(t *testing.T) {
+	// An artist no other test uses keeps the counts exact
+	artist := "Filter Test " + uuid.New().String()[:8]
+	cheap := createAlbum(t, "Cheap", artist, 9.99)
+	createAlbum(t, "Dear", "  "+strings.ToUpper(artist)+" ", 99.99)
+	createAlbum(t, "Middle", artist, 49.5)
+
+	var albums []Album
+	query := url.Values{"artist": {strings.ToLower(artist)}, "max_price": {"50"}, "limit": {"1"}}
+	resp := call(t, http.MethodGet, "/albums?"+query.Encode(), nil, nil, &albums)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Total-Count"); got != "2" {
+		t.Errorf("X-Total-Count = %q, want 2", got)
+	}
+	if len(albums) != 1 {
+		t.Fatalf("got %d albums on a page of 1", len(albums))
+	}
+
+	var page artistAlbums
+	path := "/artists/" + url.PathEscape(artist) + "/albums?sort=price"
+	if resp := call(t, http.MethodGet, path, nil, nil, &page); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+	}
+	if page.Artist.Count != 3 || page.Total != 3 || len(page.Albums) != 3 {
+		t.Fatalf("got %+v, want all 3 albums of the artist", page)
+	}
+	if page.Albums[0].ID != cheap.ID || page.Albums[2].Price != 99.99 {
+		t.Errorf("albums not ordered by price: %+v", page.Albums)
+	}
+}
+
+func TestIntegrationTagsAndReviews(t *testing.T) {
+	album := createAlbum(t, "Kind of Blue", "Miles Davis", 29.99)
+	tag := "modal-" + uuid.New().String()[:8]
+
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	if resp := call(t, http.MethodPost, "/albums/"+album.ID+"/tags", map[string][]string{"tags": {tag}}, nil, &tags); resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST tags: status %d", resp.StatusCode)
+	}
+	if len(tags.Tags) != 1 || tags.Tags[0] != tag {
+		t.Errorf("album tags %v, want [%s]", tags.Tags, tag)
+	}
+	var tagged []Album
+	call(t, http.MethodGet, "/albums?tag="+tag, nil, nil, &tagged)
+	if len(tagged) != 1 || tagged[0].ID != album.ID {
+		t.Errorf("?tag= returned %+v, want only the tagged album", tagged)
+	}
+
+	review := map[string]interface{}{"author_name": "Ann", "rating": 5, "body": "Timeless"}
+	if resp := call(t, http.MethodPost, "/albums/"+album.ID+"/reviews", review, nil, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST review: status %d", resp.StatusCode)
+	}
+	var got Album
+	call(t, http.MethodGet, "/albums/"+album.ID, nil, nil, &got)
+	if got.ReviewCount == nil || *got.ReviewCount != 1 || got.AverageRating == nil || *got.AverageRating != 5 {
+		t.Errorf("review stats %v/%v, want 1 review rated 5", got.ReviewCount, got.AverageRating)
+	}
+}
+
+// A request whose client goes away must cancel its query in PostgreSQL and
+// hand the connection back, not leave it waiting on the database
+func TestIntegrationCancelledRequestReleasesConnection(t *testing.T) {
+	createAlbum(t, "Giant Steps", "John Coltrane", 24.99)
+
+	// Hold a lock on albums from a connection of our own, so the list waits on it
+	opts, err := pg.ParseURL(integrationDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := pg.Connect(opts)
+	defer locker.Close()
+	tx, err := locker.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("LOCK TABLE albums IN ACCESS EXCLUSIVE MODE"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, integrationURL+"/albums", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("list answered %d while albums was locked", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var waiting int
+		_, err := locker.QueryOne(pg.Scan(&waiting), `
+			SELECT count(*) FROM pg_stat_activity
+			WHERE datname = current_database() AND wait_event_type = 'Lock'`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats := integrationDB.PoolStats()
+		if waiting == 0 && stats.TotalConns == stats.IdleConns {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("2s after the client left: %d queries still wait on the lock, %d of %d connections in use",
+				waiting, stats.TotalConns-stats.IdleConns, stats.TotalConns)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// ========== JSON Key Case ==========
+
+// jsonKeysCamelCase is set from JSON_CASE=camel and turns response keys such
+// as created_at into createdAt
+var jsonKeysCamelCase bool
+
+// loadJSONCase reads JSON_CASE ("snake", the default, or "camel")
+func loadJSONCase() {
+	switch v := os.Getenv("JSON_CASE"); v {
+	case "", "snake":
+		jsonKeysCamelCase = false
+	case "camel":
+		jsonKeysCamelCase = true
+	default:
+		log.Fatalf("JSON_CASE must be \"snake\" or \"camel\", got %q", v)
+	}
+}
+
+// encodeJSON marshals v for a response body, with the object keys in the
+// case chosen by JSON_CASE
+func encodeJSON(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || !jsonKeysCamelCase {
+		return data, err
+	}
+	return camelCaseKeys(data)
+}
+
+// camelCaseKeys rewrites every object key in data from snake_case to
+// camelCase, keeping the order of keys and everything else as it was
+func camelCaseKeys(data []byte) ([]byte, error) {
+	type container struct {
+		object  bool
+		count   int  // values written so far
+		wantKey bool // objects alternate between keys and values
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out bytes.Buffer
+	var stack []*container
+
+	// valueDone moves the enclosing container past the value just written
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		top := stack[len(stack)-1]
+		top.count++
+		top.wantKey = top.object
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var top *container
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			out.WriteByte(byte(delim))
+			stack = stack[:len(stack)-1]
+			valueDone()
+			continue
+		}
+
+		if top != nil && top.count > 0 && (top.wantKey || !top.object) {
+			out.WriteByte(',')
+		}
+		if top != nil && top.wantKey {
+			key, err := json.Marshal(snakeToCamel(tok.(string)))
+			if err != nil {
+				return nil, err
+			}
+			out.Write(key)
+			out.WriteByte(':')
+			top.wantKey = false
+			continue
+		}
+
+		switch tok := tok.(type) {
+		case json.Delim: // '{' or '['
+			out.WriteByte(byte(tok))
+			stack = append(stack, &container{object: tok == '{', wantKey: tok == '{'})
+			continue
+		case json.Number:
+			out.WriteString(tok.String())
+		case nil:
+			out.WriteString("null")
+		case string, bool:
+			b, err := json.Marshal(tok)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(b)
+		default:
+			return nil, fmt.Errorf("unexpected JSON token %v", tok)
+		}
+		valueDone()
+	}
+}
+
+// snakeToCamel turns created_at into createdAt. Only an underscore between
+// two letters or digits is folded, so _links and a__b keep theirs
+func snakeToCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' && i > 0 && i+1 < len(s) && s[i-1] != '_' && s[i+1] != '_' {
+			i++
+			b.WriteString(strings.ToUpper(s[i : i+1]))
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
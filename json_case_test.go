@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestSnakeToCamel(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"id", "id"},
+		{"cover_url", "coverUrl"},
+		{"created_at", "createdAt"},
+		{"price_2024", "price2024"},
+		{"_links", "_links"},
+		{"_embedded", "_embedded"},
+		{"a__b", "a__b"},
+		{"trailing_", "trailing_"},
+	} {
+		if got := snakeToCamel(tt.in); got != tt.want {
+			t.Errorf("snakeToCamel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCamelCaseKeysKeepsHALLinks(t *testing.T) {
+	got, err := camelCaseKeys([]byte(`{"_links":{"self":{"href":"/v1/albums/1"}},"cover_url":null,"created_at":"2024-01-01T00:00:00Z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"_links":{"self":{"href":"/v1/albums/1"}},"coverUrl":null,"createdAt":"2024-01-01T00:00:00Z"}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestLoadJSONCase(t *testing.T) {
+	defer func() { jsonKeysCamelCase = false }()
+	t.Setenv("JSON_CASE", "camel")
+	loadJSONCase()
+	if got, err := encodeJSON(map[string]int{"review_count": 2}); err != nil || string(got) != `{"reviewCount":2}` {
+		t.Errorf("JSON_CASE=camel: %s, %v", got, err)
+	}
+	t.Setenv("JSON_CASE", "snake")
+	loadJSONCase()
+	if got, _ := encodeJSON(map[string]int{"review_count": 2}); string(got) != `{"review_count":2}` {
+		t.Errorf("JSON_CASE=snake: %s", got)
+	}
+}
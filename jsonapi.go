@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ========== JSON:API ==========
+
+// Response formats selectable with ?format=
+const (
+	formatDefault = ""
+	formatJSONAPI = "jsonapi"
+)
+
+const jsonAPIContentType = "application/vnd.api+json"
+
+// parseFormat reads ?format=, which is either absent or "jsonapi"
+func parseFormat(r *http.Request) (string, *FieldError) {
+	switch format := r.URL.Query().Get("format"); format {
+	case formatDefault, formatJSONAPI:
+		return format, nil
+	default:
+		return "", &FieldError{Field: "format", Message: fmt.Sprintf("unsupported format %q", format)}
+	}
+}
+
+// toJSONAPI converts an album into a JSON:API resource object
+func toJSONAPI(ctx context.Context, album Album) map[string]interface{} {
+	return jsonAPIResource(ctx, album, albumFields)
+}
+
+// jsonAPIResource is toJSONAPI restricted to the given sparse fieldset.
+// The id is always the resource identifier, never an attribute.
+func jsonAPIResource(ctx context.Context, album Album, fields []string) map[string]interface{} {
+	attributes := withReviewStats(projectAlbum(album, fields), album)
+	delete(attributes, "id")
+
+	return map[string]interface{}{
+		"type":       "albums",
+		"id":         album.ID,
+		"attributes": attributes,
+		"links": map[string]string{
+			"self": apiPath(ctx, "/albums/"+album.ID),
+		},
+	}
+}
+
+func sendJSONAPI(w http.ResponseWriter, status int, doc map[string]interface{}) {
+	data, err := encodeJSON(doc)
+	if err != nil {
+		log.Printf("Failed to encode JSON:API response: %v", err)
+		sendError(w, APIError{Code: ErrInternal, Message: "failed to encode response"}, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonAPIContentType)
+	w.WriteHeader(status)
+	w.Write(append(prettyJSON(w, data), '\n'))
+}
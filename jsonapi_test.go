@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// jsonAPIDocument is the part of a JSON:API response the tests look at
+type jsonAPIDocument struct {
+	Data  []jsonAPIData     `json:"data"`
+	Links map[string]string `json:"links"`
+}
+
+type jsonAPIData struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+func TestGetAlbumsJSONAPI(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?format=jsonapi&fields=title&after=&limit=2", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != jsonAPIContentType {
+		t.Fatalf("status %d, Content-Type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	var doc jsonAPIDocument
+	decodeJSONAPI(t, rec, &doc)
+	if len(doc.Data) != 2 || doc.Data[0].ID != "1" || doc.Data[0].Type != "albums" {
+		t.Fatalf("data %+v, want albums 1 and 2", doc.Data)
+	}
+	if _, ok := doc.Data[0].Attributes["id"]; ok || doc.Data[0].Attributes["title"] != "Blue Train" {
+		t.Errorf("attributes %v, want the title without the id", doc.Data[0].Attributes)
+	}
+	if doc.Links["next"] != "/albums?after=2&fields=title&format=jsonapi&limit=2" {
+		t.Errorf("next link %q", doc.Links["next"])
+	}
+
+	rec = httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("?format=xml: status %d, want 400", rec.Code)
+	}
+}
+
+func TestGetAlbumByIDJSONAPI(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbumByID(rec, httptest.NewRequest(http.MethodGet, "/albums/2?format=jsonapi", nil), "2")
+	var doc struct {
+		Data jsonAPIData `json:"data"`
+	}
+	decodeJSONAPI(t, rec, &doc)
+	if doc.Data.ID != "2" || doc.Data.Attributes["artist"] != "Gerry Mulligan" {
+		t.Errorf("got %+v, want album 2", doc.Data)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.getAlbumByID(rec, httptest.NewRequest(http.MethodGet, "/albums/2?format=xml", nil), "2")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("?format=xml: status %d, want 400", rec.Code)
+	}
+}
+
+func decodeJSONAPI(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	rec.Header().Set("Content-Type", "application/json")
+	decodeJSON(t, rec, v)
+}
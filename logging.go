@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ========== Request Logging ==========
+
+// maxLoggedBody is how much of a request or response body is kept for the
+// log; longer bodies are logged by size only
+const maxLoggedBody = 64 << 10
+
+// defaultMaskedFields applies when LOG_MASKED_FIELDS is not set
+const defaultMaskedFields = "price"
+
+// maskedFields are the JSON keys whose values are replaced by "***" in logged bodies
+var maskedFields map[string]bool
+
+// loadMaskedFields reads LOG_MASKED_FIELDS, a comma-separated list of JSON
+// keys. Set it to an empty value to log bodies unchanged.
+func loadMaskedFields() {
+	v, ok := os.LookupEnv("LOG_MASKED_FIELDS")
+	if !ok {
+		v = defaultMaskedFields
+	}
+	maskedFields = make(map[string]bool)
+	for _, field := range strings.Split(v, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			maskedFields[field] = true
+			maskedFields[snakeToCamel(field)] = true // as sent with JSON_CASE=camel
+		}
+	}
+}
+
+// maskSensitiveFields returns body with the value of every maskedFields key,
+// at any depth, replaced by "***". Anything that isn't JSON is replaced
+// as a whole, since it can't be masked.
+func maskSensitiveFields(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return []byte("<unreadable JSON>")
+	}
+	masked, err := json.Marshal(maskValue(v))
+	if err != nil {
+		return []byte("<unreadable JSON>")
+	}
+	return masked
+}
+
+func maskValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if maskedFields[key] {
+				v[key] = "***"
+			} else {
+				v[key] = maskValue(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = maskValue(value)
+		}
+	}
+	return v
+}
+
+// bodyLog keeps the start of a body for the log and counts its full size
+type bodyLog struct {
+	buf  bytes.Buffer
+	size int
+}
+
+func (b *bodyLog) Write(p []byte) (int, error) {
+	b.size += len(p)
+	if room := maxLoggedBody - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// loggable gives the body as it should appear in the log: masked JSON, or
+// just its size when it is too long or of another type
+func (b *bodyLog) loggable(contentType string) string {
+	switch {
+	case b.size == 0:
+		return ""
+	case b.size > maxLoggedBody:
+		return fmt.Sprintf("<%d bytes>", b.size)
+	case !strings.Contains(contentType, "json"):
+		return fmt.Sprintf("<%d bytes of %s>", b.size, contentType)
+	}
+	return string(maskSensitiveFields(b.buf.Bytes()))
+}
+
+// bodyRecorder copies what the handler writes into a bodyLog
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bodyLog
+}
+
+func (w *bodyRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// requestLoggingMiddleware logs every request with its status, duration and
+// bodies at debug level, with maskedFields hidden. Streams are not logged.
+func requestLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logger.Enabled(r.Context(), slog.LevelDebug) || wantsAlbumStream(r) || wantsEventStream(r) || isWebSocket(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		var reqBody bodyLog
+		if r.Body != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, &reqBody), r.Body}
+		}
+		rec := &bodyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK // nothing written, which net/http sends as 200
+		}
+
+		logger.Debug("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"request_body", reqBody.loggable(r.Header.Get("Content-Type")),
+			"response_body", rec.body.loggable(rec.Header().Get("Content-Type")),
+		)
+	})
+}
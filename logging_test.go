@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaskSensitiveFields(t *testing.T) {
+	t.Setenv("LOG_MASKED_FIELDS", "price, cover_url")
+	loadMaskedFields()
+	defer func() {
+		t.Setenv("LOG_MASKED_FIELDS", defaultMaskedFields)
+		loadMaskedFields()
+	}()
+
+	for _, tt := range []struct{ in, want string }{
+		{`{"id":"1","price":17.99}`, `{"id":"1","price":"***"}`},
+		{`[{"coverUrl":"x","tags":[{"price":1}]}]`, `[{"coverUrl":"***","tags":[{"price":"***"}]}]`},
+		{`{"big":12345678901234567890}`, `{"big":12345678901234567890}`},
+		{`not json`, `<unreadable JSON>`},
+	} {
+		if got := maskSensitiveFields([]byte(tt.in)); string(got) != tt.want {
+			t.Errorf("maskSensitiveFields(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	t.Setenv("LOG_MASKED_FIELDS", "")
+	loadMaskedFields()
+	if got := maskSensitiveFields([]byte(`{"price":1}`)); string(got) != `{"price":1}` {
+		t.Errorf("with nothing masked: %s", got)
+	}
+}
+
+func TestBodyLog(t *testing.T) {
+	var b bodyLog
+	if got := b.loggable("application/json"); got != "" {
+		t.Errorf("empty body logged as %q", got)
+	}
+	b.Write([]byte(`{"price":1}`))
+	if got := b.loggable("text/csv"); got != "<11 bytes of text/csv>" {
+		t.Errorf("CSV body logged as %q", got)
+	}
+	b.Write(bytes.Repeat([]byte("x"), maxLoggedBody))
+	if got := b.loggable("application/json"); got != "<65547 bytes>" || b.buf.Len() != maxLoggedBody {
+		t.Errorf("long body logged as %q, keeping %d bytes", got, b.buf.Len())
+	}
+}
+
+func TestRequestLoggingMiddleware(t *testing.T) {
+	loadMaskedFields()
+	var out bytes.Buffer
+	saved := logger
+	logger = slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	defer func() { logger = saved }()
+
+	h := requestLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			return
+		}
+		io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"4","price":24.99}`))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/v1/albums", strings.NewReader(`{"price":24.99}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || rec.Body.String() != `{"id":"4","price":24.99}` {
+		t.Errorf("response changed: %d %s", rec.Code, rec.Body)
+	}
+	logged := out.String()
+	for _, want := range []string{"status=201", `request_body="{\"price\":\"***\"}"`, `response_body="{\"id\":\"4\",\"price\":\"***\"}"`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log %q doesn't contain %s", logged, want)
+		}
+	}
+	if strings.Contains(logged, "24.99") {
+		t.Errorf("log %q shows the price", logged)
+	}
+
+	out.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/empty", nil))
+	if !strings.Contains(out.String(), "status=200") {
+		t.Errorf("handler that wrote nothing logged as %q", out.String())
+	}
+
+	// Above debug level nothing is logged
+	out.Reset()
+	logger = slog.New(slog.NewTextHandler(&out, nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/empty", nil))
+	if out.Len() != 0 {
+		t.Errorf("logged %q at info level", out.String())
+	}
+}
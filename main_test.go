@@ -0,0 +1,988 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var testAlbums = []Album{
+	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99, Currency: "USD", Tags: []string{}, Version: 1},
+	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99, Currency: "USD", Tags: []string{}, Version: 1},
+	{ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99, Currency: "USD", Tags: []string{}, Version: 1},
+}
+
+func newTestServer(albums ...Album) (*Server, *fakeAlbumRepository) {
+	repo := newFakeAlbumRepository(albums...)
+	return &Server{albums: repo}, repo
+}
+
+// newTestRouter serves srv through the production middleware and routes.
+// Routes that need the database itself, such as /healthz, can't be tested with it.
+func newTestRouter(t *testing.T, srv *Server) http.Handler {
+	t.Helper()
+	config := &runtimeConfig{maintenance: &maintenanceMode{}, slowQueries: &slowQueryHook{}}
+	h, err := newRouter(srv, nil, config, &inFlightRequests{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// decodeJSON decodes the body of rec into v, failing the test if it isn't JSON
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func TestGetAlbums(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var albums []Album
+	decodeJSON(t, rec, &albums)
+	if len(albums) != len(testAlbums) {
+		t.Fatalf("got %d albums, want %d", len(albums), len(testAlbums))
+	}
+	for i, a := range albums {
+		if a.ID != testAlbums[i].ID {
+			t.Errorf("albums[%d].ID = %q, want %q", i, a.ID, testAlbums[i].ID)
+		}
+	}
+}
+
+func TestGetAlbumsFiltersAndPaginates(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?min_price=20&limit=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var albums []Album
+	decodeJSON(t, rec, &albums)
+	if len(albums) != 1 || albums[0].ID != "1" {
+		t.Errorf("got %+v, want only album 1", albums)
+	}
+	if got := rec.Header().Get("X-Total-Count"); got != "2" {
+		t.Errorf("X-Total-Count = %q, want 2", got)
+	}
+	if got := rec.Header().Get("Link"); !strings.Contains(got, `offset=1`) || !strings.Contains(got, `rel="next"`) {
+		t.Errorf("Link = %q, want a next page at offset=1", got)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?artist=+sarah+VAUGHAN", nil))
+	albums = nil
+	decodeJSON(t, rec, &albums)
+	if len(albums) != 1 || albums[0].ID != "3" {
+		t.Errorf("?artist= got %+v, want only album 3", albums)
+	}
+}
+
+func TestGetAlbumsRejectsBadQuery(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	for _, query := range []string{"limit=0", "offset=-1", "min_price=abc", "min_price=5&max_price=1", "fields=nope"} {
+		rec := httptest.NewRecorder()
+		srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status %d, want 400", query, rec.Code)
+			continue
+		}
+		var apiErr APIError
+		decodeJSON(t, rec, &apiErr)
+		if apiErr.Code != ErrValidation {
+			t.Errorf("?%s: code %q, want %s", query, apiErr.Code, ErrValidation)
+		}
+	}
+}
+
+func TestGetAlbumsStorageError(t *testing.T) {
+	srv, repo := newTestServer(testAlbums...)
+	repo.err = errDatabaseUnavailable
+
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("503 without Retry-After")
+	}
+}
+
+func TestGetAlbumByID(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbumByID(rec, httptest.NewRequest(http.MethodGet, "/albums/2", nil), "2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("ETag"); got != versionETag(1) {
+		t.Errorf("ETag = %q, want %q", got, versionETag(1))
+	}
+	var body struct {
+		Album
+		Links map[string]halLink `json:"_links"`
+	}
+	decodeJSON(t, rec, &body)
+	if body.Title != "Jeru" || body.Artist != "Gerry Mulligan" {
+		t.Errorf("got %+v, want album 2", body.Album)
+	}
+	if got := body.Links["self"].Href; !strings.HasSuffix(got, "/albums/2") {
+		t.Errorf("self link = %q", got)
+	}
+}
+
+func TestGetAlbumByIDFields(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbumByID(rec, httptest.NewRequest(http.MethodGet, "/albums/1?fields=title", nil), "1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var body map[string]interface{}
+	decodeJSON(t, rec, &body)
+	if body["title"] != "Blue Train" {
+		t.Errorf("title = %v, want Blue Train", body["title"])
+	}
+	if _, ok := body["artist"]; ok {
+		t.Error("artist returned although only title was asked for")
+	}
+}
+
+func TestGetAlbumByIDNotFound(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbumByID(rec, httptest.NewRequest(http.MethodGet, "/albums/42", nil), "42")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", rec.Code)
+	}
+	var apiErr APIError
+	decodeJSON(t, rec, &apiErr)
+	if apiErr.Code != ErrNotFound {
+		t.Errorf("code %q, want %s", apiErr.Code, ErrNotFound)
+	}
+}
+
+func TestPostAlbum(t *testing.T) {
+	srv, repo := newTestServer()
+
+	body := `{"id": "4", "title": "Kind of Blue", "artist": "Miles Davis", "price": 29.99, "currency": "eur", "version": 7}`
+	rec := httptest.NewRecorder()
+	srv.postAlbum(rec, httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var created Album
+	decodeJSON(t, rec, &created)
+	if created.Currency != "EUR" {
+		t.Errorf("currency = %q, want it normalized to EUR", created.Currency)
+	}
+	if created.Version != 1 {
+		t.Errorf("version = %d, want 1 whatever the client sent", created.Version)
+	}
+	if _, ok := repo.albums["4"]; !ok {
+		t.Error("album was not stored")
+	}
+
+	rec = httptest.NewRecorder()
+	srv.postAlbum(rec, httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(body)))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("duplicate id: status %d, want 409", rec.Code)
+	}
+}
+
+// The 201 body is the album as stored, with the timestamps and version the
+// repository filled in, never the ones the client sent
+func TestPostAlbumReturnsStoredAlbum(t *testing.T) {
+	srv, _ := newTestServer()
+
+	body := `{"id": "4", "title": "Kind of Blue", "artist": "Miles Davis", "price": 29.99, "created_at": "2000-01-01T00:00:00Z"}`
+	rec := httptest.NewRecorder()
+	srv.postAlbum(rec, httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var created map[string]interface{}
+	decodeJSON(t, rec, &created)
+	createdAt, _ := created["created_at"].(string)
+	if createdAt == "" || createdAt == "0001-01-01T00:00:00Z" || strings.HasPrefix(createdAt, "2000-") {
+		t.Errorf("created_at = %q, want the time the album was stored", createdAt)
+	}
+	if created["updated_at"] != createdAt {
+		t.Errorf("updated_at = %v, want it equal to created_at", created["updated_at"])
+	}
+}
+
+func TestPostAlbumInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		fields []string // fields the 422 must name
+	}{
+		{"not JSON", `{"id": `, http.StatusBadRequest, nil},
+		{"wrong type", `{"id": "5", "price": "cheap"}`, http.StatusBadRequest, nil},
+		{"missing fields", `{}`, http.StatusUnprocessableEntity, []string{"id", "title", "artist"}},
+		{"negative price", `{"id": "5", "title": "T", "artist": "A", "price": -1}`, http.StatusUnprocessableEntity, []string{"price"}},
+		{"sub-cent price", `{"id": "5", "title": "T", "artist": "A", "price": 1.001}`, http.StatusUnprocessableEntity, []string{"price"}},
+		{"unknown currency", `{"id": "5", "title": "T", "artist": "A", "price": 1, "currency": "XYZ"}`, http.StatusUnprocessableEntity, []string{"currency"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, repo := newTestServer()
+			rec := httptest.NewRecorder()
+			srv.postAlbum(rec, httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if len(repo.albums) != 0 {
+				t.Error("invalid album was stored")
+			}
+
+			var apiErr APIError
+			decodeJSON(t, rec, &apiErr)
+			named := make(map[string]bool)
+			for _, fe := range apiErr.Errors {
+				named[fe.Field] = true
+			}
+			for _, field := range tt.fields {
+				if !named[field] {
+					t.Errorf("errors %+v don't name %s", apiErr.Errors, field)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteAlbumByID(t *testing.T) {
+	srv, repo := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.deleteAlbumByID(rec, httptest.NewRequest(http.MethodDelete, "/albums/1", nil), "1")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status %d, want 204: %s", rec.Code, rec.Body)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("204 with a body: %q", rec.Body)
+	}
+	if _, ok := repo.albums["1"]; ok {
+		t.Error("album 1 is still stored")
+	}
+
+	rec = httptest.NewRecorder()
+	srv.deleteAlbumByID(rec, httptest.NewRequest(http.MethodDelete, "/albums/1", nil), "1")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: status %d, want 404", rec.Code)
+	}
+}
+
+// ctxRecordingRepository remembers the context of every call it passes on
+type ctxRecordingRepository struct {
+	AlbumRepository
+	ctxs []context.Context
+}
+
+func (r *ctxRecordingRepository) ListAlbums(ctx context.Context, opts AlbumListOptions) ([]Album, error) {
+	r.ctxs = append(r.ctxs, ctx)
+	return r.AlbumRepository.ListAlbums(ctx, opts)
+}
+
+func (r *ctxRecordingRepository) GetAlbumByID(ctx context.Context, id string, fields []string) (Album, error) {
+	r.ctxs = append(r.ctxs, ctx)
+	return r.AlbumRepository.GetAlbumByID(ctx, id, fields)
+}
+
+func (r *ctxRecordingRepository) CreateAlbum(ctx context.Context, album *Album) error {
+	r.ctxs = append(r.ctxs, ctx)
+	return r.AlbumRepository.CreateAlbum(ctx, album)
+}
+
+func (r *ctxRecordingRepository) DeleteAlbum(ctx context.Context, id string) error {
+	r.ctxs = append(r.ctxs, ctx)
+	return r.AlbumRepository.DeleteAlbum(ctx, id)
+}
+
+// The handlers must query with the request's context, so a client that goes
+// away or a timeout cancels the query; integration_test.go checks that
+// PostgreSQL actually drops it
+func TestHandlersQueryWithRequestContext(t *testing.T) {
+	type marker struct{}
+	repo := &ctxRecordingRepository{AlbumRepository: newFakeAlbumRepository(testAlbums...)}
+	srv := &Server{albums: repo}
+
+	request := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		return req.WithContext(context.WithValue(req.Context(), marker{}, target))
+	}
+	srv.getAlbums(httptest.NewRecorder(), request(http.MethodGet, "/albums", ""))
+	srv.getAlbumByID(httptest.NewRecorder(), request(http.MethodGet, "/albums/1", ""), "1")
+	srv.postAlbum(httptest.NewRecorder(), request(http.MethodPost, "/albums", `{"id": "9", "title": "T", "artist": "A", "price": 1}`))
+	srv.deleteAlbumByID(httptest.NewRecorder(), request(http.MethodDelete, "/albums/2", ""), "2")
+
+	if len(repo.ctxs) != 4 {
+		t.Fatalf("%d repository calls, want 4", len(repo.ctxs))
+	}
+	for i, ctx := range repo.ctxs {
+		if ctx.Value(marker{}) == nil {
+			t.Errorf("call %d was not made with the request's context", i+1)
+		}
+	}
+}
+
+func TestParsePriceRange(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		query    string
+		min, max *float64
+		field    string // of the error, "" when the range is valid
+	}{
+		{"", nil, nil, ""},
+		{"min_price=20", f(20), nil, ""},
+		{"max_price=20.5", nil, f(20.5), ""},
+		{"min_price=0&max_price=0", f(0), f(0), ""},
+		{"min_price=10&max_price=20", f(10), f(20), ""},
+		{"min_price=20&max_price=10", nil, nil, "min_price"},
+		{"min_price=cheap", nil, nil, "min_price"},
+		{"max_price=-1", nil, nil, "max_price"},
+		{"max_price=NaN", nil, nil, "max_price"},
+		{"min_price=Inf", nil, nil, "min_price"},
+	}
+	for _, tt := range tests {
+		minPrice, maxPrice, fe := parsePriceRange(httptest.NewRequest(http.MethodGet, "/albums?"+tt.query, nil))
+		if tt.field != "" {
+			if fe == nil || fe.Field != tt.field {
+				t.Errorf("?%s: error %v, want one for %s", tt.query, fe, tt.field)
+			}
+			continue
+		}
+		if fe != nil {
+			t.Errorf("?%s: unexpected error %v", tt.query, fe)
+			continue
+		}
+		if !equalPrice(minPrice, tt.min) || !equalPrice(maxPrice, tt.max) {
+			t.Errorf("?%s: got %v..%v, want %v..%v", tt.query, minPrice, maxPrice, tt.min, tt.max)
+		}
+	}
+}
+
+func equalPrice(a, b *float64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func TestGetAlbumsPriceRange(t *testing.T) {
+	srv, _ := newTestServer(append(testAlbums,
+		Album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 24.99, Currency: "USD", Tags: []string{}},
+	)...)
+
+	ids := func(query string) (got []string, total string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("?%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		var albums []Album
+		decodeJSON(t, rec, &albums)
+		for _, a := range albums {
+			got = append(got, a.ID)
+		}
+		return got, rec.Header().Get("X-Total-Count")
+	}
+
+	// Open-ended ranges
+	if got, _ := ids("min_price=39.99"); strings.Join(got, ",") != "1,3" {
+		t.Errorf("only min_price: got %v, want 1,3 (the bound is inclusive)", got)
+	}
+	if got, _ := ids("max_price=24.99"); strings.Join(got, ",") != "2,4" {
+		t.Errorf("only max_price: got %v, want 2,4 (the bound is inclusive)", got)
+	}
+	if got, _ := ids("min_price=20&max_price=40"); strings.Join(got, ",") != "3,4" {
+		t.Errorf("both bounds: got %v, want 3,4", got)
+	}
+	// Composed with the artist filter and pagination
+	got, total := ids("artist=john+coltrane&max_price=30&limit=1")
+	if strings.Join(got, ",") != "4" || total != "1" {
+		t.Errorf("with artist and limit: got %v of %s, want 4 of 1", got, total)
+	}
+	got, total = ids("min_price=10&limit=2&offset=2")
+	if strings.Join(got, ",") != "3,4" || total != "4" {
+		t.Errorf("second page: got %v of %s, want 3,4 of 4", got, total)
+	}
+}
+
+func TestGetAlbumsPriceRangeEncrypted(t *testing.T) {
+	saved := encryptionKey
+	encryptionKey = make([]byte, 32)
+	defer func() { encryptionKey = saved }()
+
+	srv, _ := newTestServer(testAlbums...)
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?max_price=10", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400 while prices are encrypted", rec.Code)
+	}
+}
+
+func TestAlbumsHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		code   string // of the error, "" on success
+	}{
+		{"list", http.MethodGet, "", http.StatusOK, ""},
+		{"create", http.MethodPost, `{"id": "4", "title": "Kind of Blue", "artist": "Miles Davis", "price": 29.99}`, http.StatusCreated, ""},
+		{"bad body", http.MethodPost, `{"id": `, http.StatusBadRequest, ErrValidation},
+		{"invalid album", http.MethodPost, `{"id": "4"}`, http.StatusUnprocessableEntity, ErrValidation},
+		{"duplicate", http.MethodPost, `{"id": "1", "title": "T", "artist": "A", "price": 1}`, http.StatusConflict, ErrConflict},
+		{"put", http.MethodPut, `{}`, http.StatusMethodNotAllowed, ErrMethodNotAllowed},
+		{"delete", http.MethodDelete, "", http.StatusMethodNotAllowed, ErrMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newTestServer(testAlbums...)
+			rec := httptest.NewRecorder()
+			srv.albumsHandler(rec, httptest.NewRequest(tt.method, "/albums", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				var apiErr APIError
+				decodeJSON(t, rec, &apiErr)
+				if apiErr.Code != tt.code {
+					t.Errorf("code %q, want %s", apiErr.Code, tt.code)
+				}
+			}
+		})
+	}
+}
+
+func TestAlbumByIDHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		id      string
+		body    string
+		status  int
+		code    string // of the error, "" when there is none
+		deleted bool   // whether the album is gone afterwards
+	}{
+		{"get", http.MethodGet, "2", "", http.StatusOK, "", false},
+		{"get missing", http.MethodGet, "42", "", http.StatusNotFound, ErrNotFound, false},
+		{"get bad fields", http.MethodGet, "2?fields=nope", "", http.StatusBadRequest, ErrValidation, false},
+		{"head", http.MethodHead, "2", "", http.StatusOK, "", false},
+		{"head missing", http.MethodHead, "42", "", http.StatusNotFound, "", false},
+		{"patch", http.MethodPatch, "2", `{"price": 19.99}`, http.StatusOK, "", false},
+		{"patch bad body", http.MethodPatch, "2", `{"price": `, http.StatusBadRequest, ErrValidation, false},
+		{"delete", http.MethodDelete, "2", "", http.StatusNoContent, "", true},
+		{"delete missing", http.MethodDelete, "42", "", http.StatusNotFound, ErrNotFound, false},
+		{"put", http.MethodPut, "2", `{}`, http.StatusMethodNotAllowed, ErrMethodNotAllowed, false},
+		{"post", http.MethodPost, "2", `{}`, http.StatusMethodNotAllowed, ErrMethodNotAllowed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, repo := newTestServer(testAlbums...)
+			r := chi.NewRouter()
+			r.HandleFunc("/albums/{id}", srv.albumByIDHandler)
+
+			req := httptest.NewRequest(tt.method, "/albums/"+tt.id, strings.NewReader(tt.body))
+			if tt.method == http.MethodPatch {
+				req.Header.Set("Content-Type", mergePatchContentType)
+				req.Header.Set("If-Match", versionETag(1))
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.method == http.MethodHead && rec.Body.Len() != 0 {
+				t.Errorf("HEAD with a body: %q", rec.Body)
+			}
+			if tt.code != "" {
+				var apiErr APIError
+				decodeJSON(t, rec, &apiErr)
+				if apiErr.Code != tt.code {
+					t.Errorf("code %q, want %s", apiErr.Code, tt.code)
+				}
+			}
+			if _, ok := repo.albums["2"]; ok == tt.deleted {
+				t.Errorf("album 2 stored: %v, want %v", ok, !tt.deleted)
+			}
+		})
+	}
+}
+
+// The same requests through the production router, where chi answers the
+// methods no route takes
+func TestAlbumRoutes(t *testing.T) {
+	tests := []struct {
+		method, path string
+		body         string
+		status       int
+	}{
+		{http.MethodGet, "/v1/albums", "", http.StatusOK},
+		{http.MethodPost, "/v1/albums", `{"id": "4", "title": "Kind of Blue", "artist": "Miles Davis", "price": 29.99}`, http.StatusCreated},
+		{http.MethodPost, "/v1/albums", `{"id": `, http.StatusBadRequest},
+		{http.MethodPut, "/v1/albums", `{}`, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/albums/1", "", http.StatusOK},
+		{http.MethodGet, "/v1/albums/42", "", http.StatusNotFound},
+		{http.MethodHead, "/v1/albums/1", "", http.StatusOK},
+		{http.MethodHead, "/v1/albums/42", "", http.StatusNotFound},
+		{http.MethodPost, "/v1/albums/1", `{}`, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/v1/albums/1", "", http.StatusNoContent},
+		{http.MethodDelete, "/v1/albums/42", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		srv, _ := newTestServer(testAlbums...)
+		h := newTestRouter(t, srv)
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestAlbumByIDHandlerUUIDs(t *testing.T) {
+	albumIDsAreUUIDs = true
+	defer func() { albumIDsAreUUIDs = false }()
+
+	const id = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	srv, _ := newTestServer(Album{ID: id, Title: "Blue Train", Artist: "John Coltrane", Price: 56.99, Version: 1})
+	r := chi.NewRouter()
+	r.HandleFunc("/albums/{id}", srv.albumByIDHandler)
+
+	for path, status := range map[string]int{"/albums/" + id: http.StatusOK, "/albums/1": http.StatusBadRequest} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Errorf("GET %s: status %d, want %d", path, rec.Code, status)
+		}
+	}
+}
+
+func TestHeadAlbumByIDStorageError(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		status int
+	}{
+		{errDatabaseUnavailable, http.StatusServiceUnavailable},
+		{errNotFaked, http.StatusInternalServerError},
+	} {
+		srv, repo := newTestServer(testAlbums...)
+		repo.err = tt.err
+		rec := httptest.NewRecorder()
+		srv.headAlbumByID(rec, httptest.NewRequest(http.MethodHead, "/albums/1", nil), "1")
+		if rec.Code != tt.status || rec.Body.Len() != 0 {
+			t.Errorf("%v: status %d with %d bytes, want %d without a body", tt.err, rec.Code, rec.Body.Len(), tt.status)
+		}
+	}
+}
+
+func TestBatchDeleteAlbums(t *testing.T) {
+	tooMany, _ := json.Marshal(batchDeleteRequest{IDs: make([]string, maxBatchDeleteIDs+1)})
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		deleted int
+		left    []string // the album ids still stored
+	}{
+		{"some", `{"ids": ["1", "3", "42"]}`, http.StatusOK, 2, []string{"2"}},
+		{"none found", `{"ids": ["42"]}`, http.StatusOK, 0, []string{"1", "2", "3"}},
+		{"not JSON", `{"ids": `, http.StatusBadRequest, 0, []string{"1", "2", "3"}},
+		{"empty", `{"ids": []}`, http.StatusBadRequest, 0, []string{"1", "2", "3"}},
+		{"too many", string(tooMany), http.StatusBadRequest, 0, []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, repo := newTestServer(testAlbums...)
+			rec := httptest.NewRecorder()
+			srv.batchDeleteAlbums(rec, httptest.NewRequest(http.MethodPost, "/albums/batch-delete", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				var body map[string]int
+				decodeJSON(t, rec, &body)
+				if body["deleted"] != tt.deleted {
+					t.Errorf("deleted %d, want %d", body["deleted"], tt.deleted)
+				}
+			}
+			var left []string
+			for id := range repo.albums {
+				left = append(left, id)
+			}
+			sort.Strings(left)
+			if strings.Join(left, ",") != strings.Join(tt.left, ",") {
+				t.Errorf("albums left %v, want %v", left, tt.left)
+			}
+		})
+	}
+
+	srv, repo := newTestServer(testAlbums...)
+	repo.err = errDatabaseUnavailable
+	rec := httptest.NewRecorder()
+	srv.batchDeleteAlbums(rec, httptest.NewRequest(http.MethodPost, "/albums/batch-delete", strings.NewReader(`{"ids": ["1"]}`)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("storage error: status %d, want 503", rec.Code)
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	tooMany := make([]string, maxMultiGetIDs+1)
+	for i := range tooMany {
+		tooMany[i] = strconv.Itoa(i)
+	}
+	tests := []struct {
+		query string
+		want  []string
+		field string // of the error, "" when the ids are valid
+	}{
+		{"", nil, ""},
+		{"ids=1", []string{"1"}, ""},
+		{"ids=1,2,3", []string{"1", "2", "3"}, ""},
+		{"ids=+1+,,2,1", []string{"1", "2"}, ""},
+		{"ids=,,", nil, "ids"},
+		{"ids=" + strings.Join(tooMany, ","), nil, "ids"},
+	}
+	for _, tt := range tests {
+		ids, fe := parseIDs(httptest.NewRequest(http.MethodGet, "/albums?"+tt.query, nil))
+		if tt.field != "" {
+			if fe == nil || fe.Field != tt.field {
+				t.Errorf("?%.20s: error %v, want one for %s", tt.query, fe, tt.field)
+			}
+			continue
+		}
+		if fe != nil {
+			t.Errorf("?%s: unexpected error %v", tt.query, fe)
+			continue
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.want, ",") || (ids == nil) != (tt.want == nil) {
+			t.Errorf("?%s: got %q, want %q", tt.query, ids, tt.want)
+		}
+	}
+}
+
+func TestGetAlbumsByIDs(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?ids=3,1,42", nil))
+	var albums []Album
+	decodeJSON(t, rec, &albums)
+	if len(albums) != 2 || albums[0].ID != "1" || albums[1].ID != "3" {
+		t.Errorf("got %+v, want albums 1 and 3", albums)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?ids=,", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("?ids=,: status %d, want 400", rec.Code)
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	h := recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/albums", nil))
+	var apiErr APIError
+	decodeJSON(t, rec, &apiErr)
+	if rec.Code != http.StatusInternalServerError || apiErr.Code != ErrInternal {
+		t.Errorf("status %d, code %q; want 500 %s", rec.Code, apiErr.Code, ErrInternal)
+	}
+
+	// http.ErrAbortHandler is left to net/http
+	h = recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", rec)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/albums", nil))
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	clientDeadline := make(chan bool, 1)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			clientDeadline <- clientDeadlineExceeded(r.Context())
+		case <-time.After(time.Second):
+			sendJSON(w, http.StatusOK, "done")
+		}
+	})
+	h := timeoutMiddleware(50 * time.Millisecond)(slow)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/albums", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), ErrTimeout) {
+		t.Errorf("server timeout: status %d, body %q; want 503 %s", rec.Code, rec.Body, ErrTimeout)
+	}
+	if <-clientDeadline {
+		t.Error("the server's timeout was taken for the client's")
+	}
+
+	// X-Request-Timeout cuts the request context short, and says so
+	req := httptest.NewRequest(http.MethodGet, "/albums", nil)
+	req.Header.Set("X-Request-Timeout", "10")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if !<-clientDeadline {
+		t.Error("the client's timeout was not recognized")
+	}
+
+	// Streams aren't buffered or cut off
+	h = timeoutMiddleware(time.Nanosecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, http.StatusOK, "streamed")
+	}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/albums?stream=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("stream: status %d, want 200", rec.Code)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"abc", 0, false},
+		{"-5", 0, false},
+		{"250", 250 * time.Millisecond, true},
+		{"10000", 10 * time.Second, false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/albums", nil)
+		req.Header.Set("X-Request-Timeout", tt.header)
+		if got, ok := clientTimeout(req, 10*time.Second); got != tt.want || ok != tt.ok {
+			t.Errorf("X-Request-Timeout: %s: got %v, %v; want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := concurrencyLimitMiddleware(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/albums", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over the limit: status %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Event streams don't take a slot
+	req := httptest.NewRequest(http.MethodGet, "/albums/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("event stream: status %d, want it served", rec.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("first request: status %d", code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/albums", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("after the slot was freed: status %d", rec.Code)
+	}
+}
+
+func TestMiddlewareSettings(t *testing.T) {
+	if requestTimeout() != defaultRequestTimeout || maxConcurrentRequests() != 0 {
+		t.Error("unexpected defaults")
+	}
+	t.Setenv("REQUEST_TIMEOUT_SECONDS", "3")
+	t.Setenv("MAX_CONCURRENT_REQUESTS", "8")
+	if got := requestTimeout(); got != 3*time.Second {
+		t.Errorf("requestTimeout() = %v, want 3s", got)
+	}
+	if got := maxConcurrentRequests(); got != 8 {
+		t.Errorf("maxConcurrentRequests() = %d, want 8", got)
+	}
+}
+
+func TestEnvelopeAndPretty(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/albums/42?envelope=true&pretty=true", nil))
+	if !strings.Contains(rec.Body.String(), "\n  \"success\": false") {
+		t.Errorf("body %q, want an indented envelope", rec.Body)
+	}
+	var env struct {
+		Success bool      `json:"success"`
+		Error   *APIError `json:"error"`
+	}
+	decodeJSON(t, rec, &env)
+	if rec.Code != http.StatusNotFound || env.Success || env.Error == nil || env.Error.Code != ErrNotFound {
+		t.Errorf("status %d, envelope %+v; want 404 with the error wrapped", rec.Code, env)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/albums/1?envelope=1", nil))
+	var ok struct {
+		Success bool  `json:"success"`
+		Data    Album `json:"data"`
+	}
+	decodeJSON(t, rec, &ok)
+	if !ok.Success || ok.Data.ID != "1" {
+		t.Errorf("envelope %+v, want album 1", ok)
+	}
+}
+
+func TestLoadPageSizes(t *testing.T) {
+	defer func() {
+		defaultPageSize, maxPageSize, clampPageSize = builtinDefaultPageSize, builtinMaxPageSize, false
+	}()
+
+	t.Setenv("DEFAULT_PAGE_SIZE", "5")
+	t.Setenv("MAX_PAGE_SIZE", "10")
+	t.Setenv("PAGE_SIZE_OVERFLOW", "clamp")
+	loadPageSizes()
+	if defaultPageSize != 5 || maxPageSize != 10 || !clampPageSize {
+		t.Fatalf("got %d, %d, clamp %v", defaultPageSize, maxPageSize, clampPageSize)
+	}
+	if limit, fe := parseLimit("50"); fe != nil || limit != 10 {
+		t.Errorf("parseLimit(50) = %d, %v; want it clamped to 10", limit, fe)
+	}
+
+	t.Setenv("PAGE_SIZE_OVERFLOW", "reject")
+	loadPageSizes()
+	if _, fe := parseLimit("50"); fe == nil {
+		t.Error("parseLimit(50) accepted past MAX_PAGE_SIZE")
+	}
+}
+
+func TestLoadAlbumIDFormat(t *testing.T) {
+	defer func() { albumIDsAreUUIDs = false }()
+	t.Setenv("ALBUM_ID_FORMAT", "uuid")
+	loadAlbumIDFormat()
+	if !albumIDsAreUUIDs || validAlbumID("1") {
+		t.Error("ALBUM_ID_FORMAT=uuid accepted id 1")
+	}
+	t.Setenv("ALBUM_ID_FORMAT", "any")
+	loadAlbumIDFormat()
+	if albumIDsAreUUIDs || !validAlbumID("1") {
+		t.Error("ALBUM_ID_FORMAT=any rejected id 1")
+	}
+}
+
+func TestGetAlbumsCursor(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+
+	var pages [][]string
+	after := ""
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?limit=2&fields=id&after="+after, nil))
+		var page struct {
+			Albums     []map[string]string `json:"albums"`
+			NextCursor string              `json:"next_cursor"`
+		}
+		decodeJSON(t, rec, &page)
+		var ids []string
+		for _, a := range page.Albums {
+			ids = append(ids, a["id"])
+		}
+		pages = append(pages, ids)
+		if page.NextCursor == "" {
+			if rec.Header().Get("Link") != "" {
+				t.Errorf("last page links to %q", rec.Header().Get("Link"))
+			}
+			break
+		}
+		after = page.NextCursor
+	}
+	if len(pages) != 2 || strings.Join(pages[0], ",") != "1,2" || strings.Join(pages[1], ",") != "3" {
+		t.Errorf("pages %v, want [1 2] [3]", pages)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?after=1&offset=2", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("after with offset: status %d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	srv.getAlbums(rec, httptest.NewRequest(http.MethodGet, "/albums?offset=2&limit=1", nil))
+	if link := rec.Header().Get("Link"); !strings.Contains(link, `offset=1>; rel="prev"`) {
+		t.Errorf("Link %q, want a prev page at offset 1", link)
+	}
+}
+
+func TestDocs(t *testing.T) {
+	srv, _ := newTestServer()
+	h := newTestRouter(t, srv)
+
+	rec := serve(t, h, http.MethodGet, "/openapi.json", nil, nil, nil)
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil || rec.Code != http.StatusOK || spec.OpenAPI == "" {
+		t.Errorf("GET /openapi.json: status %d, %v", rec.Code, err)
+	}
+	rec = serve(t, h, http.MethodGet, "/docs", nil, nil, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `url: "/openapi.json"`) {
+		t.Errorf("GET /docs: status %d", rec.Code)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	srv, _ := newTestServer()
+	var info versionInfo
+	if rec := serve(t, newTestRouter(t, srv), http.MethodGet, "/v1/version", nil, nil, &info); rec.Code != http.StatusOK || info.Version != version {
+		t.Errorf("status %d, %+v", rec.Code, info)
+	}
+}
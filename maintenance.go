@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ========== Maintenance Mode ==========
+
+// defaultMaintenanceRetryAfter applies when MAINTENANCE_RETRY_AFTER_SECONDS is not set
+const defaultMaintenanceRetryAfter = 5 * time.Minute
+
+// maintenanceMode makes the API read-only while enabled: writes get 503 with a
+// Retry-After header, reads are served as usual. It can be toggled at runtime
+// through /admin/maintenance.
+type maintenanceMode struct {
+	enabled    atomic.Bool
+	retryAfter atomic.Int64 // time.Duration
+}
+
+type maintenanceStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+// maintenanceConfig reads MAINTENANCE_MODE and MAINTENANCE_RETRY_AFTER_SECONDS
+func maintenanceConfig() (enabled bool, retryAfter time.Duration, err error) {
+	retryAfter = defaultMaintenanceRetryAfter
+	if v := os.Getenv("MAINTENANCE_RETRY_AFTER_SECONDS"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			return false, 0, fmt.Errorf("MAINTENANCE_RETRY_AFTER_SECONDS must be a positive integer, got %q", v)
+		}
+		retryAfter = time.Duration(secs) * time.Second
+	}
+
+	if v := os.Getenv("MAINTENANCE_MODE"); v != "" {
+		if enabled, err = strconv.ParseBool(v); err != nil {
+			return false, 0, fmt.Errorf("MAINTENANCE_MODE must be true or false, got %q", v)
+		}
+	}
+	return enabled, retryAfter, nil
+}
+
+// set switches maintenance mode and logs who changed it
+func (m *maintenanceMode) set(on bool, by string) {
+	if m.enabled.Swap(on) == on {
+		return
+	}
+	if on {
+		log.Printf("Maintenance mode enabled by %s, rejecting writes", by)
+	} else {
+		log.Printf("Maintenance mode disabled by %s, accepting writes", by)
+	}
+}
+
+// middleware rejects everything but GET, HEAD and OPTIONS while maintenance mode is on
+func (m *maintenanceMode) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if m.enabled.Load() {
+				retryAfter := time.Duration(m.retryAfter.Load())
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+				sendError(w, APIError{Code: ErrMaintenance, Message: "the API is in maintenance mode, try again later"}, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getMaintenance serves GET /admin/maintenance
+func (m *maintenanceMode) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, http.StatusOK, maintenanceStatus{Enabled: m.enabled.Load()})
+}
+
+// putMaintenance serves PUT /admin/maintenance with {"enabled": true|false}
+func (m *maintenanceMode) putMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "body must be {\"enabled\": true|false}", Field: "enabled"}, http.StatusBadRequest)
+		return
+	}
+
+	m.set(*body.Enabled, actorFromContext(r.Context()))
+	sendJSON(w, http.StatusOK, maintenanceStatus{Enabled: *body.Enabled})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+
+	var status maintenanceStatus
+	if rec := serve(t, h, http.MethodGet, "/v1/admin/maintenance", nil, admin, &status); rec.Code != http.StatusOK || status.Enabled {
+		t.Fatalf("GET: status %d, %+v", rec.Code, status)
+	}
+	if rec := serve(t, h, http.MethodPut, "/v1/admin/maintenance", map[string]bool{"enabled": true}, admin, &status); rec.Code != http.StatusOK || !status.Enabled {
+		t.Fatalf("PUT: status %d, %+v", rec.Code, status)
+	}
+
+	rec := serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "0" {
+		t.Errorf("write in maintenance: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve(t, h, http.MethodGet, "/v1/albums", nil, nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("read in maintenance: status %d", rec.Code)
+	}
+	serve(t, h, http.MethodGet, "/v1/admin/maintenance", nil, admin, &status)
+	if !status.Enabled {
+		t.Error("GET after enabling says disabled")
+	}
+
+	serve(t, h, http.MethodPut, "/v1/admin/maintenance", map[string]bool{"enabled": false}, admin, nil)
+	if rec := serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil); rec.Code != http.StatusCreated {
+		t.Errorf("write after maintenance: status %d", rec.Code)
+	}
+
+	for _, body := range []interface{}{map[string]string{}, "on"} {
+		if rec := serve(t, h, http.MethodPut, "/v1/admin/maintenance", body, admin, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %v: status %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestMaintenanceConfig(t *testing.T) {
+	for _, tt := range []struct {
+		mode, retryAfter string
+		enabled          bool
+		wantErr          bool
+	}{
+		{"", "", false, false},
+		{"true", "60", true, false},
+		{"maybe", "", false, true},
+		{"", "0", false, true},
+		{"", "soon", false, true},
+	} {
+		t.Setenv("MAINTENANCE_MODE", tt.mode)
+		t.Setenv("MAINTENANCE_RETRY_AFTER_SECONDS", tt.retryAfter)
+		enabled, _, err := maintenanceConfig()
+		if enabled != tt.enabled || (err != nil) != tt.wantErr {
+			t.Errorf("MAINTENANCE_MODE=%q MAINTENANCE_RETRY_AFTER_SECONDS=%q: %v, %v", tt.mode, tt.retryAfter, enabled, err)
+		}
+	}
+}
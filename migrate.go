@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// ========== Migrations ==========
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// schemaMigration records a migration file that has been applied
+type schemaMigration struct {
+	tableName struct{} `pg:"schema_migrations"`
+
+	Name      string    `pg:"name,pk"`
+	AppliedAt time.Time `pg:"applied_at,default:now()"`
+}
+
+// runMigrations applies the files in migrations/ that haven't been applied
+// yet, in name order, each in its own transaction. It returns the names of
+// the files it applied.
+func runMigrations(ctx context.Context, db *pg.DB) ([]string, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		name VARCHAR PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	var done []string
+	if err := db.ModelContext(ctx, (*schemaMigration)(nil)).Column("name").Select(&done); err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	applied := make(map[string]bool, len(done))
+	for _, name := range done {
+		applied[name] = true
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var ran []string
+	for _, path := range names {
+		name := path[len("migrations/"):]
+		if applied[name] {
+			continue
+		}
+
+		sql, err := migrationFiles.ReadFile(path)
+		if err != nil {
+			return ran, err
+		}
+		err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+			if _, err := tx.ExecContext(ctx, string(sql)); err != nil {
+				return err
+			}
+			_, err := tx.ModelContext(ctx, &schemaMigration{Name: name}).Insert()
+			return err
+		})
+		if err != nil {
+			return ran, fmt.Errorf("applying %s: %w", name, err)
+		}
+		log.Printf("Applied migration %s", name)
+		ran = append(ran, name)
+	}
+	return ran, nil
+}
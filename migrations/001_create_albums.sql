@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS albums (
+    id VARCHAR PRIMARY KEY,
+    title VARCHAR NOT NULL,
+    artist VARCHAR NOT NULL,
+    price NUMERIC(10,2) NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD'
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    table_name VARCHAR NOT NULL,
+    record_id VARCHAR NOT NULL,
+    action VARCHAR NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+    old_value JSONB,
+    new_value JSONB,
+    performed_by VARCHAR NOT NULL,
+    performed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_logs_record_idx ON audit_logs (table_name, record_id, performed_at DESC);
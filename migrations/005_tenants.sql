@@ -0,0 +1,10 @@
+-- Albums and audit entries belong to a tenant. Existing rows move to the
+-- "default" tenant, which is the one used when TENANT_IDS is not set.
+
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+ALTER TABLE albums DROP CONSTRAINT IF EXISTS albums_pkey;
+ALTER TABLE albums ADD PRIMARY KEY (tenant_id, id);
+
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS tenant_id VARCHAR NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS audit_logs_record_idx;
+CREATE INDEX IF NOT EXISTS audit_logs_record_idx ON audit_logs (tenant_id, table_name, record_id, performed_at DESC);
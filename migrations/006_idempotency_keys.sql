@@ -0,0 +1,14 @@
+-- Responses to POST /albums requests sent with an Idempotency-Key. Rows older
+-- than 24 hours are ignored and overwritten on reuse; delete them at leisure:
+--
+--   DELETE FROM idempotency_keys WHERE created_at < now() - interval '24 hours';
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    tenant_id VARCHAR NOT NULL DEFAULT 'default',
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status INTEGER NOT NULL,
+    response JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant_id, key)
+);
@@ -0,0 +1,2 @@
+-- Optimistic concurrency: every update must name the version it read and bumps it by one.
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
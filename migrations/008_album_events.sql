@@ -0,0 +1,26 @@
+-- Publishes every album change on the album_events channel for GET /albums/events.
+-- The payload is {"type": "created"|"updated"|"deleted", "tenant_id", "id", "album"},
+-- with "album" left out for deletes.
+CREATE OR REPLACE FUNCTION notify_album_change() RETURNS trigger AS $$
+DECLARE
+    payload JSON;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        payload := json_build_object('type', 'deleted', 'tenant_id', OLD.tenant_id, 'id', OLD.id);
+    ELSE
+        payload := json_build_object(
+            'type', CASE TG_OP WHEN 'INSERT' THEN 'created' ELSE 'updated' END,
+            'tenant_id', NEW.tenant_id,
+            'id', NEW.id,
+            'album', row_to_json(NEW)
+        );
+    END IF;
+    PERFORM pg_notify('album_events', payload::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS albums_notify_change ON albums;
+CREATE TRIGGER albums_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON albums
+    FOR EACH ROW EXECUTE FUNCTION notify_album_change();
@@ -0,0 +1,25 @@
+-- Include the last state of a deleted album in its event, so subscribers that
+-- filter by artist can tell whether a delete concerns them.
+CREATE OR REPLACE FUNCTION notify_album_change() RETURNS trigger AS $$
+DECLARE
+    payload JSON;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        payload := json_build_object(
+            'type', 'deleted',
+            'tenant_id', OLD.tenant_id,
+            'id', OLD.id,
+            'album', row_to_json(OLD)
+        );
+    ELSE
+        payload := json_build_object(
+            'type', CASE TG_OP WHEN 'INSERT' THEN 'created' ELSE 'updated' END,
+            'tenant_id', NEW.tenant_id,
+            'id', NEW.id,
+            'album', row_to_json(NEW)
+        );
+    END IF;
+    PERFORM pg_notify('album_events', payload::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
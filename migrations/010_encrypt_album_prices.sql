@@ -0,0 +1,4 @@
+-- Prices are stored as text so they can hold AES-256-GCM ciphertext (base64)
+-- when ENCRYPTION_KEY is set. Existing prices become plain decimal strings,
+-- which the server still reads; `admin encrypt-prices` encrypts them in place.
+ALTER TABLE albums ALTER COLUMN price TYPE TEXT USING price::text;
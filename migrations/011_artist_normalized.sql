@@ -0,0 +1,17 @@
+-- ?artist= matches artist_normalized: the artist lowercased, trimmed and with
+-- runs of whitespace collapsed, so "The  Beatles " and "the beatles" are the
+-- same artist. The server fills it on every insert and update; the UPDATE
+-- below backfills existing rows the same way. artist keeps the name as entered.
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS artist_normalized VARCHAR;
+
+-- The backfill changes nothing clients can see, so don't announce it on album_events
+ALTER TABLE albums DISABLE TRIGGER albums_notify_change;
+UPDATE albums SET artist_normalized = lower(btrim(regexp_replace(artist, '\s+', ' ', 'g')))
+    WHERE artist_normalized IS NULL;
+ALTER TABLE albums ENABLE TRIGGER albums_notify_change;
+
+ALTER TABLE albums ALTER COLUMN artist_normalized SET NOT NULL;
+
+-- Replaces the lower(artist) index from 002_album_search_indexes.sql
+CREATE INDEX IF NOT EXISTS albums_artist_normalized_idx ON albums (tenant_id, artist_normalized);
+DROP INDEX IF EXISTS albums_artist_lower_idx;
@@ -0,0 +1,2 @@
+-- Where the cover image uploaded with an album can be fetched; NULL without a cover.
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS cover_url VARCHAR;
@@ -0,0 +1,12 @@
+-- Endpoints that are sent a signed POST when albums are created or deleted;
+-- managed through /admin/webhooks.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR NOT NULL DEFAULT 'default',
+    url VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    events TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_tenant_idx ON webhooks (tenant_id);
@@ -0,0 +1,18 @@
+-- Every attempt to send an album event to a webhook. Failed deliveries are
+-- retried at next_retry_at until they succeed or are marked dead.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR NOT NULL DEFAULT 'default',
+    webhook_id BIGINT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_retry_at TIMESTAMPTZ,
+    status VARCHAR NOT NULL CHECK (status IN ('pending', 'failed', 'delivered', 'dead')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_retry_at) WHERE status IN ('pending', 'failed');
@@ -0,0 +1,48 @@
+-- An append-only log of every change to an album, read by GET /albums/{id}/history.
+-- Each event's payload is the whole row after the change (before it, for
+-- AlbumDeleted), so replaying an album's events in order ends with its
+-- current state. The price in it is in its stored form, encrypted or not.
+CREATE TABLE IF NOT EXISTS album_events (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR NOT NULL,
+    album_id VARCHAR NOT NULL,
+    event_type VARCHAR NOT NULL CHECK (event_type IN ('AlbumCreated', 'AlbumUpdated', 'AlbumDeleted')),
+    payload JSONB NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS album_events_album_idx ON album_events (tenant_id, album_id, occurred_at, id);
+
+-- Albums that existed before the log start with the event that created them
+INSERT INTO album_events (tenant_id, album_id, event_type, payload, occurred_at)
+SELECT a.tenant_id, a.id, 'AlbumCreated', to_jsonb(a), a.created_at
+FROM albums a
+WHERE NOT EXISTS (
+    SELECT 1 FROM album_events e WHERE e.tenant_id = a.tenant_id AND e.album_id = a.id
+)
+ORDER BY a.created_at, a.id;
+
+-- The trigger writes the events in the transaction of the change, whichever
+-- way the change is made
+CREATE OR REPLACE FUNCTION record_album_event() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO album_events (tenant_id, album_id, event_type, payload)
+        VALUES (OLD.tenant_id, OLD.id, 'AlbumDeleted', to_jsonb(OLD));
+    ELSE
+        INSERT INTO album_events (tenant_id, album_id, event_type, payload)
+        VALUES (
+            NEW.tenant_id,
+            NEW.id,
+            CASE TG_OP WHEN 'INSERT' THEN 'AlbumCreated' ELSE 'AlbumUpdated' END,
+            to_jsonb(NEW)
+        );
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS albums_record_event ON albums;
+CREATE TRIGGER albums_record_event
+    AFTER INSERT OR UPDATE OR DELETE ON albums
+    FOR EACH ROW EXECUTE FUNCTION record_album_event();
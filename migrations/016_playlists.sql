@@ -0,0 +1,23 @@
+-- Playlists are ordered lists of albums of one tenant. An album can be on
+-- many playlists, but only once on each; deleting the album takes it off them.
+CREATE TABLE IF NOT EXISTS playlists (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR NOT NULL DEFAULT 'default',
+    name VARCHAR NOT NULL,
+    description VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS playlists_tenant_idx ON playlists (tenant_id);
+
+CREATE TABLE IF NOT EXISTS playlist_albums (
+    playlist_id BIGINT NOT NULL REFERENCES playlists (id) ON DELETE CASCADE,
+    tenant_id VARCHAR NOT NULL,
+    album_id VARCHAR NOT NULL,
+    position INTEGER NOT NULL CHECK (position > 0),
+    PRIMARY KEY (playlist_id, album_id),
+    FOREIGN KEY (tenant_id, album_id) REFERENCES albums (tenant_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS playlist_albums_position_idx ON playlist_albums (playlist_id, position);
+CREATE INDEX IF NOT EXISTS playlist_albums_album_idx ON playlist_albums (tenant_id, album_id);
@@ -0,0 +1,19 @@
+-- Freeform tags on albums, such as "jazz" or "sale". Tag names are unique per
+-- tenant and shared by all of its albums; deleting an album drops its links.
+CREATE TABLE IF NOT EXISTS tags (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR NOT NULL DEFAULT 'default',
+    name VARCHAR NOT NULL,
+    UNIQUE (tenant_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS album_tags (
+    tenant_id VARCHAR NOT NULL,
+    album_id VARCHAR NOT NULL,
+    tag_id BIGINT NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    PRIMARY KEY (tenant_id, album_id, tag_id),
+    FOREIGN KEY (tenant_id, album_id) REFERENCES albums (tenant_id, id) ON DELETE CASCADE
+);
+
+-- For ?tag=, which looks up the albums of one tag
+CREATE INDEX IF NOT EXISTS album_tags_tag_idx ON album_tags (tag_id);
@@ -0,0 +1,15 @@
+-- Reviews of albums, with a rating from 1 to 5. Deleting an album deletes
+-- its reviews.
+CREATE TABLE IF NOT EXISTS reviews (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR NOT NULL DEFAULT 'default',
+    album_id VARCHAR NOT NULL,
+    author_name VARCHAR NOT NULL,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (tenant_id, album_id) REFERENCES albums (tenant_id, id) ON DELETE CASCADE
+);
+
+-- Lists an album's reviews newest first, and averages and counts them for GET /albums/{id}
+CREATE INDEX IF NOT EXISTS reviews_album_idx ON reviews (tenant_id, album_id, id);
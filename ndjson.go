@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ========== Album Streaming ==========
+
+const ndjsonContentType = "application/x-ndjson"
+
+// lineDelimitedTypes are the media types clients use for one JSON value per
+// line: NDJSON and JSON Lines, which are the same format under two names
+var lineDelimitedTypes = []string{ndjsonContentType, "application/jsonl", "application/x-jsonlines"}
+
+// ndjsonFlushEvery is how many albums are written between flushes
+const ndjsonFlushEvery = 100
+
+// albumStreamFormat says how streamed albums are put together in the body
+type albumStreamFormat struct {
+	contentType string
+	open, close string // written before the first album and after the last
+	sep, end    string // written between two albums and after each one
+}
+
+var (
+	// ndjsonStream is one JSON object per line
+	ndjsonStream = albumStreamFormat{contentType: ndjsonContentType, end: "\n"}
+	// arrayStream is a plain JSON array, for ?stream=true
+	arrayStream = albumStreamFormat{contentType: "application/json", open: "[", close: "]\n", sep: ","}
+)
+
+// lineDelimitedType returns the first of lineDelimitedTypes the client
+// accepts, or "" when it accepts none of them
+func lineDelimitedType(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	for _, t := range lineDelimitedTypes {
+		if strings.Contains(accept, t) {
+			return t
+		}
+	}
+	return ""
+}
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON
+// under any of its names
+func wantsNDJSON(r *http.Request) bool {
+	return lineDelimitedType(r) != ""
+}
+
+// ndjsonStreamFor is ndjsonStream labelled with the media type the client
+// asked for, so a JSON Lines client gets application/jsonl back
+func ndjsonStreamFor(r *http.Request) albumStreamFormat {
+	format := ndjsonStream
+	format.contentType = lineDelimitedType(r)
+	return format
+}
+
+// wantsArrayStream reports whether the client asked for a streamed JSON array with ?stream=true
+func wantsArrayStream(r *http.Request) bool {
+	on, _ := strconv.ParseBool(r.URL.Query().Get("stream"))
+	return on
+}
+
+// wantsAlbumStream reports whether the albums are to be streamed in either format
+func wantsAlbumStream(r *http.Request) bool {
+	return wantsNDJSON(r) || wantsArrayStream(r)
+}
+
+// streamAlbums writes the albums matching opts in format straight from the
+// database cursor, so memory use doesn't grow with the result.
+// Once the body has started the status can no longer change, so a later
+// error ends the stream early and is only logged. A JSON array is then left
+// unclosed, which clients see as invalid JSON rather than a short result.
+func (s *Server) streamAlbums(w http.ResponseWriter, r *http.Request, opts AlbumListOptions, fields []string, format albumStreamFormat) {
+	rc := http.NewResponseController(w)
+	started := false
+	written := 0
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", format.contentType)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(format.open))
+		return err
+	}
+
+	err := s.albums.StreamAlbums(r.Context(), opts, func(a Album) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		var item interface{} = a
+		if fields != nil {
+			item = projectAlbum(a, fields)
+		}
+		data, err := encodeJSON(item)
+		if err != nil {
+			return err
+		}
+		if written > 0 {
+			data = append([]byte(format.sep), data...)
+		}
+		if _, err := w.Write(append(data, format.end...)); err != nil {
+			return err
+		}
+
+		written++
+		if written%ndjsonFlushEvery == 0 {
+			return rc.Flush()
+		}
+		return nil
+	})
+
+	switch {
+	case err != nil && !started:
+		sendStorageError(w, err)
+	case err != nil:
+		log.Printf("Album stream stopped after %d albums: %v", written, err)
+	default:
+		// No rows still makes a valid, empty body
+		if !started {
+			if err := start(); err != nil {
+				return
+			}
+		}
+		w.Write([]byte(format.close))
+		rc.Flush()
+	}
+}
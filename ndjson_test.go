@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamAlbums(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+
+	for _, accept := range lineDelimitedTypes {
+		req := httptest.NewRequest(http.MethodGet, "/v1/albums?fields=id,title", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != accept {
+			t.Fatalf("Accept: %s: status %d, Content-Type %q", accept, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+		if len(lines) != len(testAlbums) {
+			t.Fatalf("Accept: %s: %d lines, want %d: %q", accept, len(lines), len(testAlbums), rec.Body)
+		}
+		for i, line := range lines {
+			var a map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &a); err != nil || a["id"] != testAlbums[i].ID || len(a) != 2 {
+				t.Errorf("line %d = %s, want id and title of album %s", i, line, testAlbums[i].ID)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/albums?stream=true", nil))
+	var albums []Album
+	decodeJSON(t, rec, &albums)
+	if len(albums) != len(testAlbums) {
+		t.Errorf("?stream=true: got %d albums, want %d", len(albums), len(testAlbums))
+	}
+
+	// Nothing to stream is still a complete, empty array
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/albums?stream=true&artist=nobody", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != "[]" {
+		t.Errorf("empty stream = %q, want []", got)
+	}
+}
+
+func TestStreamAlbumsStorageError(t *testing.T) {
+	srv, repo := newTestServer(testAlbums...)
+	repo.err = errDatabaseUnavailable
+
+	req := httptest.NewRequest(http.MethodGet, "/albums", nil)
+	req.Header.Set("Accept", ndjsonContentType)
+	rec := httptest.NewRecorder()
+	srv.getAlbums(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503 before anything was streamed", rec.Code)
+	}
+}
+
+func TestStreamAlbumsFromSQLite(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	for _, id := range []string{"1", "2", "3"} {
+		serve(t, h, http.MethodPost, "/v1/albums", Album{ID: id, Title: "Album " + id, Artist: "Artist", Price: 10}, nil, nil)
+	}
+	serve(t, h, http.MethodPost, "/v1/albums/2/tags", map[string][]string{"tags": {"cool"}}, nil, nil)
+
+	rec := serve(t, h, http.MethodGet, "/v1/albums?min_price=5", nil, http.Header{"Accept": {ndjsonContentType}}, nil)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if rec.Code != http.StatusOK || len(lines) != 3 {
+		t.Fatalf("status %d, %d lines: %s", rec.Code, len(lines), rec.Body)
+	}
+	var album Album
+	if err := json.Unmarshal([]byte(lines[1]), &album); err != nil || album.ID != "2" || len(album.Tags) != 1 {
+		t.Errorf("second line %s, want album 2 with its tag", lines[1])
+	}
+}
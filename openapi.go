@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// ========== OpenAPI ==========
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// loadOpenAPI parses and validates the embedded spec, returning the document
+// and its JSON encoding for GET /openapi.json. The server URLs are moved
+// under API_PREFIX, so requests are validated and clients generated for
+// where the API really is.
+func loadOpenAPI() (*openapi3.T, []byte, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing openapi.yaml: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, nil, fmt.Errorf("invalid openapi.yaml: %w", err)
+	}
+	if err := checkAlbumSchema(doc); err != nil {
+		return nil, nil, fmt.Errorf("openapi.yaml is out of date: %w", err)
+	}
+	if apiPrefix != "" {
+		for _, server := range doc.Servers {
+			server.URL = apiPrefix + strings.TrimSuffix(server.URL, "/")
+		}
+	}
+
+	specJSON, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding openapi spec: %w", err)
+	}
+	return doc, specJSON, nil
+}
+
+// checkAlbumSchema makes sure the Album schema lists exactly the JSON fields of
+// the Album struct, so a field added to one and not the other stops the
+// server at startup instead of producing clients that don't know it
+func checkAlbumSchema(doc *openapi3.T) error {
+	schema := doc.Components.Schemas["Album"]
+	if schema == nil || schema.Value == nil {
+		return errors.New("no Album schema")
+	}
+
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(Album{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = true
+		if _, ok := schema.Value.Properties[name]; !ok {
+			return fmt.Errorf("Album schema lacks %q", name)
+		}
+	}
+	for name := range schema.Value.Properties {
+		if !fields[name] {
+			return fmt.Errorf("Album schema has %q, which the Album struct doesn't", name)
+		}
+	}
+	return nil
+}
+
+// openAPIHandler serves the spec as JSON
+func openAPIHandler(specJSON []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(specJSON)
+	}
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at /openapi.json
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Album API docs</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(swaggerUIPage))
+}
+
+// openAPIValidationMiddleware rejects requests that don't match the spec with
+// 400, or with 415 when the body is of a media type the operation doesn't take.
+// Requests for paths or methods the spec doesn't describe pass through untouched,
+// so the router can still answer them with 404 or 405.
+func openAPIValidationMiddleware(doc *openapi3.T) (func(http.Handler) http.Handler, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("building openapi router: %w", err)
+	}
+	// kin-openapi knows JSON Patch bodies but not JSON Merge Patch ones,
+	// which are plain JSON objects
+	openapi3filter.RegisterBodyDecoder(mergePatchContentType, openapi3filter.JSONBodyDecoder)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, pathParams, err := router.FindRoute(r)
+			if err != nil {
+				if !errors.Is(err, routers.ErrPathNotFound) && !errors.Is(err, routers.ErrMethodNotAllowed) {
+					sendError(w, APIError{Code: ErrInternal, Message: err.Error()}, http.StatusInternalServerError)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if body := route.Operation.RequestBody; body != nil && body.Value != nil && hasBody(r) {
+				if content := body.Value.Content; content.Get(requestMediaType(r)) == nil {
+					sendUnsupportedMediaType(w, mediaTypes(content))
+					return
+				}
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+			}
+			if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+				sendError(w, openAPIValidationError(err), http.StatusBadRequest)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// mediaTypes lists the media types of content in a stable order
+func mediaTypes(content openapi3.Content) []string {
+	types := make([]string, 0, len(content))
+	for t := range content {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// openAPIValidationError turns a kin-openapi failure into our error body,
+// naming the parameter when the problem is with one
+func openAPIValidationError(err error) APIError {
+	apiErr := APIError{Code: ErrValidation, Message: err.Error()}
+
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) {
+		if reqErr.Parameter != nil {
+			apiErr.Field = reqErr.Parameter.Name
+			apiErr.Message = fmt.Sprintf("invalid %s parameter %q", reqErr.Parameter.In, reqErr.Parameter.Name)
+		} else if reqErr.RequestBody != nil {
+			apiErr.Message = "request body does not match the API schema"
+		}
+		if reqErr.Err != nil {
+			apiErr.Details = reqErr.Err.Error()
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// ========== PATCH ==========
+
+const (
+	jsonPatchContentType  = "application/json-patch+json"  // RFC 6902
+	mergePatchContentType = "application/merge-patch+json" // RFC 7396
+	maxPatchBytes         = 1 << 20
+)
+
+// patchAlbum serves PATCH /albums/{id}. The Content-Type picks the format: a
+// JSON Patch list of operations, or a JSON Merge Patch object (also accepted
+// as plain application/json). The patched album is validated like a new one;
+// changing the id or producing an invalid album is a 422.
+//
+// The client must say which version it is changing, with If-Match or in the
+// body (see expectedVersion); if the album has moved on since, it gets a 409.
+func (s *Server) patchAlbum(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	mediaType := requestMediaType(r)
+	if mediaType != jsonPatchContentType && mediaType != mergePatchContentType && mediaType != "application/json" {
+		sendUnsupportedMediaType(w, []string{jsonPatchContentType, mergePatchContentType})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchBytes))
+	if err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+
+	// The version check below must see the latest write, not a lagging replica
+	album, err := s.albums.GetAlbumByID(withPrimaryReads(r.Context()), id, nil)
+	if err != nil {
+		if err == errAlbumNotFound {
+			sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+			return
+		}
+		sendStorageError(w, err)
+		return
+	}
+	expected, ok, err := expectedVersion(r, mediaType, body)
+	if err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		sendError(w, APIError{Code: ErrValidation, Message: "send the album version you are changing in If-Match or as \"version\" in the body", Field: "version"}, http.StatusPreconditionRequired)
+		return
+	}
+	if expected != album.Version {
+		sendError(w, APIError{Code: ErrConflict, Message: errVersionConflict.Error(), Details: map[string]int{"expected": expected, "current": album.Version}}, http.StatusConflict)
+		return
+	}
+
+	original, err := json.Marshal(album)
+	if err != nil {
+		sendError(w, APIError{Code: ErrInternal, Message: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	var patchedJSON []byte
+	if mediaType == jsonPatchContentType {
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			sendError(w, APIError{Code: ErrValidation, Message: "body must be a JSON Patch array of operations", Details: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if op, ok := patchTouchesID(patch); ok {
+			sendError(w, APIError{Code: ErrValidation, Message: fmt.Sprintf("%s operation may not touch id", op), Field: "id"}, http.StatusUnprocessableEntity)
+			return
+		}
+		if patchedJSON, err = patch.Apply(original); err != nil {
+			sendError(w, APIError{Code: ErrValidation, Message: "patch could not be applied", Details: err.Error()}, http.StatusUnprocessableEntity)
+			return
+		}
+	} else {
+		if patchedJSON, err = jsonpatch.MergePatch(original, body); err != nil {
+			sendError(w, APIError{Code: ErrValidation, Message: "body must be a JSON object", Details: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var patched Album
+	if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "patched album is not valid", Details: err.Error()}, http.StatusUnprocessableEntity)
+		return
+	}
+	patched.CoverURL = album.CoverURL // set by uploading a cover, not by patching
+	patched.Tags = album.Tags         // changed through /albums/{id}/tags, not by patching
+	patched.AverageRating, patched.ReviewCount = album.AverageRating, album.ReviewCount
+	if patched.ID != id {
+		sendError(w, APIError{Code: ErrValidation, Message: "id cannot be changed", Field: "id"}, http.StatusUnprocessableEntity)
+		return
+	}
+	if errs := validateAlbum(&patched); errs != nil {
+		sendError(w, errs.APIError(), http.StatusUnprocessableEntity)
+		return
+	}
+	patched.Version = expected
+
+	if err := s.albums.UpdateAlbum(r.Context(), &patched); err != nil {
+		if err == errAlbumNotFound {
+			sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+			return
+		}
+		if err == errVersionConflict {
+			sendError(w, APIError{Code: ErrConflict, Message: err.Error()}, http.StatusConflict)
+			return
+		}
+		sendStorageError(w, err)
+		return
+	}
+	s.listCache.invalidate()
+	w.Header().Set("ETag", versionETag(patched.Version))
+	sendJSON(w, http.StatusOK, patched)
+}
+
+// versionETag is the ETag for an album version, e.g. "3"
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// expectedVersion finds the album version a PATCH is based on: the If-Match
+// header ("3", also weak or unquoted), else "version" in a merge patch, else
+// the value of a JSON Patch test operation on /version. ok is false when the
+// client sent none of them.
+func expectedVersion(r *http.Request, mediaType string, body []byte) (version int, ok bool, err error) {
+	if v := strings.TrimSpace(r.Header.Get("If-Match")); v != "" {
+		v = strings.Trim(strings.TrimPrefix(v, "W/"), `"`)
+		if version, err = strconv.Atoi(v); err != nil {
+			return 0, false, fmt.Errorf("If-Match must be an album version such as \"3\", got %q", r.Header.Get("If-Match"))
+		}
+		return version, true, nil
+	}
+
+	if mediaType != jsonPatchContentType {
+		var fields struct {
+			Version *int `json:"version"`
+		}
+		if err := json.Unmarshal(body, &fields); err != nil || fields.Version == nil {
+			return 0, false, nil
+		}
+		return *fields.Version, true, nil
+	}
+
+	var ops []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return 0, false, nil
+	}
+	for _, op := range ops {
+		if op.Op == "test" && op.Path == "/version" {
+			if err := json.Unmarshal(op.Value, &version); err != nil {
+				return 0, false, fmt.Errorf("test operation on /version needs an integer value")
+			}
+			return version, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// patchTouchesID reports the first operation other than test whose path or from is /id
+func patchTouchesID(patch jsonpatch.Patch) (string, bool) {
+	isID := func(pointer string) bool {
+		return pointer == "/id" || strings.HasPrefix(pointer, "/id/")
+	}
+	for _, op := range patch {
+		if op.Kind() == "test" {
+			continue
+		}
+		path, _ := op.Path()
+		from, _ := op.From()
+		if isID(path) || isID(from) {
+			return op.Kind(), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPatchAlbum(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	serve(t, h, http.MethodPost, "/v1/albums/1/tags", map[string][]string{"tags": {"cool"}}, nil, nil)
+	jsonPatch := http.Header{"Content-Type": {jsonPatchContentType}}
+
+	// The tags moved the album to version 2
+	var album Album
+	ops := []map[string]interface{}{
+		{"op": "test", "path": "/version", "value": 2},
+		{"op": "replace", "path": "/title", "value": "Jeru (remastered)"},
+	}
+	rec := serve(t, h, http.MethodPatch, "/v1/albums/1", ops, jsonPatch, &album)
+	if rec.Code != http.StatusOK || album.Title != "Jeru (remastered)" || album.Version != 3 || len(album.Tags) != 1 {
+		t.Fatalf("JSON Patch: status %d, %+v: %s", rec.Code, album, rec.Body)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"3"` {
+		t.Errorf("ETag %s, want \"3\"", etag)
+	}
+
+	merge := http.Header{"Content-Type": {mergePatchContentType}}
+	rec = serve(t, h, http.MethodPatch, "/v1/albums/1", map[string]interface{}{"version": 3, "price": 19.5}, merge, &album)
+	if rec.Code != http.StatusOK || album.Price != 19.5 || album.Version != 4 {
+		t.Errorf("merge patch with version: status %d, %+v", rec.Code, album)
+	}
+	rec = serve(t, h, http.MethodPatch, "/v1/albums/1", map[string]interface{}{"price": 21}, http.Header{"If-Match": {`W/"4"`}}, &album)
+	if rec.Code != http.StatusOK || album.Price != 21 {
+		t.Errorf("weak If-Match: status %d, %+v", rec.Code, album)
+	}
+}
+
+func TestPatchAlbumRejectsBadPatches(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	jsonPatch := http.Header{"Content-Type": {jsonPatchContentType}, "If-Match": {`"1"`}}
+	merge := http.Header{"Content-Type": {mergePatchContentType}, "If-Match": {`"1"`}}
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		header http.Header
+		body   interface{}
+		status int
+	}{
+		{"missing album", "/v1/albums/42", merge, map[string]string{"title": "x"}, http.StatusNotFound},
+		{"no version", "/v1/albums/1", http.Header{"Content-Type": {mergePatchContentType}}, map[string]string{"title": "x"}, http.StatusPreconditionRequired},
+		{"no test on /version", "/v1/albums/1", http.Header{"Content-Type": {jsonPatchContentType}}, []map[string]interface{}{{"op": "test", "path": "/title", "value": "Jeru"}}, http.StatusPreconditionRequired},
+		{"version not a number", "/v1/albums/1", http.Header{"Content-Type": {jsonPatchContentType}}, []map[string]interface{}{{"op": "test", "path": "/version", "value": "one"}}, http.StatusBadRequest},
+		{"bad If-Match", "/v1/albums/1", http.Header{"If-Match": {"*"}}, map[string]string{"title": "x"}, http.StatusBadRequest},
+		{"stale version", "/v1/albums/1", http.Header{"If-Match": {`"7"`}}, map[string]string{"title": "x"}, http.StatusConflict},
+		{"replace id", "/v1/albums/1", jsonPatch, []map[string]interface{}{{"op": "replace", "path": "/id", "value": "2"}}, http.StatusUnprocessableEntity},
+		{"move from id", "/v1/albums/1", jsonPatch, []map[string]interface{}{{"op": "copy", "from": "/id", "path": "/title"}}, http.StatusUnprocessableEntity},
+		{"failing test", "/v1/albums/1", jsonPatch, []map[string]interface{}{{"op": "test", "path": "/title", "value": "Giant Steps"}}, http.StatusUnprocessableEntity},
+		{"id in a merge patch", "/v1/albums/1", merge, map[string]string{"id": "2"}, http.StatusUnprocessableEntity},
+		{"invalid result", "/v1/albums/1", merge, map[string]interface{}{"price": -1}, http.StatusUnprocessableEntity},
+		{"wrong type", "/v1/albums/1", merge, map[string]interface{}{"title": 5}, http.StatusUnprocessableEntity},
+		{"form body", "/v1/albums/1", http.Header{"Content-Type": {"application/x-www-form-urlencoded"}, "If-Match": {`"1"`}}, "title=x", http.StatusUnsupportedMediaType},
+	} {
+		rec := serve(t, h, http.MethodPatch, tt.path, tt.body, tt.header, nil)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== Playlists ==========
+
+// maxPlaylistNameLen keeps names short enough to show in a list
+const maxPlaylistNameLen = 200
+
+var (
+	errPlaylistNotFound   = errors.New("playlist not found")
+	errAlbumInPlaylist    = errors.New("album is already on this playlist")
+	errAlbumNotInPlaylist = errors.New("album is not on this playlist")
+)
+
+// Playlist is a row of playlists: a named, ordered list of albums of one tenant
+type Playlist struct {
+	tableName struct{} `pg:"playlists"`
+
+	ID          int64     `json:"id" pg:"id,pk"`
+	TenantID    string    `json:"-" pg:"tenant_id"`
+	Name        string    `json:"name" pg:"name"`
+	Description string    `json:"description" pg:"description,use_zero"`
+	CreatedAt   time.Time `json:"created_at" pg:"created_at,default:now()"`
+}
+
+// PlaylistAlbum is a row of playlist_albums, putting an album on a playlist.
+// Positions start at 1: adding an album moves the ones at and after its
+// position down, removing one moves those after it up. Deleting the album
+// itself leaves a gap, which doesn't change the order.
+type PlaylistAlbum struct {
+	tableName struct{} `pg:"playlist_albums"`
+
+	PlaylistID int64  `pg:"playlist_id,pk"`
+	TenantID   string `pg:"tenant_id"`
+	AlbumID    string `pg:"album_id,pk"`
+	Position   int    `pg:"position"`
+}
+
+// PlaylistEntry is an album as listed on a playlist, with its position.
+// It is read from albums joined with playlist_albums.
+type PlaylistEntry struct {
+	tableName struct{} `pg:"albums,alias:album"`
+
+	Position int `json:"position" pg:"position"`
+	Album
+}
+
+// playlistWithAlbums is the body of GET /playlists/{playlistID}
+type playlistWithAlbums struct {
+	*Playlist
+	Albums []PlaylistEntry `json:"albums"`
+}
+
+// validatePlaylist checks a playlist sent to POST or PUT /playlists
+func validatePlaylist(p *Playlist) ValidationErrors {
+	var errs ValidationErrors
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		errs.Add("name", "name is required")
+	} else if len(p.Name) > maxPlaylistNameLen {
+		errs.Add("name", "name must be at most "+strconv.Itoa(maxPlaylistNameLen)+" characters")
+	}
+	return errs
+}
+
+// PlaylistRepository stores the playlists of the request's tenant. Calls
+// naming a missing playlist return errPlaylistNotFound.
+type PlaylistRepository interface {
+	ListPlaylists(ctx context.Context) ([]Playlist, error)
+	GetPlaylist(ctx context.Context, id int64) (*Playlist, error)
+	// PlaylistAlbums returns the albums on playlist id, in order
+	PlaylistAlbums(ctx context.Context, id int64) ([]PlaylistEntry, error)
+	CreatePlaylist(ctx context.Context, playlist *Playlist) error
+	// UpdatePlaylist replaces the name and description of playlist
+	UpdatePlaylist(ctx context.Context, playlist *Playlist) error
+	DeletePlaylist(ctx context.Context, id int64) error
+	// AddPlaylistAlbum puts album albumID at position, or at the end when
+	// position is 0 or past it. It returns errAlbumNotFound for an unknown
+	// album and errAlbumInPlaylist when it is already there.
+	AddPlaylistAlbum(ctx context.Context, id int64, albumID string, position int) error
+	// RemovePlaylistAlbum returns errAlbumNotInPlaylist when the album isn't there
+	RemovePlaylistAlbum(ctx context.Context, id int64, albumID string) error
+}
+
+type sqlPlaylistRepository struct {
+	db DB
+}
+
+func newSQLPlaylistRepository(db DB) *sqlPlaylistRepository {
+	return &sqlPlaylistRepository{db: db}
+}
+
+func (r *sqlPlaylistRepository) ListPlaylists(ctx context.Context) ([]Playlist, error) {
+	var playlists []Playlist
+	err := r.db.Select(ctx, &playlists, `SELECT * FROM playlists WHERE tenant_id = ? ORDER BY id`, tenantFromContext(ctx))
+	return playlists, err
+}
+
+func (r *sqlPlaylistRepository) GetPlaylist(ctx context.Context, id int64) (*Playlist, error) {
+	playlist := new(Playlist)
+	err := r.db.Select(ctx, playlist, `SELECT * FROM playlists WHERE tenant_id = ? AND id = ?`, tenantFromContext(ctx), id)
+	if err == sql.ErrNoRows {
+		return nil, errPlaylistNotFound
+	}
+	return playlist, err
+}
+
+func (r *sqlPlaylistRepository) PlaylistAlbums(ctx context.Context, id int64) ([]PlaylistEntry, error) {
+	var entries []PlaylistEntry
+	err := r.db.Select(ctx, &entries, `
+		SELECT album.*, pa.position
+		FROM albums AS album
+		JOIN playlist_albums AS pa ON pa.tenant_id = album.tenant_id AND pa.album_id = album.id
+		WHERE album.tenant_id = ? AND pa.playlist_id = ?
+		ORDER BY pa.position ASC`,
+		tenantFromContext(ctx), id)
+	return entries, err
+}
+
+func (r *sqlPlaylistRepository) CreatePlaylist(ctx context.Context, playlist *Playlist) error {
+	playlist.TenantID = tenantFromContext(ctx)
+	_, err := r.db.Insert(ctx, playlist, `
+		INSERT INTO playlists (tenant_id, name, description)
+		VALUES (?, ?, ?)
+		RETURNING *`,
+		playlist.TenantID, playlist.Name, playlist.Description)
+	return err
+}
+
+func (r *sqlPlaylistRepository) UpdatePlaylist(ctx context.Context, playlist *Playlist) error {
+	playlist.TenantID = tenantFromContext(ctx)
+	n, err := r.db.Update(ctx, playlist, `
+		UPDATE playlists SET name = ?, description = ?
+		WHERE tenant_id = ? AND id = ?
+		RETURNING *`,
+		playlist.Name, playlist.Description, playlist.TenantID, playlist.ID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errPlaylistNotFound
+	}
+	return nil
+}
+
+// DeletePlaylist removes the playlist; its playlist_albums rows go with it (ON DELETE CASCADE)
+func (r *sqlPlaylistRepository) DeletePlaylist(ctx context.Context, id int64) error {
+	n, err := r.db.Delete(ctx, nil, `DELETE FROM playlists WHERE tenant_id = ? AND id = ?`, tenantFromContext(ctx), id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errPlaylistNotFound
+	}
+	return nil
+}
+
+// lockPlaylist locks playlist id for the rest of tx, so concurrent changes to
+// its albums can't hand out the same position twice
+func lockPlaylist(ctx context.Context, tx DB, id int64) error {
+	var locked int64
+	err := tx.Select(ctx, &locked, `SELECT id FROM playlists WHERE tenant_id = ? AND id = ?`+tx.dialect().forUpdate,
+		tenantFromContext(ctx), id)
+	if err == sql.ErrNoRows {
+		return errPlaylistNotFound
+	}
+	return err
+}
+
+func (r *sqlPlaylistRepository) AddPlaylistAlbum(ctx context.Context, id int64, albumID string, position int) error {
+	return r.db.RunInTransaction(ctx, func(tx DB) error {
+		if err := lockPlaylist(ctx, tx, id); err != nil {
+			return err
+		}
+		exists, err := albumExists(ctx, tx, albumID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errAlbumNotFound
+		}
+
+		var last int
+		err = tx.Select(ctx, &last, `SELECT COALESCE(MAX(position), 0) FROM playlist_albums WHERE playlist_id = ?`, id)
+		if err != nil {
+			return err
+		}
+		if position <= 0 || position > last {
+			position = last + 1
+		}
+
+		_, err = tx.Update(ctx, nil, `UPDATE playlist_albums SET position = position + 1 WHERE playlist_id = ? AND position >= ?`,
+			id, position)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Insert(ctx, nil, `
+			INSERT INTO playlist_albums (playlist_id, tenant_id, album_id, position)
+			VALUES (?, ?, ?, ?)`,
+			id, tenantFromContext(ctx), albumID, position)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return errAlbumInPlaylist
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *sqlPlaylistRepository) RemovePlaylistAlbum(ctx context.Context, id int64, albumID string) error {
+	return r.db.RunInTransaction(ctx, func(tx DB) error {
+		if err := lockPlaylist(ctx, tx, id); err != nil {
+			return err
+		}
+
+		var removed []PlaylistAlbum
+		_, err := tx.Delete(ctx, &removed, `DELETE FROM playlist_albums WHERE playlist_id = ? AND album_id = ? RETURNING position`,
+			id, albumID)
+		if err != nil {
+			return err
+		}
+		if len(removed) == 0 {
+			return errAlbumNotInPlaylist
+		}
+
+		_, err = tx.Update(ctx, nil, `UPDATE playlist_albums SET position = position - 1 WHERE playlist_id = ? AND position > ?`,
+			id, removed[0].Position)
+		return err
+	})
+}
+
+// parsePlaylistID reads the {playlistID} URL parameter
+func parsePlaylistID(r *http.Request) (int64, *FieldError) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "playlistID"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, &FieldError{Field: "id", Message: "invalid playlist id"}
+	}
+	return id, nil
+}
+
+// decodePlaylist reads and validates the body of POST and PUT /playlists,
+// answering the request itself when it is invalid
+func decodePlaylist(w http.ResponseWriter, r *http.Request) (*Playlist, bool) {
+	defer r.Body.Close()
+	// Only the name and description are the client's to set
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "Invalid request body"}, http.StatusBadRequest)
+		return nil, false
+	}
+	playlist := Playlist{Name: body.Name, Description: body.Description}
+	if errs := validatePlaylist(&playlist); errs != nil {
+		sendError(w, errs.APIError(), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return &playlist, true
+}
+
+// sendPlaylistError answers for a failed PlaylistRepository call
+func sendPlaylistError(w http.ResponseWriter, err error) {
+	switch err {
+	case errPlaylistNotFound, errAlbumNotInPlaylist:
+		sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+	case errAlbumNotFound:
+		sendError(w, APIError{Code: ErrValidation, Message: err.Error(), Field: "album_id"}, http.StatusUnprocessableEntity)
+	case errAlbumInPlaylist:
+		sendError(w, APIError{Code: ErrConflict, Message: err.Error()}, http.StatusConflict)
+	default:
+		sendStorageError(w, err)
+	}
+}
+
+// sendPlaylist answers with playlist id and its albums
+func (s *Server) sendPlaylist(w http.ResponseWriter, r *http.Request, status int, id int64) {
+	playlist, err := s.playlists.GetPlaylist(r.Context(), id)
+	if err != nil {
+		sendPlaylistError(w, err)
+		return
+	}
+	albums, err := s.playlists.PlaylistAlbums(r.Context(), id)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if albums == nil {
+		albums = []PlaylistEntry{}
+	}
+	sendJSON(w, status, playlistWithAlbums{Playlist: playlist, Albums: albums})
+}
+
+// listPlaylists serves GET /playlists, without their albums
+func (s *Server) listPlaylists(w http.ResponseWriter, r *http.Request) {
+	playlists, err := s.playlists.ListPlaylists(r.Context())
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if playlists == nil {
+		playlists = []Playlist{}
+	}
+	sendJSON(w, http.StatusOK, playlists)
+}
+
+// createPlaylist serves POST /playlists
+func (s *Server) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := decodePlaylist(w, r)
+	if !ok {
+		return
+	}
+	if err := s.playlists.CreatePlaylist(r.Context(), playlist); err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSON(w, http.StatusCreated, playlistWithAlbums{Playlist: playlist, Albums: []PlaylistEntry{}})
+}
+
+// getPlaylist serves GET /playlists/{playlistID}
+func (s *Server) getPlaylist(w http.ResponseWriter, r *http.Request) {
+	id, fe := parsePlaylistID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	s.sendPlaylist(w, r, http.StatusOK, id)
+}
+
+// putPlaylist serves PUT /playlists/{playlistID}, replacing the name and description
+func (s *Server) putPlaylist(w http.ResponseWriter, r *http.Request) {
+	id, fe := parsePlaylistID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	playlist, ok := decodePlaylist(w, r)
+	if !ok {
+		return
+	}
+	playlist.ID = id
+	if err := s.playlists.UpdatePlaylist(r.Context(), playlist); err != nil {
+		sendPlaylistError(w, err)
+		return
+	}
+	s.sendPlaylist(w, r, http.StatusOK, id)
+}
+
+// deletePlaylist serves DELETE /playlists/{playlistID}
+func (s *Server) deletePlaylist(w http.ResponseWriter, r *http.Request) {
+	id, fe := parsePlaylistID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	if err := s.playlists.DeletePlaylist(r.Context(), id); err != nil {
+		sendPlaylistError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addPlaylistAlbum serves POST /playlists/{playlistID}/albums with
+// {"album_id": "...", "position": n}, answering with the updated playlist
+func (s *Server) addPlaylistAlbum(w http.ResponseWriter, r *http.Request) {
+	id, fe := parsePlaylistID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+	var body struct {
+		AlbumID  string `json:"album_id"`
+		Position int    `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+	var errs ValidationErrors
+	if !validAlbumID(body.AlbumID) {
+		errs.Add("album_id", "invalid album id format")
+	}
+	if body.Position < 0 {
+		errs.Add("position", "position must be positive, or 0 to add at the end")
+	}
+	if errs != nil {
+		sendError(w, errs.APIError(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := s.playlists.AddPlaylistAlbum(r.Context(), id, body.AlbumID, body.Position); err != nil {
+		sendPlaylistError(w, err)
+		return
+	}
+	s.sendPlaylist(w, r, http.StatusCreated, id)
+}
+
+// removePlaylistAlbum serves DELETE /playlists/{playlistID}/albums/{albumID}
+func (s *Server) removePlaylistAlbum(w http.ResponseWriter, r *http.Request) {
+	id, fe := parsePlaylistID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	if err := s.playlists.RemovePlaylistAlbum(r.Context(), id, chi.URLParam(r, "albumID")); err != nil {
+		sendPlaylistError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListPlaylists(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+
+	var playlists []Playlist
+	if rec := serve(t, h, http.MethodGet, "/v1/playlists", nil, admin, &playlists); rec.Code != http.StatusOK || playlists == nil || len(playlists) != 0 {
+		t.Fatalf("empty list: status %d, %v", rec.Code, playlists)
+	}
+	for _, name := range []string{"Late night", "Early morning"} {
+		serve(t, h, http.MethodPost, "/v1/playlists", map[string]string{"name": name, "description": "for " + name}, admin, nil)
+	}
+	serve(t, h, http.MethodGet, "/v1/playlists", nil, admin, &playlists)
+	if len(playlists) != 2 || playlists[0].Name != "Late night" || playlists[1].Description != "for Early morning" {
+		t.Errorf("playlists %+v", playlists)
+	}
+	if rec := serve(t, h, http.MethodGet, "/v1/playlists", nil, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without the admin token: status %d, want 401", rec.Code)
+	}
+}
+
+func TestPlaylistsRejectBadRequests(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	var playlist playlistWithAlbums
+	serve(t, h, http.MethodPost, "/v1/playlists", map[string]string{"name": "Late night"}, admin, &playlist)
+	path := "/v1/playlists/" + strconv.FormatInt(playlist.ID, 10)
+
+	for _, tt := range []struct {
+		method, path string
+		body         interface{}
+		status       int
+	}{
+		{http.MethodPost, "/v1/playlists", map[string]string{"name": " "}, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/v1/playlists", map[string]string{"name": strings.Repeat("x", maxPlaylistNameLen+1)}, http.StatusBadRequest},
+		{http.MethodGet, "/v1/playlists/0", nil, http.StatusBadRequest},
+		{http.MethodGet, "/v1/playlists/x", nil, http.StatusBadRequest},
+		{http.MethodGet, "/v1/playlists/99", nil, http.StatusNotFound},
+		{http.MethodPut, "/v1/playlists/x", map[string]string{"name": "a"}, http.StatusBadRequest},
+		{http.MethodPut, "/v1/playlists/99", map[string]string{"name": "a"}, http.StatusNotFound},
+		{http.MethodPut, path, map[string]string{"name": ""}, http.StatusUnprocessableEntity},
+		{http.MethodDelete, "/v1/playlists/x", nil, http.StatusBadRequest},
+		{http.MethodDelete, "/v1/playlists/99", nil, http.StatusNotFound},
+		{http.MethodPost, "/v1/playlists/x/albums", map[string]string{"album_id": "1"}, http.StatusBadRequest},
+		{http.MethodPost, "/v1/playlists/99/albums", map[string]string{"album_id": "1"}, http.StatusNotFound},
+		{http.MethodPost, path + "/albums", map[string]interface{}{"album_id": "1", "position": -1}, http.StatusBadRequest},
+		{http.MethodPost, path + "/albums", map[string]interface{}{"album_id": ""}, http.StatusUnprocessableEntity},
+		{http.MethodDelete, "/v1/playlists/x/albums/1", nil, http.StatusBadRequest},
+		{http.MethodDelete, path + "/albums/1", nil, http.StatusNotFound},
+		{http.MethodDelete, "/v1/playlists/99/albums/1", nil, http.StatusNotFound},
+	} {
+		if rec := serve(t, h, tt.method, tt.path, tt.body, admin, nil); rec.Code != tt.status {
+			t.Errorf("%s %s %v: status %d, want %d: %s", tt.method, tt.path, tt.body, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// ========== Pretty-Printed JSON ==========
+
+// prettyWriter marks a response whose JSON body should be indented
+type prettyWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush
+func (w *prettyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// prettyMiddleware opts a request into indented JSON when it has
+// ?pretty=true, for reading responses by eye. Everything else stays compact.
+func prettyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if on, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); on {
+			w = &prettyWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// markedWith reports whether w, or a writer it wraps, is a T
+func markedWith[T http.ResponseWriter](w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(T); ok {
+			return true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// prettyJSON indents data by two spaces when the request asked for it with
+// ?pretty=true, and returns it unchanged otherwise
+func prettyJSON(w http.ResponseWriter, data []byte) []byte {
+	if !markedWith[*prettyWriter](w) {
+		return data
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return data
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== Price History ==========
+
+// PriceChange is one change of an album's price, taken from an update in audit_logs
+type PriceChange struct {
+	ChangedAt time.Time `json:"changed_at"`
+	From      float64   `json:"from"`
+	To        float64   `json:"to"`
+}
+
+// PriceHistory returns the price changes of the album, oldest first, read
+// from the old_value and new_value of its audited updates. Updates that left
+// the price alone are skipped. Prices are compared once decrypted, since the
+// same price encrypts differently every time.
+func (r *sqlAuditLogRepository) PriceHistory(ctx context.Context, albumID string) ([]PriceChange, error) {
+	var rows []struct {
+		ID          int64
+		PerformedAt time.Time
+		OldPrice    string
+		NewPrice    string
+	}
+	err := retryDB(ctx, dbRetryAttempts, func() error {
+		rows = nil
+		return r.db.Select(ctx, &rows, `
+			SELECT id, performed_at, old_value->>'price' AS old_price, new_value->>'price' AS new_price
+			FROM audit_logs
+			WHERE tenant_id = ? AND table_name = 'albums' AND record_id = ? AND action = ?
+			ORDER BY performed_at ASC, id ASC`,
+			tenantFromContext(ctx), albumID, auditUpdate)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []PriceChange{}
+	for _, row := range rows {
+		from, err := decodePrice(row.OldPrice)
+		if err != nil {
+			return nil, fmt.Errorf("audit log %d: %w", row.ID, err)
+		}
+		to, err := decodePrice(row.NewPrice)
+		if err != nil {
+			return nil, fmt.Errorf("audit log %d: %w", row.ID, err)
+		}
+		if from != to {
+			changes = append(changes, PriceChange{ChangedAt: row.PerformedAt, From: from, To: to})
+		}
+	}
+	return changes, nil
+}
+
+// getPriceHistory serves GET /albums/{id}/price-history. An album whose price
+// never changed, or that doesn't exist, has an empty history rather than a 404.
+func (s *Server) getPriceHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+
+	changes, err := s.audit.PriceHistory(r.Context(), id)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSON(w, http.StatusOK, changes)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ========== Reverse Proxy ==========
+
+// forwardedRequest is what the proxy in front of us says about the original request
+type forwardedRequest struct {
+	clientIP string
+	scheme   string
+	host     string
+}
+
+type forwardedKey struct{}
+
+// loadTrustProxy reads TRUST_PROXY. Only turn it on when every request comes
+// through a proxy that sets the X-Forwarded-* headers, or clients can spoof them.
+func loadTrustProxy() bool {
+	v := os.Getenv("TRUST_PROXY")
+	if v == "" {
+		return false
+	}
+	trust, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("TRUST_PROXY must be true or false, got %q", v)
+	}
+	return trust
+}
+
+// proxyMiddleware stores the client IP, scheme and host from X-Forwarded-For,
+// X-Forwarded-Proto and X-Forwarded-Host in the request context. When trust is
+// false the headers are ignored and the connection's own values are used.
+func proxyMiddleware(trust bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !trust {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fwd := forwardedRequest{
+				clientIP: lastForwardedFor(r.Header.Get("X-Forwarded-For")),
+				scheme:   strings.ToLower(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto"))),
+				host:     strings.TrimSpace(r.Header.Get("X-Forwarded-Host")),
+			}
+			if fwd.scheme != "http" && fwd.scheme != "https" {
+				fwd.scheme = ""
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), forwardedKey{}, fwd)))
+		})
+	}
+}
+
+// lastForwardedFor picks the address our proxy appended to X-Forwarded-For.
+// Entries before it were supplied by the client and can't be trusted.
+func lastForwardedFor(header string) string {
+	if header == "" {
+		return ""
+	}
+	parts := strings.Split(header, ",")
+	ip := strings.TrimSpace(parts[len(parts)-1])
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return ip
+}
+
+func forwardedFromContext(ctx context.Context) forwardedRequest {
+	fwd, _ := ctx.Value(forwardedKey{}).(forwardedRequest)
+	return fwd
+}
+
+// clientIP is the address of the client, as reported by a trusted proxy or
+// taken from the connection
+func clientIP(r *http.Request) string {
+	if ip := forwardedFromContext(r.Context()).clientIP; ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
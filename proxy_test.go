@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyMiddleware(t *testing.T) {
+	var got forwardedRequest
+	var ip string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = forwardedFromContext(r.Context())
+		ip = clientIP(r)
+	})
+	send := func(trust bool, header http.Header) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/albums", nil)
+		req.RemoteAddr = "10.0.0.1:5678"
+		req.Header = header
+		proxyMiddleware(trust)(next).ServeHTTP(httptest.NewRecorder(), req)
+	}
+	forwarded := http.Header{
+		"X-Forwarded-For":   {"6.6.6.6, 203.0.113.7"},
+		"X-Forwarded-Proto": {"HTTPS"},
+		"X-Forwarded-Host":  {"api.example.com"},
+	}
+
+	send(true, forwarded)
+	if want := (forwardedRequest{clientIP: "203.0.113.7", scheme: "https", host: "api.example.com"}); got != want || ip != "203.0.113.7" {
+		t.Errorf("trusted: %+v, client %s; want %+v", got, ip, want)
+	}
+	send(false, forwarded)
+	if got != (forwardedRequest{}) || ip != "10.0.0.1" {
+		t.Errorf("untrusted: %+v, client %s; want the connection's address", got, ip)
+	}
+	send(true, http.Header{"X-Forwarded-For": {"not an ip"}, "X-Forwarded-Proto": {"gopher"}})
+	if got != (forwardedRequest{}) || ip != "10.0.0.1" {
+		t.Errorf("bad headers: %+v, client %s", got, ip)
+	}
+}
+
+func TestLoadTrustProxy(t *testing.T) {
+	t.Setenv("TRUST_PROXY", "")
+	if loadTrustProxy() {
+		t.Error("trusted without TRUST_PROXY")
+	}
+	t.Setenv("TRUST_PROXY", "true")
+	if !loadTrustProxy() {
+		t.Error("TRUST_PROXY=true not trusted")
+	}
+}
+
+func TestClientIPWithoutPort(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "@"
+	if ip := clientIP(req); ip != "@" {
+		t.Errorf("clientIP = %q, want the address as it is", ip)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+)
+
+// ========== Random Album ==========
+
+// getRandomAlbum serves GET /albums/random, a "surprise me" pick among the
+// albums matching the same artist, q, tag and price filters as GET /albums.
+// It is a read like any other, so maintenance mode doesn't stop it.
+func (s *Server) getRandomAlbum(w http.ResponseWriter, r *http.Request) {
+	minPrice, maxPrice, fe := parsePriceRange(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	opts := AlbumListOptions{
+		Artist:      strings.TrimSpace(r.URL.Query().Get("artist")),
+		TitleSearch: strings.TrimSpace(r.URL.Query().Get("q")),
+		Tag:         r.URL.Query().Get("tag"),
+		MinPrice:    minPrice,
+		MaxPrice:    maxPrice,
+	}
+
+	album, err := s.albums.RandomAlbum(r.Context(), opts)
+	if err == errAlbumNotFound {
+		sendError(w, APIError{Code: ErrNotFound, Message: "no album matches the filters"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	// Every call may answer differently, so nothing may reuse this response
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(w, http.StatusOK, wrapWithHAL(album, r))
+}
+
+// RandomAlbum picks one of the albums matching opts with ORDER BY random().
+// That reads every matching row, but TABLESAMPLE would sample before the
+// filters and could come back empty while albums match.
+func (r *sqlAlbumRepository) RandomAlbum(ctx context.Context, opts AlbumListOptions) (album Album, err error) {
+	err = retryDB(ctx, dbRetryAttempts, func() error {
+		db := r.reader(ctx)
+		album = Album{}
+		where := applyAlbumFilters(db.dialect(), albumWhere(ctx), opts)
+		if err := db.Select(ctx, &album, selectAlbumsSQL+where.String()+" ORDER BY random() LIMIT 1", where.args...); err != nil {
+			return err
+		}
+		one := []Album{album}
+		if err := loadAlbumTags(ctx, db, one); err != nil {
+			return err
+		}
+		album.Tags = one[0].Tags
+		return nil
+	})
+	if err == sql.ErrNoRows {
+		return album, errAlbumNotFound
+	}
+	return album, err
+}
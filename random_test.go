@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetRandomAlbum(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	for _, a := range []Album{
+		{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+		{ID: "2", Title: "Time Out", Artist: "Dave Brubeck", Price: 22},
+		{ID: "3", Title: "Kind of Blue", Artist: "Miles Davis", Price: 29.99, Tags: []string{}},
+	} {
+		serve(t, h, http.MethodPost, "/v1/albums", a, nil, nil)
+	}
+	serve(t, h, http.MethodPost, "/v1/albums/3/tags", map[string][]string{"tags": {"modal"}}, nil, nil)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 50 && len(seen) < 3; i++ {
+		var album Album
+		rec := serve(t, h, http.MethodGet, "/v1/albums/random", nil, nil, &album)
+		if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("status %d, Cache-Control %q", rec.Code, rec.Header().Get("Cache-Control"))
+		}
+		seen[album.ID] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("50 picks only found %v", seen)
+	}
+
+	var album Album
+	serve(t, h, http.MethodGet, "/v1/albums/random?tag=modal&min_price=20", nil, nil, &album)
+	if album.ID != "3" || len(album.Tags) != 1 {
+		t.Errorf("filtered pick %+v, want album 3 with its tag", album)
+	}
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/random?artist=nobody", nil, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("no match: status %d, want 404", rec.Code)
+	}
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/random?min_price=-1", nil, nil, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad price: status %d, want 400", rec.Code)
+	}
+}
+
+func TestGetRandomAlbumStorageError(t *testing.T) {
+	srv, repo := newTestServer(testAlbums...)
+	repo.err = errDatabaseUnavailable
+	if rec := serve(t, newTestRouter(t, srv), http.MethodGet, "/v1/albums/random", nil, nil, nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", rec.Code)
+	}
+}
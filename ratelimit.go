@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ========== Rate Limiting ==========
+
+// rateLimitIdleTTL is how long a client's bucket is kept after its last
+// request; by then it would be full again anyway
+const rateLimitIdleTTL = 10 * time.Minute
+
+// rateLimiter gives every client IP a token bucket holding up to burst
+// requests and refilled at perSecond. A nil limiter limits nothing.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	swept     time.Time // when idle buckets were last dropped
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		perSecond: float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// rateLimitConfig reads RATE_LIMIT_PER_MINUTE and RATE_LIMIT_BURST (default:
+// the per-minute rate). A per-minute rate of 0, the default, means no limit.
+func rateLimitConfig() (perMinute, burst int, err error) {
+	if v := os.Getenv("RATE_LIMIT_PER_MINUTE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("RATE_LIMIT_PER_MINUTE must be a non-negative integer, got %q", v)
+		}
+		perMinute = n
+	}
+	if perMinute == 0 {
+		return 0, 0, nil
+	}
+
+	burst = perMinute
+	if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("RATE_LIMIT_BURST must be a positive integer, got %q", v)
+		}
+		burst = n
+	}
+	return perMinute, burst, nil
+}
+
+// limits reports whether l already enforces perMinute and burst, so a reload
+// that changes neither keeps the clients' buckets
+func (l *rateLimiter) limits(perMinute, burst int) bool {
+	if l == nil {
+		return perMinute == 0
+	}
+	return l.perSecond == float64(perMinute)/60 && l.burst == float64(burst)
+}
+
+// allow takes a token from client's bucket at now. When the bucket is empty
+// it reports false and how long until the next token.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > rateLimitIdleTTL {
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > rateLimitIdleTTL {
+				delete(l.buckets, key)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.perSecond)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimitMiddleware answers 429 to clients that ran out of requests of the
+// limiter in current, which POST /admin/reload may replace or set to nil.
+// Requests authMiddleware found the admin token on are never limited, so
+// admin tooling can run bulk operations; it must run before this middleware.
+// Health checks aren't limited either, however often a load balancer polls.
+func rateLimitMiddleware(current *atomic.Pointer[rateLimiter]) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l := current.Load()
+			if l == nil || isAdmin(r.Context()) || r.URL.Path == "/healthz" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if ok, wait := l.allow(clientIP(r), time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				sendError(w, APIError{Code: ErrRateLimitExceeded, Message: "too many requests, slow down"}, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
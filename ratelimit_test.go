@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := newRateLimiter(60, 2) // one token a second, two at once
+	start := time.Unix(1_700_000_000, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("1.2.3.4", start); !ok {
+			t.Fatalf("request %d within the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.allow("1.2.3.4", start)
+	if ok {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if wait != time.Second {
+		t.Errorf("wait = %v, want 1s", wait)
+	}
+	if ok, _ := l.allow("5.6.7.8", start); !ok {
+		t.Error("another client was limited by the first one's bucket")
+	}
+	if ok, _ := l.allow("1.2.3.4", start.Add(time.Second)); !ok {
+		t.Error("request after a refill was limited")
+	}
+}
+
+func TestRateLimitMiddlewareExemptsAdmins(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var limiter atomic.Pointer[rateLimiter]
+	limiter.Store(newRateLimiter(1, 1))
+	h := authMiddleware(rateLimitMiddleware(&limiter)(ok))
+
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/albums", nil)
+		req.RemoteAddr = "1.2.3.4:5678"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(""); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", rec.Code)
+	}
+	rec := send("")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+	if rec := send("wrong"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("wrong token: status %d, want 429", rec.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rec := send("secret"); rec.Code != http.StatusOK {
+			t.Fatalf("admin request %d: status %d, want 200", i+1, rec.Code)
+		}
+	}
+}
+
+// POST /admin/reload swaps the limiter the middleware uses, keeps it while
+// the limits stay the same, and keeps it too when a value is invalid
+func TestReloadRateLimit(t *testing.T) {
+	config := &runtimeConfig{maintenance: &maintenanceMode{}, slowQueries: &slowQueryHook{}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := rateLimitMiddleware(&config.rateLimiter)(ok)
+	send := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/albums", nil)
+		req.RemoteAddr = "1.2.3.4:5678"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "")
+	if _, err := config.load("test"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if code := send(); code != http.StatusOK {
+			t.Fatalf("request %d without a limit: status %d", i+1, code)
+		}
+	}
+
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "60")
+	t.Setenv("RATE_LIMIT_BURST", "1")
+	cfg, err := config.load("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimitPerMinute != 60 || cfg.RateLimitBurst != 1 {
+		t.Errorf("reloaded %+v, want 60 per minute with a burst of 1", cfg)
+	}
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", code)
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", code)
+	}
+
+	limiter := config.rateLimiter.Load()
+	if _, err := config.load("test"); err != nil {
+		t.Fatal(err)
+	}
+	if config.rateLimiter.Load() != limiter {
+		t.Error("reloading the same limits replaced the limiter and its buckets")
+	}
+
+	t.Setenv("RATE_LIMIT_BURST", "lots")
+	if _, err := config.load("test"); err == nil {
+		t.Error("RATE_LIMIT_BURST=lots was accepted")
+	}
+	if config.rateLimiter.Load() != limiter {
+		t.Error("an invalid reload changed the limiter")
+	}
+
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "0")
+	if _, err := config.load("test"); err != nil {
+		t.Fatal(err)
+	}
+	if code := send(); code != http.StatusOK {
+		t.Errorf("after turning the limit off: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ========== Redis Album Cache ==========
+
+const defaultRedisCacheTTL = 5 * time.Minute
+
+// connectRedis connects to REDIS_URL, or returns nil when it is unset
+func connectRedis() *redis.Client {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return nil
+	}
+
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		log.Fatalf("Invalid REDIS_URL: %v", err)
+	}
+	rdb := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	log.Println("Redis cache enabled")
+	return rdb
+}
+
+// redisCacheTTL reads REDIS_CACHE_TTL_SECONDS, defaulting to five minutes
+func redisCacheTTL() time.Duration {
+	v := os.Getenv("REDIS_CACHE_TTL_SECONDS")
+	if v == "" {
+		return defaultRedisCacheTTL
+	}
+
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		log.Fatalf("REDIS_CACHE_TTL_SECONDS must be a positive integer, got %q", v)
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// redisAlbumRepository is a read-through cache for single albums in front of
+// another AlbumRepository. Redis failures are logged and fall through to the
+// wrapped repository, so the cache can never make a read fail.
+type redisAlbumRepository struct {
+	AlbumRepository
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+func newRedisAlbumRepository(next AlbumRepository, rdb *redis.Client, ttl time.Duration) *redisAlbumRepository {
+	return &redisAlbumRepository{AlbumRepository: next, rdb: rdb, ttl: ttl}
+}
+
+func albumCacheKey(ctx context.Context, id string) string {
+	return "album:" + tenantFromContext(ctx) + ":" + id
+}
+
+func (r *redisAlbumRepository) GetAlbumByID(ctx context.Context, id string, fields []string) (Album, error) {
+	// The cache always holds the full album; the handler trims it to fields
+	if album, ok := r.cached(ctx, id); ok {
+		return album, nil
+	}
+
+	album, err := r.AlbumRepository.GetAlbumByID(ctx, id, fields)
+	if err != nil || fields != nil {
+		// Partial rows must not be cached as if they were the whole album
+		return album, err
+	}
+
+	data, err := json.Marshal(album)
+	if err != nil {
+		log.Printf("Failed to encode album %q for cache: %v", id, err)
+		return album, nil
+	}
+	if err := r.rdb.Set(ctx, albumCacheKey(ctx, id), data, r.ttl).Err(); err != nil {
+		log.Printf("Failed to cache album %q: %v", id, err)
+	}
+	return album, nil
+}
+
+func (r *redisAlbumRepository) cached(ctx context.Context, id string) (Album, bool) {
+	var album Album
+
+	data, err := r.rdb.Get(ctx, albumCacheKey(ctx, id)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Failed to read album %q from cache: %v", id, err)
+		}
+		return album, false
+	}
+	if err := json.Unmarshal(data, &album); err != nil {
+		log.Printf("Discarding unreadable cache entry for album %q: %v", id, err)
+		return album, false
+	}
+	return album, true
+}
+
+func (r *redisAlbumRepository) UpdateAlbum(ctx context.Context, album *Album) error {
+	if err := r.AlbumRepository.UpdateAlbum(ctx, album); err != nil {
+		return err
+	}
+	r.evict(ctx, album.ID)
+	return nil
+}
+
+func (r *redisAlbumRepository) DeleteAlbum(ctx context.Context, id string) error {
+	if err := r.AlbumRepository.DeleteAlbum(ctx, id); err != nil {
+		return err
+	}
+	r.evict(ctx, id)
+	return nil
+}
+
+func (r *redisAlbumRepository) DeleteAlbums(ctx context.Context, ids []string) ([]string, error) {
+	deleted, err := r.AlbumRepository.DeleteAlbums(ctx, ids)
+	if err != nil {
+		return deleted, err
+	}
+	r.evict(ctx, ids...)
+	return deleted, nil
+}
+
+func (r *redisAlbumRepository) MultiplyArtistPrices(ctx context.Context, artist string, multiplier float64) ([]string, error) {
+	updated, err := r.AlbumRepository.MultiplyArtistPrices(ctx, artist, multiplier)
+	if err != nil {
+		return updated, err
+	}
+	if len(updated) > 0 {
+		r.evict(ctx, updated...)
+	}
+	return updated, nil
+}
+
+func (r *redisAlbumRepository) AddAlbumTags(ctx context.Context, id string, tags []string) ([]string, error) {
+	all, err := r.AlbumRepository.AddAlbumTags(ctx, id, tags)
+	if err != nil {
+		return all, err
+	}
+	r.evict(ctx, id)
+	return all, nil
+}
+
+func (r *redisAlbumRepository) RemoveAlbumTag(ctx context.Context, id, tag string) error {
+	if err := r.AlbumRepository.RemoveAlbumTag(ctx, id, tag); err != nil {
+		return err
+	}
+	r.evict(ctx, id)
+	return nil
+}
+
+// CreateReview and DeleteReview evict the album, as its cached copy carries
+// the review statistics
+func (r *redisAlbumRepository) CreateReview(ctx context.Context, review *Review) error {
+	if err := r.AlbumRepository.CreateReview(ctx, review); err != nil {
+		return err
+	}
+	r.evict(ctx, review.AlbumID)
+	return nil
+}
+
+func (r *redisAlbumRepository) DeleteReview(ctx context.Context, albumID string, id int64) error {
+	if err := r.AlbumRepository.DeleteReview(ctx, albumID, id); err != nil {
+		return err
+	}
+	r.evict(ctx, albumID)
+	return nil
+}
+
+func (r *redisAlbumRepository) evict(ctx context.Context, ids ...string) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = albumCacheKey(ctx, id)
+	}
+	if err := r.rdb.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("Failed to evict albums %v from cache: %v", ids, err)
+	}
+}
+
+// flushAlbumCache deletes every cached album of every tenant and returns how
+// many keys it removed. Other keys in the same Redis database are left alone.
+func flushAlbumCache(ctx context.Context, rdb *redis.Client) (int, error) {
+	removed := 0
+	iter := rdb.Scan(ctx, 0, "album:*", 500).Iterator()
+	for iter.Next(ctx) {
+		n, err := rdb.Del(ctx, iter.Val()).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += int(n)
+	}
+	return removed, iter.Err()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// ========== Runtime Configuration ==========
+
+// logLevel filters the structured logger and can be changed by POST /admin/reload
+var logLevel = new(slog.LevelVar)
+
+// logger writes structured logs such as slow queries. Plain log.Printf output
+// is not affected by LOG_LEVEL, so startup and fatal messages are never hidden.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+// parseLogLevel reads LOG_LEVEL: debug, info, warn or error, defaulting to info
+func parseLogLevel() (slog.Level, error) {
+	var level slog.Level
+	v := os.Getenv("LOG_LEVEL")
+	if v == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		return 0, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", v)
+	}
+	return level, nil
+}
+
+// runtimeConfig is the part of the configuration that can be re-read from
+// .env, the config file and the environment without restarting. main applies it once with
+// load at startup.
+type runtimeConfig struct {
+	maintenance *maintenanceMode
+	slowQueries *slowQueryHook
+	rateLimiter atomic.Pointer[rateLimiter] // nil while RATE_LIMIT_PER_MINUTE is 0
+}
+
+// reloadedConfig is the body of POST /admin/reload: the settings now in effect
+type reloadedConfig struct {
+	LogLevel                string `json:"log_level"`
+	MaintenanceMode         bool   `json:"maintenance_mode"`
+	MaintenanceRetryAfter   int    `json:"maintenance_retry_after_seconds"`
+	SlowQueryThresholdMilli int64  `json:"slow_query_threshold_ms"`
+	RateLimitPerMinute      int    `json:"rate_limit_per_minute"`
+	RateLimitBurst          int    `json:"rate_limit_burst"`
+}
+
+// load reads every reloadable setting and applies them only if all are
+// valid, so a typo never leaves the server half reconfigured
+func (c *runtimeConfig) load(by string) (reloadedConfig, error) {
+	level, err := parseLogLevel()
+	if err != nil {
+		return reloadedConfig{}, err
+	}
+	maintenance, retryAfter, err := maintenanceConfig()
+	if err != nil {
+		return reloadedConfig{}, err
+	}
+	threshold, err := slowQueryThreshold()
+	if err != nil {
+		return reloadedConfig{}, err
+	}
+	perMinute, burst, err := rateLimitConfig()
+	if err != nil {
+		return reloadedConfig{}, err
+	}
+
+	logLevel.Set(level)
+	c.maintenance.retryAfter.Store(int64(retryAfter))
+	c.maintenance.set(maintenance, by)
+	c.slowQueries.setThreshold(threshold)
+	if !c.rateLimiter.Load().limits(perMinute, burst) {
+		var limiter *rateLimiter
+		if perMinute > 0 {
+			limiter = newRateLimiter(perMinute, burst)
+		}
+		c.rateLimiter.Store(limiter)
+	}
+
+	return reloadedConfig{
+		LogLevel:                strings.ToLower(level.String()),
+		MaintenanceMode:         maintenance,
+		MaintenanceRetryAfter:   int(retryAfter / time.Second),
+		SlowQueryThresholdMilli: threshold.Milliseconds(),
+		RateLimitPerMinute:      perMinute,
+		RateLimitBurst:          burst,
+	}, nil
+}
+
+// reloadConfig serves POST /admin/reload. It re-reads .env, overriding
+// variables already in the environment, and the config file, then applies the
+// reloadable settings.
+func (c *runtimeConfig) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	if err := godotenv.Overload(); err != nil && !os.IsNotExist(err) {
+		sendError(w, APIError{Code: ErrInternal, Message: fmt.Sprintf("reading .env: %v", err)}, http.StatusInternalServerError)
+		return
+	}
+	if loadedConfig != nil {
+		if err := loadedConfig.apply(); err != nil {
+			sendError(w, APIError{Code: ErrValidation, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	actor := actorFromContext(r.Context())
+	cfg, err := c.load(actor)
+	if err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	log.Printf("Configuration reloaded by %s: log level %s, maintenance mode %t, slow query threshold %dms, rate limit %d/min (burst %d)",
+		actor, cfg.LogLevel, cfg.MaintenanceMode, cfg.SlowQueryThresholdMilli, cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+	sendJSON(w, http.StatusOK, cfg)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	defer logLevel.Set(slog.LevelInfo)
+
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("MAINTENANCE_MODE", "true")
+	t.Setenv("MAINTENANCE_RETRY_AFTER_SECONDS", "30")
+	t.Setenv("SLOW_QUERY_THRESHOLD_MS", "250")
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "")
+	var cfg reloadedConfig
+	if rec := serve(t, h, http.MethodPost, "/v1/admin/reload", nil, admin, &cfg); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	want := reloadedConfig{LogLevel: "warn", MaintenanceMode: true, MaintenanceRetryAfter: 30, SlowQueryThresholdMilli: 250}
+	if cfg != want {
+		t.Errorf("reloaded %+v, want %+v", cfg, want)
+	}
+	if logLevel.Level() != slog.LevelWarn {
+		t.Errorf("log level %v, want warn", logLevel.Level())
+	}
+	if rec := serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("write after the reload: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	for name, value := range map[string]string{"LOG_LEVEL": "loud", "SLOW_QUERY_THRESHOLD_MS": "-1", "MAINTENANCE_MODE": "maybe"} {
+		saved := os.Getenv(name)
+		t.Setenv(name, value)
+		if rec := serve(t, h, http.MethodPost, "/v1/admin/reload", nil, admin, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s=%s: status %d, want 400", name, value, rec.Code)
+		}
+		t.Setenv(name, saved)
+	}
+	if logLevel.Level() != slog.LevelWarn {
+		t.Errorf("failed reloads changed the log level to %v", logLevel.Level())
+	}
+
+	// A config file that no longer parses is reported, and nothing changes
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "log_level: error\n")
+	t.Setenv("LOG_LEVEL", "")
+	os.Unsetenv("LOG_LEVEL")
+	c, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedConfig = c
+	defer func() { loadedConfig = nil }()
+	serve(t, h, http.MethodPost, "/v1/admin/reload", nil, admin, &cfg)
+	if cfg.LogLevel != "error" {
+		t.Errorf("log level %q from the config file, want error", cfg.LogLevel)
+	}
+	writeConfig(t, path, "log_level: [\n")
+	if rec := serve(t, h, http.MethodPost, "/v1/admin/reload", nil, admin, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("broken config file: status %d, want 400", rec.Code)
+	}
+	os.Unsetenv("LOG_LEVEL")
+}
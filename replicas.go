@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// ========== Read Replicas ==========
+
+// readReplicas are read-only pools that album lookups and listings are
+// spread over, round-robin. A nil or empty set sends every read to the primary.
+type readReplicas struct {
+	dbs  []*pg.DB
+	next atomic.Uint64
+}
+
+// openReadReplicas connects to every server in DB_READ_REPLICAS, a
+// comma-separated list of host or host:port addresses. They use the primary's
+// user, password, database, schema and TLS settings, and its port when none is given.
+// DB_READ_HOST and DB_READ_PORT name a single replica instead, and are
+// ignored when DB_READ_REPLICAS is set.
+func openReadReplicas() *readReplicas {
+	v := os.Getenv("DB_READ_REPLICAS")
+	if v == "" {
+		v = os.Getenv("DB_READ_HOST")
+		if port := os.Getenv("DB_READ_PORT"); v != "" && port != "" {
+			v = net.JoinHostPort(v, port)
+		}
+	}
+	if v == "" {
+		return &readReplicas{}
+	}
+
+	primary := loadDBConfig()
+	replicas := &readReplicas{}
+	for _, addr := range strings.Split(v, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		cfg := primary
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			cfg.host, cfg.port = host, port
+		} else {
+			cfg.host = addr
+		}
+		replicas.dbs = append(replicas.dbs, dialDB(cfg, "read replica "+addr))
+	}
+	return replicas
+}
+
+// pick returns the next replica in turn, or nil when there are none
+func (rr *readReplicas) pick() DB {
+	if rr == nil || len(rr.dbs) == 0 {
+		return nil
+	}
+	n := rr.next.Add(1) - 1
+	return pgDB{db: rr.dbs[n%uint64(len(rr.dbs))]}
+}
+
+// AddQueryHook adds hook to every replica pool
+func (rr *readReplicas) AddQueryHook(hook pg.QueryHook) {
+	for _, db := range rr.dbs {
+		db.AddQueryHook(hook)
+	}
+}
+
+// Close closes every replica pool
+func (rr *readReplicas) Close() {
+	for _, db := range rr.dbs {
+		db.Close()
+	}
+}
+
+type primaryReadsKey struct{}
+
+// withPrimaryReads makes the reads made with ctx go to the primary. Use it
+// for reads that a write depends on, which must not see replication lag.
+func withPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// primaryReads reports whether ctx was marked by withPrimaryReads
+func primaryReads(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return primary
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// ========== Repository ==========
+
+var (
+	errAlbumNotFound  = errors.New("album not found")
+	errAlbumExists    = errors.New("album with this id already exists")
+	errArtistNotFound = errors.New("artist not found")
+	// errVersionConflict means the album changed since the client read it
+	errVersionConflict = errors.New("album was modified by someone else, fetch it again")
+
+	// errDryRun rolls back a transaction that otherwise succeeded
+	errDryRun = errors.New("dry run")
+)
+
+// AlbumRepository is the storage the HTTP handlers depend on.
+// Lookups and deletes of a missing album return errAlbumNotFound,
+// and creating a duplicate returns errAlbumExists.
+type AlbumRepository interface {
+	ListAlbums(ctx context.Context, opts AlbumListOptions) ([]Album, error)
+	// StreamAlbums calls fn for each album ListAlbums would return, without
+	// holding them all in memory. It stops at the first error from fn.
+	StreamAlbums(ctx context.Context, opts AlbumListOptions, fn func(Album) error) error
+	CountAlbums(ctx context.Context, opts AlbumListOptions) (int, error)
+	// RandomAlbum picks an album matching the filters of opts, or returns errAlbumNotFound
+	RandomAlbum(ctx context.Context, opts AlbumListOptions) (Album, error)
+	// TopAlbums returns the limit albums with the highest price, or with byReviews the most reviews
+	TopAlbums(ctx context.Context, byReviews bool, limit int) ([]Album, error)
+	// ArtistSummaries counts the albums of each artist, ordered by artist or, with byCount, by count
+	ArtistSummaries(ctx context.Context, byCount bool) ([]ArtistSummary, error)
+	// ArtistSummary is the ArtistSummaries entry of one artist, or errArtistNotFound
+	ArtistSummary(ctx context.Context, artist string) (ArtistSummary, error)
+	GetAlbumByID(ctx context.Context, id string, fields []string) (Album, error)
+	AlbumExists(ctx context.Context, id string) (bool, error)
+	CreateAlbum(ctx context.Context, album *Album) error
+	UpdateAlbum(ctx context.Context, album *Album) error
+	DeleteAlbum(ctx context.Context, id string) error
+	// DuplicateAlbum copies album id, with its tags, to newID and returns the
+	// copy. A non-empty title replaces the original's.
+	DuplicateAlbum(ctx context.Context, id, newID, title string) (Album, error)
+	// DeleteAlbums returns the ids of the albums it deleted; unknown ids are skipped
+	DeleteAlbums(ctx context.Context, ids []string) (deleted []string, err error)
+	// MultiplyArtistPrices multiplies the prices of the artist's albums and returns the ids it changed
+	MultiplyArtistPrices(ctx context.Context, artist string, multiplier float64) (updated []string, err error)
+	// ImportAlbums creates all albums or none. If ids are already taken it
+	// returns them with errAlbumExists. dryRun rolls back even on success.
+	ImportAlbums(ctx context.Context, albums []Album, dryRun bool) (existing map[string]bool, err error)
+	// AddAlbumTags links tags to the album, creating the ones that don't exist,
+	// and returns all of the album's tags
+	AddAlbumTags(ctx context.Context, id string, tags []string) ([]string, error)
+	// RemoveAlbumTag returns errTagNotFound when the album doesn't have tag
+	RemoveAlbumTag(ctx context.Context, id, tag string) error
+	ListReviews(ctx context.Context, albumID string, page pageParams) (reviews []Review, total int, err error)
+	CreateReview(ctx context.Context, review *Review) error
+	// DeleteReview returns errReviewNotFound when the album has no review id
+	DeleteReview(ctx context.Context, albumID string, id int64) error
+}
+
+// AlbumListOptions narrows a ListAlbums call. A nil Fields selects every column.
+// Artist is matched ignoring case and spacing (see normalizeArtist), TitleSearch is a substring of the title,
+// a non-nil IDs restricts the result to those albums, and Tag keeps albums with that tag.
+type AlbumListOptions struct {
+	Fields      []string
+	Page        pageParams
+	IDs         []string
+	Artist      string
+	TitleSearch string
+	Tag         string
+	MinPrice    *float64 // nil leaves the range open at that end
+	MaxPrice    *float64
+	Sort        string // one of albumSorts; "" orders by id alone
+}
+
+// sqlAlbumRepository is the AlbumRepository on a DB, PostgreSQL or SQLite.
+// Writes go to db, the primary; reads go to replicas when there are any.
+type sqlAlbumRepository struct {
+	db       DB
+	replicas *readReplicas
+}
+
+func newSQLAlbumRepository(db DB, replicas *readReplicas) *sqlAlbumRepository {
+	return &sqlAlbumRepository{db: db, replicas: replicas}
+}
+
+// reader picks the database for a read: the next replica, or the primary
+// when there are none or ctx asks for it (see withPrimaryReads). Retries call
+// it again, so a failed read is tried on another replica.
+func (r *sqlAlbumRepository) reader(ctx context.Context) DB {
+	if !primaryReads(ctx) {
+		if db := r.replicas.pick(); db != nil {
+			return db
+		}
+	}
+	return r.db
+}
+
+// albumWhere starts the WHERE clause of a query on albums, limited to the
+// request's tenant. Every album read, update and delete goes through it so
+// tenants never see each other's rows.
+func albumWhere(ctx context.Context) *whereClause {
+	return new(whereClause).and("tenant_id = ?", tenantFromContext(ctx))
+}
+
+// whereClause collects the conditions of a WHERE clause and their arguments
+type whereClause struct {
+	conditions []string
+	args       []interface{}
+}
+
+// and adds condition, whose ? are args
+func (w *whereClause) and(condition string, args ...interface{}) *whereClause {
+	w.conditions = append(w.conditions, condition)
+	w.args = append(w.args, args...)
+	return w
+}
+
+func (w *whereClause) String() string {
+	return " WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+// selectAlbumsSQL is the start of a query on albums, aliased album; the
+// review statistics and tag filters refer to it by that name
+const selectAlbumsSQL = "SELECT album.* FROM albums AS album"
+
+// Reads are retried on transient connection errors; writes are not, since a
+// write whose connection dropped may already have been applied.
+
+func (r *sqlAlbumRepository) ListAlbums(ctx context.Context, opts AlbumListOptions) ([]Album, error) {
+	var albums []Album
+	err := retryDB(ctx, dbRetryAttempts, func() error {
+		albums = nil
+		db := r.reader(ctx)
+		query, args := listQuery(ctx, db, opts)
+		if err := db.Select(ctx, &albums, query, args...); err != nil {
+			return err
+		}
+		if _, withTags := tagColumns(opts.Fields); withTags {
+			return loadAlbumTags(ctx, db, albums)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+// streamTagBatch is how many streamed albums share one query for their tags
+const streamTagBatch = 100
+
+func (r *sqlAlbumRepository) StreamAlbums(ctx context.Context, opts AlbumListOptions, fn func(Album) error) error {
+	db := r.reader(ctx)
+	query, args := listQuery(ctx, db, opts)
+	if _, withTags := tagColumns(opts.Fields); !withTags {
+		return db.Select(ctx, func(a *Album) error {
+			return fn(*a)
+		}, query, args...)
+	}
+
+	batch := make([]Album, 0, streamTagBatch)
+	flush := func() error {
+		if err := loadAlbumTags(ctx, db, batch); err != nil {
+			return err
+		}
+		for _, a := range batch {
+			if err := fn(a); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	err := db.Select(ctx, func(a *Album) error {
+		batch = append(batch, *a)
+		if len(batch) == streamTagBatch {
+			return flush()
+		}
+		return nil
+	}, query, args...)
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// listQuery builds the filtered, ordered and paginated query behind ListAlbums and StreamAlbums
+func listQuery(ctx context.Context, db DB, opts AlbumListOptions) (string, []interface{}) {
+	page := opts.Page
+
+	query := selectAlbumsSQL
+	if opts.Fields != nil {
+		// The columns are album fields, checked by parseFields
+		columns, _ := tagColumns(opts.Fields)
+		// The cursor is built from the last id, so fetch it even if the client didn't ask for it
+		if page.cursor && !isSelected(columns, "id") {
+			columns = append(columns, "id")
+		}
+		query = "SELECT " + strings.Join(columns, ", ") + " FROM albums AS album"
+	}
+
+	where := applyAlbumFilters(db.dialect(), albumWhere(ctx), opts)
+	if page.cursor && page.after != "" {
+		where.and("id > ?", page.after)
+	}
+	query += where.String()
+
+	// Always end the order with id, which is unique within a tenant, so every
+	// call and every page sees the albums in the same order
+	query += " ORDER BY "
+	if opts.Sort != "" {
+		query += albumSorts[opts.Sort] + ", "
+	}
+	query += "id ASC"
+
+	args := where.args
+	switch {
+	case page.cursor:
+		query += " LIMIT ?"
+		args = append(args, page.limit)
+	case page.limit > 0:
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, page.limit, page.offset)
+	}
+	return query, args
+}
+
+// CountAlbums counts the albums matching the filters in opts, ignoring pagination
+func (r *sqlAlbumRepository) CountAlbums(ctx context.Context, opts AlbumListOptions) (count int, err error) {
+	err = retryDB(ctx, dbRetryAttempts, func() error {
+		db := r.reader(ctx)
+		where := applyAlbumFilters(db.dialect(), albumWhere(ctx), opts)
+		return db.Select(ctx, &count, "SELECT count(*) FROM albums AS album"+where.String(), where.args...)
+	})
+	return count, err
+}
+
+// applyAlbumFilters adds the ids, artist, title, tag and price conditions of opts to where
+func applyAlbumFilters(d sqlDialect, where *whereClause, opts AlbumListOptions) *whereClause {
+	if opts.IDs != nil {
+		where.and("id IN (?)", inList(opts.IDs))
+	}
+
+	// These predicates match the indexes in migrations/011_artist_normalized.sql
+	// and the trigram index in 002_album_search_indexes.sql; keep them in sync.
+	if opts.Artist != "" {
+		where.and("artist_normalized = ?", normalizeArtist(opts.Artist))
+	}
+	if opts.TitleSearch != "" {
+		where.and("title "+d.ilike+` ? ESCAPE '\'`, "%"+escapeLike(opts.TitleSearch)+"%")
+	}
+	if opts.Tag != "" {
+		where.and(`EXISTS (
+			SELECT 1 FROM album_tags AS at
+			JOIN tags AS t ON t.id = at.tag_id
+			WHERE at.tenant_id = album.tenant_id AND at.album_id = album.id AND t.name = ?)`,
+			normalizeTag(opts.Tag))
+	}
+	// price is text since migrations/010_encrypt_album_prices.sql; these
+	// only work while it holds plain numbers (no ENCRYPTION_KEY)
+	if opts.MinPrice != nil {
+		where.and("CAST(price AS NUMERIC) >= ?", *opts.MinPrice)
+	}
+	if opts.MaxPrice != nil {
+		where.and("CAST(price AS NUMERIC) <= ?", *opts.MaxPrice)
+	}
+	return where
+}
+
+// escapeLike makes s match literally inside a LIKE/ILIKE pattern
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// GetAlbumByID also fills in the review statistics when it reads the whole album
+func (r *sqlAlbumRepository) GetAlbumByID(ctx context.Context, id string, fields []string) (Album, error) {
+	var row albumWithReviewStats
+	columns, withTags := tagColumns(fields)
+	err := retryDB(ctx, dbRetryAttempts, func() error {
+		db := r.reader(ctx)
+		query := selectAlbumsWithReviewStatsSQL
+		if columns != nil {
+			query = "SELECT " + strings.Join(columns, ", ") + " FROM albums AS album"
+		}
+		where := albumWhere(ctx).and("id = ?", id)
+		if err := db.Select(ctx, &row, query+where.String(), where.args...); err != nil || !withTags {
+			return err
+		}
+		one := []Album{row.Album}
+		if err := loadAlbumTags(ctx, db, one); err != nil {
+			return err
+		}
+		row.Tags = one[0].Tags
+		return nil
+	})
+	if err == sql.ErrNoRows {
+		return row.Album, errAlbumNotFound
+	}
+	if fields == nil {
+		row.AverageRating, row.ReviewCount = row.StatsAverage, &row.StatsCount
+	}
+	return row.Album, err
+}
+
+func (r *sqlAlbumRepository) AlbumExists(ctx context.Context, id string) (exists bool, err error) {
+	err = retryDB(ctx, dbRetryAttempts, func() error {
+		exists, err = albumExists(ctx, r.reader(ctx), id)
+		return err
+	})
+	return exists, err
+}
+
+// albumExists reports whether the request's tenant has album id
+func albumExists(ctx context.Context, db DB, id string) (exists bool, err error) {
+	where := albumWhere(ctx).and("id = ?", id)
+	err = db.Select(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM albums"+where.String()+")", where.args...)
+	return exists, err
+}
+
+// insertAlbumSQL inserts an album and reads back every column, so the
+// defaults such as created_at are filled in on the struct. It takes
+// albumValues.
+const insertAlbumSQL = `
+	INSERT INTO albums (tenant_id, id, title, artist, price, currency, cover_url, artist_normalized)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING *`
+
+// albumValues are the values of insertAlbumSQL for album, whose derived
+// columns it fills in first
+func albumValues(album *Album) ([]interface{}, error) {
+	if err := album.fillDerived(); err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		album.TenantID, album.ID, album.Title, album.Artist, album.StoredPrice,
+		album.Currency, nullString(album.CoverURL), album.ArtistNormalized,
+	}, nil
+}
+
+// insertAlbum stores album for the request's tenant with insertAlbumSQL,
+// returning errAlbumExists when its id is taken
+func insertAlbum(ctx context.Context, db DB, album *Album) error {
+	album.TenantID = tenantFromContext(ctx)
+	values, err := albumValues(album)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Insert(ctx, album, insertAlbumSQL, values...); err != nil {
+		if isUniqueViolation(err) {
+			return errAlbumExists
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateAlbum inserts album and reads back every column, so defaults such as
+// created_at are filled in on the struct. The audit entry is written in the
+// same transaction. A new album has no tags or reviews, whatever album says.
+func (r *sqlAlbumRepository) CreateAlbum(ctx context.Context, album *Album) error {
+	album.Tags = []string{}
+	album.AverageRating, album.ReviewCount = nil, nil
+	return r.db.RunInTransaction(ctx, func(tx DB) error {
+		if err := insertAlbum(ctx, tx, album); err != nil {
+			return err
+		}
+		return insertAudit(ctx, tx, newAlbumAudit(ctx, auditCreate, album.ID, nil, album))
+	})
+}
+
+// UpdateAlbum overwrites every column except created_at, bumps updated_at and
+// version and reads the stored row and tags back into album. album.Version must be the
+// version the change was based on, or the update fails with errVersionConflict.
+func (r *sqlAlbumRepository) UpdateAlbum(ctx context.Context, album *Album) error {
+	album.TenantID = tenantFromContext(ctx)
+	return r.db.RunInTransaction(ctx, func(tx DB) error {
+		var before Album
+		where := albumWhere(ctx).and("id = ?", album.ID)
+		err := tx.Select(ctx, &before, selectAlbumsSQL+where.String()+tx.dialect().forUpdate, where.args...)
+		if err == sql.ErrNoRows {
+			return errAlbumNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if before.Version != album.Version {
+			return errVersionConflict
+		}
+
+		if err := album.fillDerived(); err != nil {
+			return err
+		}
+		// The row is locked, so the version check above still holds, but
+		// keep it in the statement as well
+		where.and("version = ?", album.Version)
+		_, err = tx.Update(ctx, album, `
+			UPDATE albums
+			SET title = ?, artist = ?, price = ?, currency = ?, cover_url = ?, artist_normalized = ?,
+				updated_at = `+tx.dialect().now+`, version = version + 1`+
+			where.String()+`
+			RETURNING *`,
+			append([]interface{}{
+				album.Title, album.Artist, album.StoredPrice, album.Currency,
+				nullString(album.CoverURL), album.ArtistNormalized,
+			}, where.args...)...)
+		if err != nil {
+			return err
+		}
+		// Tags aren't changed by an update, only reported as they are
+		one := []Album{*album}
+		if err := loadAlbumTags(ctx, tx, one); err != nil {
+			return err
+		}
+		album.Tags = one[0].Tags
+		return insertAudit(ctx, tx, newAlbumAudit(ctx, auditUpdate, album.ID, &before, album))
+	})
+}
+
+func (r *sqlAlbumRepository) DeleteAlbum(ctx context.Context, id string) error {
+	return r.db.RunInTransaction(ctx, func(tx DB) error {
+		var before Album
+		where := albumWhere(ctx).and("id = ?", id)
+		n, err := tx.Delete(ctx, &before, "DELETE FROM albums"+where.String()+" RETURNING *", where.args...)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errAlbumNotFound
+		}
+		return insertAudit(ctx, tx, newAlbumAudit(ctx, auditDelete, id, &before, nil))
+	})
+}
+
+func (r *sqlAlbumRepository) DeleteAlbums(ctx context.Context, ids []string) ([]string, error) {
+	var deleted []Album
+	err := r.db.RunInTransaction(ctx, func(tx DB) error {
+		where := albumWhere(ctx).and("id IN (?)", inList(ids))
+		if _, err := tx.Delete(ctx, &deleted, "DELETE FROM albums"+where.String()+" RETURNING *", where.args...); err != nil {
+			return err
+		}
+
+		entries := make([]*AuditLog, len(deleted))
+		for i := range deleted {
+			entries[i] = newAlbumAudit(ctx, auditDelete, deleted[i].ID, &deleted[i], nil)
+		}
+		return insertAudit(ctx, tx, entries...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	deletedIDs := make([]string, len(deleted))
+	for i := range deleted {
+		deletedIDs[i] = deleted[i].ID
+	}
+	return deletedIDs, nil
+}
+
+func (r *sqlAlbumRepository) ImportAlbums(ctx context.Context, albums []Album, dryRun bool) (map[string]bool, error) {
+	if len(albums) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(albums))
+	for i := range albums {
+		albums[i].Tags = []string{}
+		ids[i] = albums[i].ID
+	}
+
+	var existing map[string]bool
+	err := r.db.RunInTransaction(ctx, func(tx DB) error {
+		var taken []string
+		where := albumWhere(ctx).and("id IN (?)", inList(ids))
+		if err := tx.Select(ctx, &taken, "SELECT id FROM albums"+where.String(), where.args...); err != nil {
+			return err
+		}
+		if len(taken) > 0 {
+			existing = make(map[string]bool, len(taken))
+			for _, id := range taken {
+				existing[id] = true
+			}
+			return errAlbumExists
+		}
+
+		entries := make([]*AuditLog, len(albums))
+		for i := range albums {
+			if err := insertAlbum(ctx, tx, &albums[i]); err != nil {
+				return err
+			}
+			entries[i] = newAlbumAudit(ctx, auditCreate, albums[i].ID, nil, &albums[i])
+		}
+		if err := insertAudit(ctx, tx, entries...); err != nil {
+			return err
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		return nil, nil
+	}
+	return existing, err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ========== Database Retries ==========
+
+const (
+	dbRetryAttempts  = 3
+	dbRetryBaseDelay = 100 * time.Millisecond
+)
+
+// isTransientDBError reports whether err looks like a dropped or refused
+// connection that may well succeed when tried again. Errors from Postgres
+// itself (bad SQL, constraint violations) are not transient.
+func isTransientDBError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryDB runs fn up to attempts times, waiting 100ms, 200ms, 400ms, ...
+// between tries, as long as it fails with a transient error. It gives up early
+// when ctx is done and returns the last error from fn.
+func retryDB(ctx context.Context, attempts int, fn func() error) error {
+	delay := dbRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !isTransientDBError(err) {
+			return err
+		}
+
+		log.Printf("Database call failed (attempt %d of %d), retrying in %s: %v", attempt, attempts, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
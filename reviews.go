@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== Album Reviews ==========
+
+const (
+	maxReviewAuthorLen = 100
+	maxReviewBodyLen   = 5000
+)
+
+// errReviewNotFound means the album has no review with that id
+var errReviewNotFound = errors.New("review not found")
+
+// Review is a row of reviews: a rating from 1 to 5 of one album, with an optional text
+type Review struct {
+	tableName struct{} `pg:"reviews"`
+
+	ID         int64     `json:"id" pg:"id,pk"`
+	TenantID   string    `json:"-" pg:"tenant_id"`
+	AlbumID    string    `json:"album_id" pg:"album_id"`
+	AuthorName string    `json:"author_name" pg:"author_name"`
+	Rating     int       `json:"rating" pg:"rating"`
+	Body       string    `json:"body" pg:"body,use_zero"`
+	CreatedAt  time.Time `json:"created_at" pg:"created_at,default:now()"`
+}
+
+func validateReview(rv *Review) ValidationErrors {
+	var errs ValidationErrors
+	rv.AuthorName = strings.TrimSpace(rv.AuthorName)
+	if rv.AuthorName == "" {
+		errs.Add("author_name", "author_name is required")
+	} else if len(rv.AuthorName) > maxReviewAuthorLen {
+		errs.Add("author_name", "author_name must be at most "+strconv.Itoa(maxReviewAuthorLen)+" characters")
+	}
+	if rv.Rating < 1 || rv.Rating > 5 {
+		errs.Add("rating", "rating must be between 1 and 5")
+	}
+	if len(rv.Body) > maxReviewBodyLen {
+		errs.Add("body", "body must be at most "+strconv.Itoa(maxReviewBodyLen)+" characters")
+	}
+	return errs
+}
+
+// albumWithReviewStats is an album row together with the average and count
+// of its reviews, which GetAlbumByID reads in the same query
+type albumWithReviewStats struct {
+	tableName struct{} `pg:"albums,alias:album"`
+
+	Album
+	StatsAverage *float64 `pg:"average_rating"`
+	StatsCount   int      `pg:"review_count"`
+}
+
+// selectAlbumsWithReviewStatsSQL selects every album column plus the review
+// statistics of the album, computed from its reviews
+const selectAlbumsWithReviewStatsSQL = `
+	SELECT album.*,
+		(SELECT ROUND(AVG(rv.rating), 2) FROM reviews AS rv
+			WHERE rv.tenant_id = album.tenant_id AND rv.album_id = album.id) AS average_rating,
+		(SELECT COUNT(*) FROM reviews AS rv
+			WHERE rv.tenant_id = album.tenant_id AND rv.album_id = album.id) AS review_count
+	FROM albums AS album`
+
+// withReviewStats adds the review statistics GetAlbumByID filled in on album
+// to body. average_rating is null while there are no reviews.
+func withReviewStats(body map[string]interface{}, album Album) map[string]interface{} {
+	if album.ReviewCount != nil {
+		body["average_rating"] = album.AverageRating
+		body["review_count"] = *album.ReviewCount
+	}
+	return body
+}
+
+// ListReviews returns a page of the album's reviews, newest first, and how
+// many it has in all
+func (r *sqlAlbumRepository) ListReviews(ctx context.Context, albumID string, page pageParams) (reviews []Review, total int, err error) {
+	err = retryDB(ctx, dbRetryAttempts, func() error {
+		db := r.reader(ctx)
+		exists, err := albumExists(ctx, db, albumID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errAlbumNotFound
+		}
+
+		reviews = nil
+		tenant := tenantFromContext(ctx)
+		err = db.Select(ctx, &reviews, `
+			SELECT * FROM reviews
+			WHERE tenant_id = ? AND album_id = ?
+			ORDER BY id DESC
+			LIMIT ? OFFSET ?`,
+			tenant, albumID, page.limit, page.offset)
+		if err != nil {
+			return err
+		}
+		return db.Select(ctx, &total, `SELECT count(*) FROM reviews WHERE tenant_id = ? AND album_id = ?`, tenant, albumID)
+	})
+	return reviews, total, err
+}
+
+// CreateReview stores review for review.AlbumID and reads back its id and created_at
+func (r *sqlAlbumRepository) CreateReview(ctx context.Context, review *Review) error {
+	review.TenantID = tenantFromContext(ctx)
+	return r.db.RunInTransaction(ctx, func(tx DB) error {
+		exists, err := albumExists(ctx, tx, review.AlbumID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errAlbumNotFound
+		}
+		_, err = tx.Insert(ctx, review, `
+			INSERT INTO reviews (tenant_id, album_id, author_name, rating, body)
+			VALUES (?, ?, ?, ?, ?)
+			RETURNING *`,
+			review.TenantID, review.AlbumID, review.AuthorName, review.Rating, review.Body)
+		return err
+	})
+}
+
+func (r *sqlAlbumRepository) DeleteReview(ctx context.Context, albumID string, id int64) error {
+	n, err := r.db.Delete(ctx, nil, `DELETE FROM reviews WHERE tenant_id = ? AND album_id = ? AND id = ?`,
+		tenantFromContext(ctx), albumID, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errReviewNotFound
+	}
+	return nil
+}
+
+// parseReviewPage reads ?limit= and ?offset= for GET /albums/{id}/reviews.
+// Reviews always come a page at a time, defaultPageSize unless limit says otherwise.
+func parseReviewPage(r *http.Request) (pageParams, *FieldError) {
+	if r.URL.Query().Has("after") {
+		return pageParams{}, &FieldError{Field: "after", Message: "reviews are paginated with limit and offset"}
+	}
+	page, fe := parsePage(r)
+	if fe != nil {
+		return page, fe
+	}
+	if page.limit == 0 {
+		page.limit = defaultPageSize
+	}
+	return page, nil
+}
+
+// sendReviewError answers for a failed ListReviews, CreateReview or DeleteReview
+func sendReviewError(w http.ResponseWriter, err error) {
+	switch err {
+	case errAlbumNotFound, errReviewNotFound:
+		sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+	default:
+		sendStorageError(w, err)
+	}
+}
+
+// listReviews serves GET /albums/{id}/reviews?limit=&offset=
+func (s *Server) listReviews(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+	page, fe := parseReviewPage(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+
+	reviews, total, err := s.albums.ListReviews(r.Context(), id, page)
+	if err != nil {
+		sendReviewError(w, err)
+		return
+	}
+	if reviews == nil {
+		reviews = []Review{}
+	}
+	setPageHeaders(w, r, page, total, "")
+	sendJSON(w, http.StatusOK, reviews)
+}
+
+// postReview serves POST /albums/{id}/reviews
+func (s *Server) postReview(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+	// The id, album and time of a review are the server's to set
+	var body struct {
+		AuthorName string `json:"author_name"`
+		Rating     int    `json:"rating"`
+		Body       string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "Invalid request body"}, http.StatusBadRequest)
+		return
+	}
+	review := Review{AlbumID: id, AuthorName: body.AuthorName, Rating: body.Rating, Body: body.Body}
+	if errs := validateReview(&review); errs != nil {
+		sendError(w, errs.APIError(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := s.albums.CreateReview(r.Context(), &review); err != nil {
+		sendReviewError(w, err)
+		return
+	}
+	sendJSON(w, http.StatusCreated, review)
+}
+
+// deleteReview serves DELETE /albums/{id}/reviews/{reviewID}
+func (s *Server) deleteReview(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+	reviewID, err := strconv.ParseInt(chi.URLParam(r, "reviewID"), 10, 64)
+	if err != nil || reviewID <= 0 {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid review id", Field: "review_id"}, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.albums.DeleteReview(r.Context(), id, reviewID); err != nil {
+		sendReviewError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestReviews(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+
+	var ids []int64
+	for i, author := range []string{"Ann", "Bob", "Cy"} {
+		var review Review
+		body := map[string]interface{}{"author_name": author, "rating": i + 3, "body": "Great"}
+		if rec := serve(t, h, http.MethodPost, "/v1/albums/1/reviews", body, nil, &review); rec.Code != http.StatusCreated {
+			t.Fatalf("POST review: status %d: %s", rec.Code, rec.Body)
+		}
+		if review.ID == 0 || review.AlbumID != "1" || review.CreatedAt.IsZero() {
+			t.Errorf("created %+v", review)
+		}
+		ids = append(ids, review.ID)
+	}
+
+	var reviews []Review
+	rec := serve(t, h, http.MethodGet, "/v1/albums/1/reviews?limit=2", nil, nil, &reviews)
+	if rec.Code != http.StatusOK || len(reviews) != 2 || rec.Header().Get("X-Total-Count") != "3" {
+		t.Fatalf("first page: status %d, %d reviews of %s", rec.Code, len(reviews), rec.Header().Get("X-Total-Count"))
+	}
+	serve(t, h, http.MethodGet, "/v1/albums/1/reviews?limit=2&offset=2", nil, nil, &reviews)
+	if len(reviews) != 1 {
+		t.Errorf("second page: %d reviews, want 1", len(reviews))
+	}
+
+	path := "/v1/albums/1/reviews/" + strconv.FormatInt(ids[0], 10)
+	if rec := serve(t, h, http.MethodDelete, path, nil, nil, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE: status %d", rec.Code)
+	}
+	if rec := serve(t, h, http.MethodDelete, path, nil, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE again: status %d, want 404", rec.Code)
+	}
+	serve(t, h, http.MethodGet, "/v1/albums/1/reviews", nil, nil, &reviews)
+	if len(reviews) != 2 {
+		t.Errorf("%d reviews after the delete, want 2", len(reviews))
+	}
+}
+
+func TestReviewsRejectBadRequests(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	valid := map[string]interface{}{"author_name": "Ann", "rating": 5}
+
+	for _, tt := range []struct {
+		method, path string
+		body         interface{}
+		status       int
+	}{
+		{http.MethodGet, "/v1/albums/42/reviews", nil, http.StatusNotFound},
+		{http.MethodGet, "/v1/albums/1/reviews?after=", nil, http.StatusBadRequest},
+		{http.MethodGet, "/v1/albums/1/reviews?offset=-1", nil, http.StatusBadRequest},
+		{http.MethodPost, "/v1/albums/42/reviews", valid, http.StatusNotFound},
+		{http.MethodPost, "/v1/albums/1/reviews", map[string]interface{}{"author_name": " ", "rating": 6}, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/v1/albums/1/reviews", "five stars", http.StatusBadRequest},
+		{http.MethodDelete, "/v1/albums/1/reviews/x", nil, http.StatusBadRequest},
+		{http.MethodDelete, "/v1/albums/1/reviews/9", nil, http.StatusNotFound},
+	} {
+		if rec := serve(t, h, tt.method, tt.path, tt.body, nil, nil); rec.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestReviewsStorageError(t *testing.T) {
+	srv, repo := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+	repo.err = errDatabaseUnavailable
+
+	for _, tt := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodGet, "/v1/albums/1/reviews", nil},
+		{http.MethodPost, "/v1/albums/1/reviews", map[string]interface{}{"author_name": "Ann", "rating": 5}},
+		{http.MethodDelete, "/v1/albums/1/reviews/1", nil},
+	} {
+		if rec := serve(t, h, tt.method, tt.path, tt.body, nil, nil); rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: status %d, want 503", tt.method, tt.path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// ========== Sample Data ==========
+
+// sampleAlbums is the development data inserted by the seed command
+var sampleAlbums = []Album{
+	{ID: "blue-train", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99, Currency: "USD"},
+	{ID: "giant-steps", Title: "Giant Steps", Artist: "John Coltrane", Price: 63.99, Currency: "USD"},
+	{ID: "jeru", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99, Currency: "USD"},
+	{ID: "sarah-vaughan", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99, Currency: "USD"},
+	{ID: "kind-of-blue", Title: "Kind of Blue", Artist: "Miles Davis", Price: 24.5, Currency: "EUR"},
+	{ID: "time-out", Title: "Time Out", Artist: "Dave Brubeck", Price: 2200, Currency: "JPY"},
+}
+
+// seedAlbums creates sampleAlbums for tenant through albums, whichever
+// database it uses, leaving albums that already exist alone, and returns how
+// many were added. With ALBUM_ID_FORMAT=uuid the IDs are UUIDs derived from
+// the sample names, so seeding twice still adds nothing the second time.
+func seedAlbums(ctx context.Context, albums AlbumRepository, tenant string) (int, error) {
+	ctx = withTenant(ctx, tenant)
+	added := 0
+	for _, a := range sampleAlbums {
+		if albumIDsAreUUIDs {
+			a.ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte("sample-album:"+a.ID)).String()
+		}
+		err := albums.CreateAlbum(ctx, &a)
+		if err == errAlbumExists {
+			continue
+		}
+		if err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ========== Graceful Shutdown ==========
+
+// defaultShutdownTimeout applies when SHUTDOWN_TIMEOUT is not set
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdownTimeout reads how long to drain in-flight requests from SHUTDOWN_TIMEOUT,
+// a Go duration such as 30s or 2m
+func shutdownTimeout() time.Duration {
+	v := os.Getenv("SHUTDOWN_TIMEOUT")
+	if v == "" {
+		return defaultShutdownTimeout
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("SHUTDOWN_TIMEOUT must be a positive duration such as 30s, got %q", v)
+	}
+	return d
+}
+
+// inFlightRequests counts requests currently being served
+type inFlightRequests struct {
+	n atomic.Int64
+}
+
+func (c *inFlightRequests) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.n.Add(1)
+		defer c.n.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *inFlightRequests) count() int64 {
+	return c.n.Load()
+}
+
+// serveUntilSignal runs server until SIGINT or SIGTERM, then stops accepting
+// connections and waits up to drain for in-flight requests before closing the
+// rest forcibly
+func serveUntilSignal(server *http.Server, inFlight *inFlightRequests, drain time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for %d in-flight requests", drain, inFlight.count())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Drain timeout reached with %d requests still running, closing connections", inFlight.count())
+		if err := server.Close(); err != nil {
+			return err
+		}
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("Server stopped")
+	return nil
+}
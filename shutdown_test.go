@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownTimeout(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT", "")
+	if d := shutdownTimeout(); d != defaultShutdownTimeout {
+		t.Errorf("default %v", d)
+	}
+	t.Setenv("SHUTDOWN_TIMEOUT", "2m")
+	if d := shutdownTimeout(); d != 2*time.Minute {
+		t.Errorf("SHUTDOWN_TIMEOUT=2m: %v", d)
+	}
+}
+
+// On SIGTERM the server waits for the request in flight, then stops
+func TestServeUntilSignal(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	inFlight := &inFlightRequests{}
+	started := make(chan struct{})
+	server := &http.Server{Addr: addr, Handler: inFlight.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))}
+	errc := make(chan error, 1)
+	go func() { errc <- serveUntilSignal(server, inFlight, 5*time.Second) }()
+
+	var resp *http.Response
+	respc := make(chan error, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get("http://" + addr); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		respc <- err
+	}()
+	<-started
+	if n := inFlight.count(); n != 1 {
+		t.Errorf("%d requests in flight, want 1", n)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	if err := <-respc; err != nil {
+		t.Fatalf("in-flight request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("in-flight request: status %d", resp.StatusCode)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("serveUntilSignal = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server still running after SIGTERM")
+	}
+}
+
+func TestServeUntilSignalListenError(t *testing.T) {
+	server := &http.Server{Addr: "127.0.0.1:-1"}
+	if err := serveUntilSignal(server, &inFlightRequests{}, time.Second); err == nil {
+		t.Error("no error for an address that can't be listened on")
+	}
+}
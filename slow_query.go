@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// ========== Slow Query Log ==========
+
+// defaultSlowQueryThreshold applies when SLOW_QUERY_THRESHOLD_MS is not set
+const defaultSlowQueryThreshold = 100 * time.Millisecond
+
+// slowQueryThreshold reads SLOW_QUERY_THRESHOLD_MS, or SLOW_QUERY_MS when that isn't set
+func slowQueryThreshold() (time.Duration, error) {
+	name := "SLOW_QUERY_THRESHOLD_MS"
+	v := os.Getenv(name)
+	if v == "" {
+		name = "SLOW_QUERY_MS"
+		v = os.Getenv(name)
+	}
+	if v == "" {
+		return defaultSlowQueryThreshold, nil
+	}
+
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", name, v)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// slowQueryHook is a pg.QueryHook that logs every query slower than its
+// threshold, and every query that was cancelled or timed out however long it
+// ran. Queries cut off because the client disconnected are logged at info
+// level only. The threshold can be changed while queries are running.
+type slowQueryHook struct {
+	threshold atomic.Int64 // time.Duration
+}
+
+var _ pg.QueryHook = (*slowQueryHook)(nil)
+
+func (h *slowQueryHook) setThreshold(d time.Duration) {
+	h.threshold.Store(int64(d))
+}
+
+func (h *slowQueryHook) BeforeQuery(ctx context.Context, evt *pg.QueryEvent) (context.Context, error) {
+	// go-pg sets evt.StartTime itself before calling the hooks
+	return ctx, nil
+}
+
+func (h *slowQueryHook) AfterQuery(ctx context.Context, evt *pg.QueryEvent) error {
+	elapsed := time.Since(evt.StartTime)
+	msg := "slow query"
+	switch {
+	case evt.Err == nil:
+	case clientDisconnected(ctx):
+		// Expected whenever a client gives up, so not a warning
+		logger.InfoContext(ctx, "client disconnected", "duration", elapsed, "error", evt.Err)
+		return nil
+	case errors.Is(evt.Err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		// The server is shutting down, or a background job was stopped
+		msg = "query cancelled"
+	case isTimeout(evt.Err):
+		// The request deadline passed, or DB_STATEMENT_TIMEOUT_MS stopped it
+		msg = "query timed out"
+	}
+	if msg == "slow query" && elapsed < time.Duration(h.threshold.Load()) {
+		return nil
+	}
+
+	query, err := evt.FormattedQuery()
+	if err != nil {
+		query, _ = evt.UnformattedQuery()
+	}
+	logger.WarnContext(ctx, msg,
+		"duration", elapsed,
+		"query", string(query),
+		"params", evt.Params,
+		"error", evt.Err,
+	)
+	return nil
+}
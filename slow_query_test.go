@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestSlowQueryThreshold(t *testing.T) {
+	for _, tt := range []struct {
+		threshold, legacy string
+		want              time.Duration
+		wantErr           bool
+	}{
+		{"", "", defaultSlowQueryThreshold, false},
+		{"250", "", 250 * time.Millisecond, false},
+		{"", "50", 50 * time.Millisecond, false},
+		{"250", "50", 250 * time.Millisecond, false},
+		{"0", "", 0, true},
+		{"", "fast", 0, true},
+	} {
+		t.Setenv("SLOW_QUERY_THRESHOLD_MS", tt.threshold)
+		t.Setenv("SLOW_QUERY_MS", tt.legacy)
+		got, err := slowQueryThreshold()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("SLOW_QUERY_THRESHOLD_MS=%q SLOW_QUERY_MS=%q: %v, %v", tt.threshold, tt.legacy, got, err)
+		}
+	}
+}
+
+func TestSlowQueryHook(t *testing.T) {
+	var out bytes.Buffer
+	saved := logger
+	logger = slog.New(slog.NewTextHandler(&out, nil))
+	defer func() { logger = saved }()
+
+	h := &slowQueryHook{}
+	h.setThreshold(100 * time.Millisecond)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tt := range []struct {
+		name    string
+		ctx     context.Context
+		elapsed time.Duration
+		err     error
+		want    string
+	}{
+		{"fast", context.Background(), time.Millisecond, nil, ""},
+		{"slow", context.Background(), time.Second, nil, "level=WARN msg=\"slow query\""},
+		{"cancelled", cancelled, time.Millisecond, context.Canceled, "msg=\"query cancelled\""},
+		{"timed out", context.Background(), time.Millisecond, context.DeadlineExceeded, "msg=\"query timed out\""},
+		{"failed", context.Background(), time.Millisecond, errors.New("syntax error"), ""},
+	} {
+		out.Reset()
+		evt := &pg.QueryEvent{StartTime: time.Now().Add(-tt.elapsed), Query: "SELECT 1", Err: tt.err}
+		ctx, _ := h.BeforeQuery(tt.ctx, evt)
+		if err := h.AfterQuery(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+		if tt.want == "" && out.Len() != 0 || !strings.Contains(out.String(), tt.want) {
+			t.Errorf("%s: logged %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
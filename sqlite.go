@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ========== SQLite Backend ==========
+
+// defaultSQLitePath is the database file DB_DRIVER=sqlite3 uses without SQLITE_PATH
+const defaultSQLitePath = "albums.db"
+
+// sqliteTimeFormat is how timestamps are stored: as text in UTC, which
+// sorts and compares in time order. sqliteDialect.now writes the same.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+// sqliteSchema is migrations/ for SQLite: the same tables, with JSON in text
+// columns, and triggers that keep album_events as record_album_event does.
+// It runs every time the database is opened, so there is nothing to migrate.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS albums (
+	tenant_id TEXT NOT NULL DEFAULT 'default',
+	id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	price TEXT NOT NULL,
+	currency TEXT NOT NULL DEFAULT 'USD',
+	cover_url TEXT,
+	artist_normalized TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	version INTEGER NOT NULL DEFAULT 1,
+	PRIMARY KEY (tenant_id, id)
+);
+CREATE INDEX IF NOT EXISTS albums_artist_normalized_idx ON albums (tenant_id, artist_normalized);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id INTEGER PRIMARY KEY,
+	tenant_id TEXT NOT NULL DEFAULT 'default',
+	table_name TEXT NOT NULL,
+	record_id TEXT NOT NULL,
+	action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+	old_value TEXT,
+	new_value TEXT,
+	performed_by TEXT NOT NULL,
+	performed_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS audit_logs_record_idx ON audit_logs (tenant_id, table_name, record_id, performed_at DESC);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	tenant_id TEXT NOT NULL DEFAULT 'default',
+	key TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	response TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	PRIMARY KEY (tenant_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY,
+	tenant_id TEXT NOT NULL DEFAULT 'default',
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS webhooks_tenant_idx ON webhooks (tenant_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY,
+	tenant_id TEXT NOT NULL DEFAULT 'default',
+	webhook_id INTEGER NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_retry_at TIMESTAMP,
+	status TEXT NOT NULL CHECK (status IN ('pending', 'failed', 'delivered', 'dead')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_retry_at) WHERE status IN ('pending', 'failed');
+
+CREATE TABLE IF NOT EXISTS album_events (
+	id INTEGER PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	album_id TEXT NOT NULL,
+	event_type TEXT NOT NULL CHECK (event_type IN ('AlbumCreated', 'AlbumUpdated', 'AlbumDeleted')),
+	payload TEXT NOT NULL,
+	occurred_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS album_events_album_idx ON album_events (tenant_id, album_id, occurred_at, id);
+
+CREATE TRIGGER IF NOT EXISTS albums_record_insert AFTER INSERT ON albums BEGIN
+	INSERT INTO album_events (tenant_id, album_id, event_type, payload)
+	VALUES (NEW.tenant_id, NEW.id, 'AlbumCreated', json_object(
+		'tenant_id', NEW.tenant_id, 'id', NEW.id, 'title', NEW.title, 'artist', NEW.artist,
+		'price', NEW.price, 'currency', NEW.currency, 'cover_url', NEW.cover_url,
+		'artist_normalized', NEW.artist_normalized,
+		'created_at', strftime('%Y-%m-%dT%H:%M:%fZ', NEW.created_at),
+		'updated_at', strftime('%Y-%m-%dT%H:%M:%fZ', NEW.updated_at),
+		'version', NEW.version));
+END;
+CREATE TRIGGER IF NOT EXISTS albums_record_update AFTER UPDATE ON albums BEGIN
+	INSERT INTO album_events (tenant_id, album_id, event_type, payload)
+	VALUES (NEW.tenant_id, NEW.id, 'AlbumUpdated', json_object(
+		'tenant_id', NEW.tenant_id, 'id', NEW.id, 'title', NEW.title, 'artist', NEW.artist,
+		'price', NEW.price, 'currency', NEW.currency, 'cover_url', NEW.cover_url,
+		'artist_normalized', NEW.artist_normalized,
+		'created_at', strftime('%Y-%m-%dT%H:%M:%fZ', NEW.created_at),
+		'updated_at', strftime('%Y-%m-%dT%H:%M:%fZ', NEW.updated_at),
+		'version', NEW.version));
+END;
+CREATE TRIGGER IF NOT EXISTS albums_record_delete AFTER DELETE ON albums BEGIN
+	INSERT INTO album_events (tenant_id, album_id, event_type, payload)
+	VALUES (OLD.tenant_id, OLD.id, 'AlbumDeleted', json_object(
+		'tenant_id', OLD.tenant_id, 'id', OLD.id, 'title', OLD.title, 'artist', OLD.artist,
+		'price', OLD.price, 'currency', OLD.currency, 'cover_url', OLD.cover_url,
+		'artist_normalized', OLD.artist_normalized,
+		'created_at', strftime('%Y-%m-%dT%H:%M:%fZ', OLD.created_at),
+		'updated_at', strftime('%Y-%m-%dT%H:%M:%fZ', OLD.updated_at),
+		'version', OLD.version));
+END;
+
+CREATE TABLE IF NOT EXISTS playlists (
+	id INTEGER PRIMARY KEY,
+	tenant_id TEXT NOT NULL DEFAULT 'default',
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS playlists_tenant_idx ON playlists (tenant_id);
+
+CREATE TABLE IF NOT EXISTS playlist_albums (
+	playlist_id INTEGER NOT NULL REFERENCES playlists (id) ON DELETE CASCADE,
+	tenant_id TEXT NOT NULL,
+	album_id TEXT NOT NULL,
+	position INTEGER NOT NULL CHECK (position > 0),
+	PRIMARY KEY (playlist_id, album_id),
+	FOREIGN KEY (tenant_id, album_id) REFERENCES albums (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS playlist_albums_position_idx ON playlist_albums (playlist_id, position);
+CREATE INDEX IF NOT EXISTS playlist_albums_album_idx ON playlist_albums (tenant_id, album_id);
+
+CREATE TABLE IF NOT EXISTS tags (
+	id INTEGER PRIMARY KEY,
+	tenant_id TEXT NOT NULL DEFAULT 'default',
+	name TEXT NOT NULL,
+	UNIQUE (tenant_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS album_tags (
+	tenant_id TEXT NOT NULL,
+	album_id TEXT NOT NULL,
+	tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+	PRIMARY KEY (tenant_id, album_id, tag_id),
+	FOREIGN KEY (tenant_id, album_id) REFERENCES albums (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS album_tags_tag_idx ON album_tags (tag_id);
+
+CREATE TABLE IF NOT EXISTS reviews (
+	id INTEGER PRIMARY KEY,
+	tenant_id TEXT NOT NULL DEFAULT 'default',
+	album_id TEXT NOT NULL,
+	author_name TEXT NOT NULL,
+	rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+	body TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	FOREIGN KEY (tenant_id, album_id) REFERENCES albums (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS reviews_album_idx ON reviews (tenant_id, album_id, id);
+`
+
+// sqliteDB is the DB of DB_DRIVER=sqlite3, a database opened with
+// mattn/go-sqlite3, which needs cgo. It keeps a single connection: SQLite
+// takes one writer at a time anyway, and it keeps SQLITE_PATH=:memory: one
+// database.
+type sqliteDB struct {
+	db *sql.DB
+}
+
+// connectSQLite opens SQLITE_PATH, creating the file and its tables if needed
+func connectSQLite() sqliteDB {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = defaultSQLitePath
+	}
+	log.Printf("Opening SQLite database %q", path)
+	db, err := openSQLite(path)
+	if err != nil {
+		log.Fatalf("Failed to open SQLite database %q: %v", path, err)
+	}
+	return db
+}
+
+// openSQLite opens the database file at path and creates the tables that
+// don't exist yet. Foreign keys are enforced, so deleting an album deletes
+// its tags and reviews, and transactions take the write lock when they begin.
+func openSQLite(path string) (sqliteDB, error) {
+	params := url.Values{
+		"_foreign_keys": {"on"},
+		"_busy_timeout": {"5000"},
+		"_txlock":       {"immediate"},
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return sqliteDB{}, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetMaxIdleConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return sqliteDB{}, err
+	}
+	return sqliteDB{db: db}, nil
+}
+
+func (d sqliteDB) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sqliteSelect(ctx, d.db, dest, query, args)
+}
+
+func (d sqliteDB) Insert(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return sqliteExec(ctx, d.db, dest, query, args)
+}
+
+func (d sqliteDB) Update(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return sqliteExec(ctx, d.db, dest, query, args)
+}
+
+func (d sqliteDB) Delete(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return sqliteExec(ctx, d.db, dest, query, args)
+}
+
+func (d sqliteDB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+// RunInTransaction runs fn in a transaction, committing it when fn returns
+// nil and rolling it back otherwise, as go-pg's does
+func (d sqliteDB) RunInTransaction(ctx context.Context, fn func(tx DB) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(sqliteTx{tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (sqliteDB) dialect() sqlDialect {
+	return sqliteDialect
+}
+
+// PoolStats reports the database/sql pool in go-pg's terms, for /healthz
+func (d sqliteDB) PoolStats() *pg.PoolStats {
+	stats := d.db.Stats()
+	return &pg.PoolStats{TotalConns: uint32(stats.OpenConnections), IdleConns: uint32(stats.Idle)}
+}
+
+func (d sqliteDB) Close() error {
+	return d.db.Close()
+}
+
+// sqliteTx is a sqliteDB transaction
+type sqliteTx struct {
+	tx *sql.Tx
+}
+
+func (t sqliteTx) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sqliteSelect(ctx, t.tx, dest, query, args)
+}
+
+func (t sqliteTx) Insert(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return sqliteExec(ctx, t.tx, dest, query, args)
+}
+
+func (t sqliteTx) Update(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return sqliteExec(ctx, t.tx, dest, query, args)
+}
+
+func (t sqliteTx) Delete(ctx context.Context, dest interface{}, query string, args ...interface{}) (int, error) {
+	return sqliteExec(ctx, t.tx, dest, query, args)
+}
+
+func (t sqliteTx) Ping(ctx context.Context) error {
+	_, err := t.tx.ExecContext(ctx, "SELECT 1")
+	return err
+}
+
+func (t sqliteTx) RunInTransaction(ctx context.Context, fn func(tx DB) error) error {
+	return fn(t)
+}
+
+func (sqliteTx) dialect() sqlDialect {
+	return sqliteDialect
+}
+
+// sqlQuerier is what *sql.DB and *sql.Tx have in common
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// sqliteSelect runs a query into dest, failing with sql.ErrNoRows when dest
+// takes a single row and there is none
+func sqliteSelect(ctx context.Context, q sqlQuerier, dest interface{}, query string, args []interface{}) error {
+	n, err := sqliteExec(ctx, q, dest, query, args)
+	if err != nil {
+		return err
+	}
+	if v := reflect.ValueOf(dest); n == 0 && v.Kind() == reflect.Ptr && v.Elem().Kind() != reflect.Slice {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// sqliteExec runs a statement, reading the rows it returns into dest when
+// there is one, and returns how many rows it changed or returned
+func sqliteExec(ctx context.Context, q sqlQuerier, dest interface{}, query string, args []interface{}) (int, error) {
+	query, args, err := sqliteArgs(query, args)
+	if err != nil {
+		return 0, err
+	}
+	if dest == nil {
+		res, err := q.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		return int(n), err
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return sqliteScan(ctx, rows, dest)
+}
+
+// sqliteArgs converts args into values SQLite stores, as go-pg would write
+// them for PostgreSQL, and expands the ? of each inList into one per item
+func sqliteArgs(query string, args []interface{}) (string, []interface{}, error) {
+	var (
+		b     strings.Builder
+		out   = make([]interface{}, 0, len(args))
+		n     int
+		quote bool
+	)
+	for _, c := range query {
+		if c == '\'' {
+			quote = !quote
+		}
+		if c != '?' || quote {
+			b.WriteRune(c)
+			continue
+		}
+		if n >= len(args) {
+			return "", nil, fmt.Errorf("sqlite: query has more placeholders than the %d arguments", len(args))
+		}
+		arg := args[n]
+		n++
+
+		list, ok := arg.(inList)
+		if !ok {
+			v, err := sqliteValue(arg)
+			if err != nil {
+				return "", nil, err
+			}
+			b.WriteRune('?')
+			out = append(out, v)
+			continue
+		}
+		if len(list) == 0 {
+			b.WriteString("NULL") // IN (NULL) matches nothing, where IN () is an error
+		}
+		for i, item := range list {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteRune('?')
+			out = append(out, item)
+		}
+	}
+	if n != len(args) {
+		return "", nil, fmt.Errorf("sqlite: query has %d placeholders for %d arguments", n, len(args))
+	}
+	return b.String(), out, nil
+}
+
+// sqliteValue is arg as it is bound: times as sqliteTimeFormat text in UTC,
+// and maps, structs and slices, json.RawMessage and stringArray among them,
+// as JSON text
+func sqliteValue(arg interface{}) (interface{}, error) {
+	switch v := arg.(type) {
+	case nil, string, []byte, bool, int, int64, float64:
+		return v, nil
+	case time.Time:
+		return v.UTC().Format(sqliteTimeFormat), nil
+	case json.RawMessage:
+		return string(v), nil
+	case driver.Valuer:
+		return v, nil
+	}
+
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return sqliteValue(v.Elem().Interface())
+	case reflect.Map, reflect.Struct, reflect.Slice:
+		if v.Kind() != reflect.Struct && v.IsNil() {
+			return nil, nil
+		}
+		b, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	return arg, nil
+}
+
+// sqliteScan reads rows into dest, which is any of the dests DB takes, and
+// returns how many there were. A func(*T) error dest is called once the rows
+// are read and closed, since the single connection can't run the queries fn
+// may make while they are open.
+func sqliteScan(ctx context.Context, rows *sql.Rows, dest interface{}) (int, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	v := reflect.ValueOf(dest)
+	var fn reflect.Value
+	switch {
+	case v.Kind() == reflect.Func:
+		typ := v.Type()
+		if typ.NumIn() != 1 || typ.In(0).Kind() != reflect.Ptr || typ.NumOut() != 1 || typ.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+			return 0, fmt.Errorf("sqlite: can't scan into %s, expected func(*T) error", typ)
+		}
+		fn, v = v, reflect.New(reflect.SliceOf(typ.In(0).Elem()))
+	case v.Kind() != reflect.Ptr || v.IsNil():
+		return 0, fmt.Errorf("sqlite: can't scan into %T", dest)
+	}
+
+	// Many rows go into a slice, one into dest itself
+	target := v.Elem()
+	many := target.Kind() == reflect.Slice && target.Type() != reflect.TypeOf(json.RawMessage(nil)) && target.Type() != reflect.TypeOf([]byte(nil))
+	if many {
+		target.Set(target.Slice(0, 0))
+	}
+
+	n := 0
+	for rows.Next() {
+		row := target
+		if many {
+			row = reflect.New(target.Type().Elem()).Elem()
+		}
+		if err := scanSQLiteRow(ctx, rows, columns, row); err != nil {
+			return n, err
+		}
+		if many {
+			target.Set(reflect.Append(target, row))
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	rows.Close()
+
+	if fn.IsValid() {
+		for i := 0; i < target.Len(); i++ {
+			if err, _ := fn.Call([]reflect.Value{target.Index(i).Addr()})[0].Interface().(error); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// scanSQLiteRow scans the current row into dest: into the fields of a struct
+// by their pg names, or, for any other type, from the only column
+func scanSQLiteRow(ctx context.Context, rows *sql.Rows, columns []string, dest reflect.Value) error {
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return err
+	}
+
+	if dest.Kind() != reflect.Struct || dest.Type() == reflect.TypeOf(time.Time{}) {
+		if len(columns) != 1 {
+			return fmt.Errorf("sqlite: can't scan %d columns into %s", len(columns), dest.Type())
+		}
+		return setSQLiteValue(dest, values[0], "")
+	}
+
+	table := orm.GetTable(dest.Type())
+	for i, column := range columns {
+		field, err := table.GetField(column)
+		if err != nil {
+			return err
+		}
+		if err := setSQLiteValue(field.Value(dest), values[i], field.SQLType); err != nil {
+			return fmt.Errorf("sqlite: column %s: %w", column, err)
+		}
+	}
+	if hook, ok := dest.Addr().Interface().(orm.AfterScanHook); ok {
+		return hook.AfterScan(ctx)
+	}
+	return nil
+}
+
+// setSQLiteValue stores src, a value read from SQLite, in dest. sqlType is
+// the pg type of the field, which says whether an interface{} holds JSON.
+func setSQLiteValue(dest reflect.Value, src interface{}, sqlType string) error {
+	if src == nil {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+	if dest.Kind() == reflect.Ptr {
+		elem := reflect.New(dest.Type().Elem())
+		if err := setSQLiteValue(elem.Elem(), src, sqlType); err != nil {
+			return err
+		}
+		dest.Set(elem)
+		return nil
+	}
+
+	text, isText := src.(string)
+	if b, ok := src.([]byte); ok {
+		text, isText = string(b), true
+	}
+	switch {
+	case dest.Type() == reflect.TypeOf(time.Time{}):
+		switch s := src.(type) {
+		case time.Time:
+			dest.Set(reflect.ValueOf(s.UTC()))
+			return nil
+		case string:
+			t, err := parseSQLiteTime(s)
+			if err != nil {
+				return err
+			}
+			dest.Set(reflect.ValueOf(t))
+			return nil
+		}
+	case dest.Type() == reflect.TypeOf(json.RawMessage(nil)):
+		if isText {
+			dest.SetBytes([]byte(text))
+			return nil
+		}
+	case dest.Kind() == reflect.Map, dest.Kind() == reflect.Struct, dest.Kind() == reflect.Slice,
+		dest.Kind() == reflect.Interface && (sqlType == "jsonb" || sqlType == "json"):
+		if isText {
+			return json.Unmarshal([]byte(text), dest.Addr().Interface())
+		}
+	case dest.Kind() == reflect.Interface:
+		dest.Set(reflect.ValueOf(src))
+		return nil
+	case dest.Kind() == reflect.String:
+		if isText {
+			dest.SetString(text)
+			return nil
+		}
+		dest.SetString(fmt.Sprint(src))
+		return nil
+	case dest.Kind() == reflect.Bool:
+		if i, ok := src.(int64); ok {
+			dest.SetBool(i != 0)
+			return nil
+		}
+	case dest.CanInt():
+		switch s := src.(type) {
+		case int64:
+			dest.SetInt(s)
+			return nil
+		case float64:
+			dest.SetInt(int64(s))
+			return nil
+		}
+	case dest.CanFloat():
+		switch s := src.(type) {
+		case int64:
+			dest.SetFloat(float64(s))
+			return nil
+		case float64:
+			dest.SetFloat(s)
+			return nil
+		case string:
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return err
+			}
+			dest.SetFloat(f)
+			return nil
+		}
+	}
+	return fmt.Errorf("can't scan %T into %s", src, dest.Type())
+}
+
+// parseSQLiteTime reads a timestamp SQLite returned as text, in any of the
+// layouts mattn/go-sqlite3 reads from timestamp columns
+func parseSQLiteTime(s string) (time.Time, error) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse %q as a time", s)
+}
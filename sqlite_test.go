@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newSQLiteTestRouter serves the API from a fresh SQLite database, set up as
+// runServer does with DB_DRIVER=sqlite3, with one webhook worker and without
+// the background workers
+func newSQLiteTestRouter(t *testing.T) (http.Handler, sqliteDB) {
+	t.Helper()
+	db, err := openSQLite(filepath.Join(t.TempDir(), "albums.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	webhooks := newSQLWebhookRepository(db)
+	dispatcher := newWebhookDispatcher(webhooks)
+	dispatcher.Start(1)
+	t.Cleanup(func() { dispatcher.Stop(context.Background()) })
+	albums := newBreakerAlbumRepository(newSQLAlbumRepository(db, nil), loadBreakerSettings(), nil)
+
+	srv := &Server{
+		albums:      newWebhookAlbumRepository(albums, dispatcher),
+		audit:       newSQLAuditLogRepository(db),
+		history:     newSQLAlbumEventRepository(db),
+		topAlbums:   newAlbumListCache(topAlbumsCacheSize, topAlbumsCacheTTL),
+		idempotency: newSQLIdempotencyRepository(db),
+		stats:       newStatsWorker(db),
+		events:      newAlbumEventBroker(db),
+		webhooks:    webhooks,
+		playlists:   newSQLPlaylistRepository(db),
+	}
+	config := &runtimeConfig{maintenance: &maintenanceMode{}, slowQueries: &slowQueryHook{}}
+	h, err := newRouter(srv, db, config, &inFlightRequests{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, db
+}
+
+// serve sends a request to h with a JSON body, unless body is nil, and
+// decodes the JSON response into out, unless out is nil
+func serve(t *testing.T, h http.Handler, method, path string, body interface{}, header http.Header, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil {
+		decodeJSON(t, rec, out)
+	}
+	return rec
+}
+
+func TestSQLiteAlbumRoundTrip(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+
+	in := map[string]interface{}{
+		"id": "1", "title": "Blue Train", "artist": "John Coltrane", "price": 56.99, "currency": "usd",
+		"created_at": "2000-01-01T00:00:00Z", "version": 9,
+	}
+	var created Album
+	if rec := serve(t, h, http.MethodPost, "/v1/albums", in, nil, &created); rec.Code != http.StatusCreated {
+		t.Fatalf("POST: status %d: %s", rec.Code, rec.Body)
+	}
+	if created.Currency != "USD" || created.Version != 1 || created.CreatedAt.Year() == 2000 || !created.UpdatedAt.Equal(created.CreatedAt) {
+		t.Errorf("created %+v, want USD at version 1, created now", created)
+	}
+	if rec := serve(t, h, http.MethodPost, "/v1/albums", in, nil, nil); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate POST: status %d, want 409", rec.Code)
+	}
+
+	var got Album
+	rec := serve(t, h, http.MethodGet, "/v1/albums/1", nil, nil, &got)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET: status %d", rec.Code)
+	}
+	if got.Title != "Blue Train" || got.Price != 56.99 || !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("GET returned %+v, want the created album", got)
+	}
+	if got.ReviewCount == nil || *got.ReviewCount != 0 || got.AverageRating != nil {
+		t.Errorf("review stats %v/%v, want 0 reviews and no average", got.ReviewCount, got.AverageRating)
+	}
+
+	var patched Album
+	header := http.Header{"If-Match": {rec.Header().Get("ETag")}, "Content-Type": {mergePatchContentType}}
+	if rec := serve(t, h, http.MethodPatch, "/v1/albums/1", map[string]interface{}{"price": 39.5}, header, &patched); rec.Code != http.StatusOK {
+		t.Fatalf("PATCH: status %d: %s", rec.Code, rec.Body)
+	}
+	if patched.Price != 39.5 || patched.Version != 2 || !patched.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("PATCH returned %+v, want price 39.5 at version 2", patched)
+	}
+	if rec := serve(t, h, http.MethodPatch, "/v1/albums/1", map[string]interface{}{"price": 1}, header, nil); rec.Code != http.StatusConflict {
+		t.Errorf("stale PATCH: status %d, want 409", rec.Code)
+	}
+
+	if rec := serve(t, h, http.MethodDelete, "/v1/albums/1", nil, nil, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d", rec.Code)
+	}
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/1", nil, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: status %d, want 404", rec.Code)
+	}
+}
+
+func TestSQLiteListFilters(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	for _, a := range []Album{
+		{ID: "1", Title: "Cheap", Artist: "Miles Davis", Price: 9.99},
+		{ID: "2", Title: "Dear", Artist: "  MILES  davis ", Price: 99.99},
+		{ID: "3", Title: "100% Middle", Artist: "Miles Davis", Price: 49.5},
+		{ID: "4", Title: "Other", Artist: "John Coltrane", Price: 20},
+	} {
+		if rec := serve(t, h, http.MethodPost, "/v1/albums", a, nil, nil); rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s: status %d: %s", a.ID, rec.Code, rec.Body)
+		}
+	}
+
+	var albums []Album
+	query := url.Values{"artist": {"miles davis"}, "max_price": {"50"}, "limit": {"1"}}
+	rec := serve(t, h, http.MethodGet, "/v1/albums?"+query.Encode(), nil, nil, &albums)
+	if got := rec.Header().Get("X-Total-Count"); got != "2" {
+		t.Errorf("X-Total-Count = %q, want 2", got)
+	}
+	if len(albums) != 1 || albums[0].ID != "1" {
+		t.Errorf("first page %+v, want album 1", albums)
+	}
+
+	// % is matched literally, not as a wildcard
+	serve(t, h, http.MethodGet, "/v1/albums?q=0%25+m", nil, nil, &albums)
+	if len(albums) != 1 || albums[0].ID != "3" {
+		t.Errorf("?q= returned %+v, want album 3", albums)
+	}
+
+	var page artistAlbums
+	serve(t, h, http.MethodGet, "/v1/artists/Miles%20Davis/albums?sort=-price", nil, nil, &page)
+	if page.Artist.Count != 3 || page.Total != 3 || len(page.Albums) != 3 {
+		t.Fatalf("got %+v, want all 3 albums of the artist", page)
+	}
+	if page.Albums[0].ID != "2" || page.Albums[2].ID != "1" {
+		t.Errorf("albums not ordered by price, highest first: %+v", page.Albums)
+	}
+	if page.Artist.AvgPrice == nil || *page.Artist.AvgPrice != 53.16 {
+		t.Errorf("avg_price %v, want 53.16", page.Artist.AvgPrice)
+	}
+
+	var summaries []ArtistSummary
+	serve(t, h, http.MethodGet, "/v1/albums/by-artist?sort=count", nil, nil, &summaries)
+	if len(summaries) != 2 || summaries[0].Count != 3 || summaries[1].Artist != "John Coltrane" {
+		t.Errorf("by-artist returned %+v", summaries)
+	}
+}
+
+func TestSQLiteTagsAndReviews(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Kind of Blue", Artist: "Miles Davis", Price: 29.99}, nil, nil)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "2", Title: "Giant Steps", Artist: "John Coltrane", Price: 24.99}, nil, nil)
+
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	if rec := serve(t, h, http.MethodPost, "/v1/albums/1/tags", map[string][]string{"tags": {"Modal", "jazz"}}, nil, &tags); rec.Code != http.StatusOK {
+		t.Fatalf("POST tags: status %d: %s", rec.Code, rec.Body)
+	}
+	serve(t, h, http.MethodPost, "/v1/albums/1/tags", map[string][]string{"tags": {"jazz"}}, nil, &tags)
+	if len(tags.Tags) != 2 || tags.Tags[0] != "jazz" || tags.Tags[1] != "modal" {
+		t.Errorf("album tags %v, want [jazz modal]", tags.Tags)
+	}
+	var tagged []Album
+	serve(t, h, http.MethodGet, "/v1/albums?tag=modal", nil, nil, &tagged)
+	if len(tagged) != 1 || tagged[0].ID != "1" || tagged[0].Version != 2 {
+		t.Errorf("?tag= returned %+v, want album 1 at version 2", tagged)
+	}
+	if rec := serve(t, h, http.MethodDelete, "/v1/albums/1/tags/modal", nil, nil, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE tag: status %d", rec.Code)
+	}
+	if rec := serve(t, h, http.MethodDelete, "/v1/albums/1/tags/modal", nil, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE removed tag: status %d, want 404", rec.Code)
+	}
+
+	for _, rating := range []int{5, 4} {
+		review := map[string]interface{}{"author_name": "Ann", "rating": rating}
+		if rec := serve(t, h, http.MethodPost, "/v1/albums/1/reviews", review, nil, nil); rec.Code != http.StatusCreated {
+			t.Fatalf("POST review: status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	var got Album
+	serve(t, h, http.MethodGet, "/v1/albums/1", nil, nil, &got)
+	if got.ReviewCount == nil || *got.ReviewCount != 2 || got.AverageRating == nil || *got.AverageRating != 4.5 {
+		t.Errorf("review stats %v/%v, want 2 reviews averaging 4.5", got.ReviewCount, got.AverageRating)
+	}
+	var top []Album
+	serve(t, h, http.MethodGet, "/v1/albums/top?by=reviews", nil, nil, &top)
+	if len(top) != 2 || top[0].ID != "1" {
+		t.Errorf("top by reviews %+v, want album 1 first", top)
+	}
+
+	// Deleting the album deletes its reviews and tag links with it
+	serve(t, h, http.MethodDelete, "/v1/albums/1", nil, nil, nil)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Kind of Blue", Artist: "Miles Davis", Price: 29.99}, nil, nil)
+	serve(t, h, http.MethodGet, "/v1/albums/1", nil, nil, &got)
+	if len(got.Tags) != 0 || *got.ReviewCount != 0 {
+		t.Errorf("recreated album has tags %v and %d reviews", got.Tags, *got.ReviewCount)
+	}
+}
+
+func TestSQLiteImportAndDuplicate(t *testing.T) {
+	_, db := newSQLiteTestRouter(t)
+	repo := newSQLAlbumRepository(db, nil)
+	ctx := context.Background()
+
+	albums := []Album{{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, {ID: "2", Title: "Time Out", Artist: "Dave Brubeck", Price: 22}}
+	if _, err := repo.ImportAlbums(ctx, albums, true); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if n, _ := repo.CountAlbums(ctx, AlbumListOptions{}); n != 0 {
+		t.Fatalf("dry run stored %d albums", n)
+	}
+	if _, err := repo.ImportAlbums(ctx, albums, false); err != nil {
+		t.Fatal(err)
+	}
+	existing, err := repo.ImportAlbums(ctx, []Album{{ID: "2"}, {ID: "3", Title: "New", Artist: "New", Price: 1}}, false)
+	if err != errAlbumExists || len(existing) != 1 || !existing["2"] {
+		t.Fatalf("importing a taken id: %v, %v; want errAlbumExists for 2", existing, err)
+	}
+	if exists, _ := repo.AlbumExists(ctx, "3"); exists {
+		t.Error("a failed import stored album 3")
+	}
+
+	if _, err := repo.AddAlbumTags(ctx, "1", []string{"cool"}); err != nil {
+		t.Fatal(err)
+	}
+	dup, err := repo.DuplicateAlbum(ctx, "1", "1-copy", "Jeru (copy)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dup.Title != "Jeru (copy)" || dup.Price != 17.99 || dup.Version != 1 || len(dup.Tags) != 1 || dup.Tags[0] != "cool" {
+		t.Errorf("duplicate %+v, want the renamed album at version 1 with its tag", dup)
+	}
+	if _, err := repo.DuplicateAlbum(ctx, "1", "2", ""); err != errAlbumExists {
+		t.Errorf("duplicating onto a taken id: %v, want errAlbumExists", err)
+	}
+
+	updated, err := repo.MultiplyArtistPrices(ctx, "gerry mulligan", 1.1)
+	if err != nil || len(updated) != 2 {
+		t.Fatalf("MultiplyArtistPrices: %v, %v; want the album and its copy", updated, err)
+	}
+	got, _ := repo.GetAlbumByID(ctx, "1", nil)
+	if got.Price != 19.79 || got.Version != 3 {
+		t.Errorf("after multiplying: price %v at version %d, want 19.79 at 3", got.Price, got.Version)
+	}
+
+	deleted, err := repo.DeleteAlbums(ctx, []string{"1", "1-copy", "missing"})
+	if err != nil || len(deleted) != 2 {
+		t.Errorf("DeleteAlbums: %v, %v; want the 2 that exist", deleted, err)
+	}
+}
+
+func TestSQLiteIdempotency(t *testing.T) {
+	_, db := newSQLiteTestRouter(t)
+	repo := newSQLIdempotencyRepository(db)
+	ctx := context.Background()
+
+	if rec, err := repo.FindIdempotencyRecord(ctx, "key"); rec != nil || err != nil {
+		t.Fatalf("unknown key: %+v, %v", rec, err)
+	}
+	first := &IdempotencyRecord{Key: "key", RequestHash: "a", Status: http.StatusCreated, Response: json.RawMessage(`{"id":"1"}`)}
+	if err := repo.SaveIdempotencyRecord(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second := &IdempotencyRecord{Key: "key", RequestHash: "b", Status: http.StatusConflict, Response: json.RawMessage(`{}`)}
+	if err := repo.SaveIdempotencyRecord(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := repo.FindIdempotencyRecord(ctx, "key")
+	if err != nil || rec == nil {
+		t.Fatalf("saved key: %+v, %v", rec, err)
+	}
+	if rec.RequestHash != "a" || rec.Status != http.StatusCreated || string(rec.Response) != `{"id":"1"}` {
+		t.Errorf("found %+v, want the first response", rec)
+	}
+	if rec, _ := repo.FindIdempotencyRecord(withTenant(ctx, "other"), "key"); rec != nil {
+		t.Error("another tenant found the key")
+	}
+}
+
+func TestSQLiteHistoryAndAudit(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	patch := http.Header{"Content-Type": {mergePatchContentType}}
+	for _, body := range []map[string]interface{}{{"price": 19.99, "version": 1}, {"title": "Jeru!", "version": 2}} {
+		if rec := serve(t, h, http.MethodPatch, "/v1/albums/1", body, patch, nil); rec.Code != http.StatusOK {
+			t.Fatalf("PATCH: status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	serve(t, h, http.MethodDelete, "/v1/albums/1", nil, nil, nil)
+
+	var events []AlbumHistoryEvent
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/1/history", nil, nil, &events); rec.Code != http.StatusOK {
+		t.Fatalf("GET history: status %d: %s", rec.Code, rec.Body)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	if len(events) != 4 || types[0] != "AlbumCreated" || types[3] != "AlbumDeleted" {
+		t.Fatalf("history %v, want created, 2 updates and deleted", types)
+	}
+	if a := events[1].Album; a == nil || a.Price != 19.99 || a.Version != 2 || a.UpdatedAt.IsZero() {
+		t.Errorf("first update's album %+v, want 19.99 at version 2", a)
+	}
+	if changed := events[2].ChangedFields; len(changed) != 1 || changed[0] != "title" {
+		t.Errorf("second update changed %v, want [title]", changed)
+	}
+
+	var changes []PriceChange
+	serve(t, h, http.MethodGet, "/v1/albums/1/price-history", nil, nil, &changes)
+	if len(changes) != 1 || changes[0].From != 17.99 || changes[0].To != 19.99 || changes[0].ChangedAt.IsZero() {
+		t.Errorf("price history %+v, want 17.99 to 19.99", changes)
+	}
+
+	var logs []AuditLog
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	if rec := serve(t, h, http.MethodGet, "/v1/admin/audit-logs?record_id=1", nil, admin, &logs); rec.Code != http.StatusOK {
+		t.Fatalf("GET audit logs: status %d: %s", rec.Code, rec.Body)
+	}
+	if len(logs) != 4 || logs[0].Action != auditDelete || logs[0].NewValue != nil || logs[3].Action != auditCreate {
+		t.Fatalf("audit logs %+v, want 4 entries, newest first", logs)
+	}
+	if old, ok := logs[0].OldValue.(map[string]interface{}); !ok || old["title"] != "Jeru!" || old["price"] != 19.99 {
+		t.Errorf("deleted album logged as %v", logs[0].OldValue)
+	}
+}
+
+func TestSQLitePlaylists(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	for _, id := range []string{"1", "2", "3"} {
+		serve(t, h, http.MethodPost, "/v1/albums", Album{ID: id, Title: "Album " + id, Artist: "Artist", Price: 10}, nil, nil)
+	}
+
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	var playlist playlistWithAlbums
+	if rec := serve(t, h, http.MethodPost, "/v1/playlists", map[string]string{"name": "Late night"}, admin, &playlist); rec.Code != http.StatusCreated {
+		t.Fatalf("POST playlist: status %d: %s", rec.Code, rec.Body)
+	}
+	path := "/v1/playlists/" + strconv.FormatInt(playlist.ID, 10)
+	for _, add := range []map[string]interface{}{{"album_id": "1"}, {"album_id": "2"}, {"album_id": "3", "position": 1}} {
+		if rec := serve(t, h, http.MethodPost, path+"/albums", add, admin, &playlist); rec.Code != http.StatusCreated {
+			t.Fatalf("POST %v: status %d: %s", add, rec.Code, rec.Body)
+		}
+	}
+	if rec := serve(t, h, http.MethodPost, path+"/albums", map[string]string{"album_id": "1"}, admin, nil); rec.Code != http.StatusConflict {
+		t.Errorf("adding album 1 again: status %d, want 409", rec.Code)
+	}
+	var apiErr APIError
+	if rec := serve(t, h, http.MethodPost, path+"/albums", map[string]string{"album_id": "9"}, admin, &apiErr); rec.Code != http.StatusUnprocessableEntity || apiErr.Field != "album_id" {
+		t.Errorf("adding a missing album: status %d, field %q; want 422 on album_id", rec.Code, apiErr.Field)
+	}
+
+	if rec := serve(t, h, http.MethodDelete, path+"/albums/1", nil, admin, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE album 1: status %d: %s", rec.Code, rec.Body)
+	}
+	serve(t, h, http.MethodGet, path, nil, admin, &playlist)
+	if len(playlist.Albums) != 2 || playlist.Albums[0].ID != "3" || playlist.Albums[1].ID != "2" || playlist.Albums[1].Position != 2 {
+		t.Errorf("playlist %+v, want 3 then 2", playlist.Albums)
+	}
+
+	if rec := serve(t, h, http.MethodPut, path, map[string]string{"name": "Early morning"}, admin, &playlist); rec.Code != http.StatusOK || playlist.Name != "Early morning" {
+		t.Errorf("PUT: status %d, name %q", rec.Code, playlist.Name)
+	}
+	if rec := serve(t, h, http.MethodDelete, path, nil, admin, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE: status %d", rec.Code)
+	}
+	if rec := serve(t, h, http.MethodGet, path, nil, admin, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: status %d, want 404", rec.Code)
+	}
+}
+
+func TestSQLiteWebhooks(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, db := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+
+	hook := Webhook{URL: "https://example.com/hook", Secret: "0123456789abcdef", Events: []string{webhookAlbumCreated}}
+	if rec := serve(t, h, http.MethodPost, "/v1/admin/webhooks", hook, admin, &hook); rec.Code != http.StatusCreated {
+		t.Fatalf("POST webhook: status %d: %s", rec.Code, rec.Body)
+	}
+	path := "/v1/admin/webhooks/" + strconv.FormatInt(hook.ID, 10)
+	hook.Secret, hook.Events = "fedcba9876543210", []string{webhookAlbumCreated, webhookAlbumDeleted}
+	if rec := serve(t, h, http.MethodPut, path, hook, admin, nil); rec.Code != http.StatusOK {
+		t.Fatalf("PUT webhook: status %d: %s", rec.Code, rec.Body)
+	}
+
+	repo := newSQLWebhookRepository(db)
+	ctx := context.Background()
+	subscribed, err := repo.WebhooksFor(ctx, webhookAlbumDeleted)
+	if err != nil || len(subscribed) != 1 || subscribed[0].Secret != "fedcba9876543210" {
+		t.Fatalf("WebhooksFor: %+v, %v; want the updated webhook", subscribed, err)
+	}
+
+	delivery := &WebhookDelivery{WebhookID: hook.ID, Event: webhookAlbumDeleted, Payload: json.RawMessage(`{"id":"1"}`), Status: deliveryPending}
+	if err := repo.CreateDelivery(ctx, delivery); err != nil || delivery.ID == 0 {
+		t.Fatalf("CreateDelivery: %v", err)
+	}
+	delivery.recordAttempt(errors.New("status 500"), time.Now().Add(-time.Hour-webhookRetryBase))
+	if err := repo.UpdateDelivery(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := repo.ClaimDueDeliveries(ctx, webhookRetryBatch, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].LastError != "status 500" || string(claimed[0].Payload) != `{"id":"1"}` {
+		t.Fatalf("ClaimDueDeliveries: %+v, %v; want the failed delivery", claimed, err)
+	}
+	if again, _ := repo.ClaimDueDeliveries(ctx, webhookRetryBatch, time.Minute); len(again) != 0 {
+		t.Errorf("a claimed delivery was claimed again: %+v", again)
+	}
+
+	var deliveries []WebhookDelivery
+	serve(t, h, http.MethodGet, path+"/deliveries?status=failed", nil, admin, &deliveries)
+	if len(deliveries) != 1 || deliveries[0].Attempts != 1 {
+		t.Errorf("deliveries %+v, want the one failed attempt", deliveries)
+	}
+
+	if rec := serve(t, h, http.MethodDelete, path, nil, admin, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE: status %d", rec.Code)
+	}
+	if rec := serve(t, h, http.MethodGet, path, nil, admin, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: status %d, want 404", rec.Code)
+	}
+}
+
+func TestSQLiteStatsAndEvents(t *testing.T) {
+	_, db := newSQLiteTestRouter(t)
+	repo := newSQLAlbumRepository(db, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := newAlbumEventBroker(db)
+	broker.Start(ctx)
+	events, unsubscribe := broker.Subscribe(defaultTenant)
+	defer unsubscribe()
+
+	for _, a := range []Album{
+		{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99, Currency: "USD"},
+		{ID: "2", Title: "Time Out", Artist: "Dave Brubeck", Price: 22.01, Currency: "USD"},
+		{ID: "3", Title: "Kind of Blue", Artist: "Miles Davis", Price: 2200, Currency: "JPY"},
+	} {
+		if err := repo.CreateAlbum(ctx, &a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.DeleteAlbum(ctx, "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []albumEvent{{Type: "created", ID: "1"}, {Type: "created", ID: "2"}, {Type: "created", ID: "3"}, {Type: "deleted", ID: "3"}} {
+		select {
+		case e := <-events:
+			if e.Type != want.Type || e.ID != want.ID || e.Album == nil || e.Album.ID != want.ID {
+				t.Errorf("event %+v, want %s %s with its album", e, want.Type, want.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event for album %s", want.Type, want.ID)
+		}
+	}
+	cancel()
+	broker.Wait()
+
+	stats := newStatsWorker(db)
+	stats.refresh(context.Background())
+	got, ok := stats.stats(defaultTenant)
+	if !ok || got.TotalAlbums != 2 {
+		t.Fatalf("stats %+v, want 2 albums", got)
+	}
+	if usd := got.ByCurrency["USD"]; usd.Count != 2 || usd.MinPrice != 17.99 || usd.MaxPrice != 22.01 || usd.AvgPrice != 20 {
+		t.Errorf("USD stats %+v", usd)
+	}
+}
+
+func TestSQLiteHealth(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	var health healthResponse
+	if rec := serve(t, h, http.MethodGet, "/healthz", nil, nil, &health); rec.Code != http.StatusOK || health.Status != "ok" {
+		t.Errorf("GET /healthz: status %d, %+v", rec.Code, health)
+	}
+}
+
+func TestSQLiteScan(t *testing.T) {
+	_, db := newSQLiteTestRouter(t)
+	ctx := context.Background()
+
+	var row struct {
+		When    time.Time              `pg:"when"`
+		Text    *string                `pg:"text"`
+		Missing *string                `pg:"missing"`
+		Flag    bool                   `pg:"flag"`
+		Count   int                    `pg:"count"`
+		Ratio   float64                `pg:"ratio"`
+		Price   float64                `pg:"price"`
+		Label   string                 `pg:"label"`
+		Doc     map[string]interface{} `pg:"doc"`
+		Raw     json.RawMessage        `pg:"raw"`
+		Any     interface{}            `pg:"any"`
+	}
+	err := db.Select(ctx, &row, `SELECT '2024-01-02 03:04:05.5' AS "when", 'x' AS text, NULL AS missing,
+		1 AS flag, 2.0 AS count, 3 AS ratio, '4.25' AS price, 5 AS label, '{"a":1}' AS doc, '[1]' AS raw, 6 AS "any"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !row.When.Equal(time.Date(2024, 1, 2, 3, 4, 5, 5e8, time.UTC)) || *row.Text != "x" || row.Missing != nil || !row.Flag ||
+		row.Count != 2 || row.Ratio != 3 || row.Price != 4.25 || row.Label != "5" || row.Doc["a"] != 1.0 || string(row.Raw) != "[1]" || row.Any != int64(6) {
+		t.Errorf("scanned %+v", row)
+	}
+	if err := db.Select(ctx, &row, `SELECT 'yesterday' AS "when"`); err == nil {
+		t.Error("scanned 'yesterday' as a time")
+	}
+	if err := db.Select(ctx, &row, `SELECT 'lots' AS price`); err == nil {
+		t.Error("scanned 'lots' as a float")
+	}
+
+	// A transaction pings, and runs nested transactions in itself
+	err = db.RunInTransaction(ctx, func(tx DB) error {
+		if err := tx.Ping(ctx); err != nil {
+			return err
+		}
+		return tx.RunInTransaction(ctx, func(inner DB) error {
+			_, err := inner.Insert(ctx, nil, `INSERT INTO playlists (tenant_id, name) VALUES (?, ?)`, defaultTenant, "Late night")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(ctx); err != nil {
+		t.Error(err)
+	}
+}
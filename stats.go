@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ========== Album Statistics ==========
+
+// defaultStatsRefreshInterval applies when STATS_REFRESH_INTERVAL_SECONDS is not set
+const defaultStatsRefreshInterval = time.Minute
+
+// priceStats summarises the prices of albums in one currency
+type priceStats struct {
+	Count    int     `json:"count"`
+	MinPrice float64 `json:"min_price"`
+	MaxPrice float64 `json:"max_price"`
+	AvgPrice float64 `json:"avg_price"`
+}
+
+// albumStats is the body of GET /stats. Prices are grouped by currency,
+// since they can't be compared across currencies.
+type albumStats struct {
+	TotalAlbums int                   `json:"total_albums"`
+	ByCurrency  map[string]priceStats `json:"by_currency"`
+	ComputedAt  time.Time             `json:"computed_at"`
+}
+
+// statsSnapshot is one refresh's result for every tenant
+type statsSnapshot struct {
+	byTenant   map[string]albumStats
+	computedAt time.Time
+}
+
+// statsRefreshInterval reads STATS_REFRESH_INTERVAL_SECONDS, defaulting to one minute
+func statsRefreshInterval() time.Duration {
+	v := os.Getenv("STATS_REFRESH_INTERVAL_SECONDS")
+	if v == "" {
+		return defaultStatsRefreshInterval
+	}
+
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		log.Fatalf("STATS_REFRESH_INTERVAL_SECONDS must be a positive integer, got %q", v)
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// statsWorker recomputes album statistics on a schedule so GET /stats never
+// has to scan the albums table itself
+type statsWorker struct {
+	db       DB
+	snapshot atomic.Value // *statsSnapshot
+	done     chan struct{}
+}
+
+func newStatsWorker(db DB) *statsWorker {
+	return &statsWorker{db: db, done: make(chan struct{})}
+}
+
+// Start computes the statistics once, then again every interval in a
+// goroutine until ctx is cancelled. Use Wait to block until it has stopped.
+func (sw *statsWorker) Start(ctx context.Context, interval time.Duration) {
+	sw.refresh(ctx)
+
+	go func() {
+		defer close(sw.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sw.refresh(ctx)
+			}
+		}
+	}()
+}
+
+// Wait blocks until the goroutine started by Start has returned
+func (sw *statsWorker) Wait() {
+	<-sw.done
+}
+
+// refresh replaces the snapshot; on failure the previous one is kept. Prices
+// may be encrypted, so they are aggregated here rather than by the database.
+func (sw *statsWorker) refresh(ctx context.Context) {
+	type group struct {
+		tenant, currency string
+	}
+	type totals struct {
+		priceStats
+		sum float64
+	}
+	groups := make(map[group]*totals)
+	err := sw.db.Select(ctx, func(a *Album) error {
+		g := group{a.TenantID, a.Currency}
+		t, ok := groups[g]
+		if !ok {
+			t = &totals{priceStats: priceStats{MinPrice: a.Price, MaxPrice: a.Price}}
+			groups[g] = t
+		}
+		t.Count++
+		t.sum += a.Price
+		t.MinPrice = math.Min(t.MinPrice, a.Price)
+		t.MaxPrice = math.Max(t.MaxPrice, a.Price)
+		return nil
+	}, "SELECT tenant_id, currency, price FROM albums")
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to refresh album statistics: %v", err)
+		}
+		return
+	}
+
+	now := time.Now().UTC()
+	byTenant := make(map[string]albumStats)
+	for g, t := range groups {
+		stats, ok := byTenant[g.tenant]
+		if !ok {
+			stats = albumStats{ByCurrency: make(map[string]priceStats), ComputedAt: now}
+		}
+		stats.TotalAlbums += t.Count
+		t.AvgPrice = math.Round(t.sum/float64(t.Count)*100) / 100
+		stats.ByCurrency[g.currency] = t.priceStats
+		byTenant[g.tenant] = stats
+	}
+	sw.snapshot.Store(&statsSnapshot{byTenant: byTenant, computedAt: now})
+}
+
+// stats returns the latest statistics for tenant, or false before the first successful refresh
+func (sw *statsWorker) stats(tenant string) (albumStats, bool) {
+	snap, ok := sw.snapshot.Load().(*statsSnapshot)
+	if !ok {
+		return albumStats{}, false
+	}
+	if stats, ok := snap.byTenant[tenant]; ok {
+		return stats, true
+	}
+	// A tenant without albums has no rows in the query
+	return albumStats{ByCurrency: map[string]priceStats{}, ComputedAt: snap.computedAt}, true
+}
+
+// getStats serves GET /stats from the worker's latest snapshot
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := s.stats.stats(tenantFromContext(r.Context()))
+	if !ok {
+		w.Header().Set("Retry-After", "5")
+		sendError(w, APIError{Code: ErrServiceUnavailable, Message: "album statistics are not available yet"}, http.StatusServiceUnavailable)
+		return
+	}
+	sendJSON(w, http.StatusOK, stats)
+}
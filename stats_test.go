@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetStats(t *testing.T) {
+	h, db := newSQLiteTestRouter(t)
+	if rec := serve(t, h, http.MethodGet, "/v1/stats", nil, nil, nil); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "5" {
+		t.Errorf("before the first refresh: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99, Currency: "USD"}, nil, nil)
+
+	// The router's worker isn't running, so read the numbers from a fresh one
+	ctx, cancel := context.WithCancel(context.Background())
+	sw := newStatsWorker(db)
+	sw.Start(ctx, time.Hour)
+	cancel()
+	sw.Wait()
+	srv := &Server{stats: sw}
+	get := func(tenant string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		srv.getStats(rec, req.WithContext(withTenant(req.Context(), tenant)))
+		return rec
+	}
+	rec := get("acme")
+	var stats albumStats
+	decodeJSON(t, rec, &stats)
+	if rec.Code != http.StatusOK || stats.TotalAlbums != 0 || stats.ByCurrency == nil {
+		t.Errorf("tenant without albums: status %d, %+v", rec.Code, stats)
+	}
+	rec = get(defaultTenant)
+	decodeJSON(t, rec, &stats)
+	if stats.TotalAlbums != 1 || stats.ByCurrency["USD"].MaxPrice != 17.99 {
+		t.Errorf("default tenant: %+v", stats)
+	}
+}
+
+func TestStatsRefreshInterval(t *testing.T) {
+	t.Setenv("STATS_REFRESH_INTERVAL_SECONDS", "")
+	if d := statsRefreshInterval(); d != defaultStatsRefreshInterval {
+		t.Errorf("default %v", d)
+	}
+	t.Setenv("STATS_REFRESH_INTERVAL_SECONDS", "5")
+	if d := statsRefreshInterval(); d != 5*time.Second {
+		t.Errorf("STATS_REFRESH_INTERVAL_SECONDS=5: %v", d)
+	}
+}
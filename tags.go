@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== Album Tags ==========
+
+const (
+	// maxTagLen keeps tags to short labels
+	maxTagLen = 50
+
+	// maxTagsPerRequest caps how many tags POST /albums/{id}/tags may add at once
+	maxTagsPerRequest = 20
+)
+
+// errTagNotFound means the album doesn't have the tag
+var errTagNotFound = errors.New("album has no such tag")
+
+// Tag is a row of tags. Names are unique within a tenant.
+type Tag struct {
+	tableName struct{} `pg:"tags"`
+
+	ID       int64  `pg:"id,pk"`
+	TenantID string `pg:"tenant_id"`
+	Name     string `pg:"name"`
+}
+
+// AlbumTag is a row of album_tags, linking an album to a tag
+type AlbumTag struct {
+	tableName struct{} `pg:"album_tags"`
+
+	TenantID string `pg:"tenant_id,pk"`
+	AlbumID  string `pg:"album_id,pk"`
+	TagID    int64  `pg:"tag_id,pk"`
+}
+
+// normalizeTag is a tag as it is stored and matched: trimmed and lowercased,
+// so "Jazz " and "jazz" are the same tag
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// parseTags normalizes and de-duplicates the tags sent to POST /albums/{id}/tags
+func parseTags(raw []string) ([]string, ValidationErrors) {
+	var errs ValidationErrors
+	if len(raw) == 0 {
+		errs.Add("tags", "tags must name at least one tag")
+	}
+	if len(raw) > maxTagsPerRequest {
+		errs.Add("tags", fmt.Sprintf("at most %d tags can be added at once", maxTagsPerRequest))
+	}
+
+	seen := make(map[string]bool, len(raw))
+	var tags []string
+	for _, t := range raw {
+		tag := normalizeTag(t)
+		switch {
+		case tag == "":
+			errs.Add("tags", "tags must not be blank")
+		case len(tag) > maxTagLen:
+			errs.Add("tags", fmt.Sprintf("tag %q is longer than %d characters", tag, maxTagLen))
+		case !seen[tag]:
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, errs
+}
+
+// tagColumns splits fields into the album columns to select and whether
+// tags, which live in album_tags, were asked for too. nil fields means every
+// column and the tags.
+func tagColumns(fields []string) (columns []string, withTags bool) {
+	if fields == nil {
+		return nil, true
+	}
+	for _, f := range fields {
+		if f == "tags" {
+			withTags = true
+			continue
+		}
+		columns = append(columns, f)
+	}
+	// Tags are matched to their album by id
+	if withTags && !isSelected(columns, "id") {
+		columns = append(columns, "id")
+	}
+	return columns, withTags
+}
+
+// loadAlbumTags fills in the Tags of albums, in name order, with one query
+func loadAlbumTags(ctx context.Context, db DB, albums []Album) error {
+	if len(albums) == 0 {
+		return nil
+	}
+	ids := make(inList, len(albums))
+	for i := range albums {
+		ids[i] = albums[i].ID
+	}
+
+	var rows []struct {
+		AlbumID string
+		Name    string
+	}
+	err := db.Select(ctx, &rows, `
+		SELECT at.album_id, t.name
+		FROM album_tags AS at
+		JOIN tags AS t ON t.id = at.tag_id
+		WHERE at.tenant_id = ? AND at.album_id IN (?)
+		ORDER BY t.name`,
+		tenantFromContext(ctx), ids)
+	if err != nil {
+		return fmt.Errorf("loading album tags: %w", err)
+	}
+
+	byAlbum := make(map[string][]string)
+	for _, row := range rows {
+		byAlbum[row.AlbumID] = append(byAlbum[row.AlbumID], row.Name)
+	}
+	for i := range albums {
+		albums[i].Tags = byAlbum[albums[i].ID]
+		if albums[i].Tags == nil {
+			albums[i].Tags = []string{}
+		}
+	}
+	return nil
+}
+
+// bumpAlbumVersion marks a change to the album's tags like any other update,
+// so ETags change and the change shows up in album_events
+func bumpAlbumVersion(ctx context.Context, tx DB, id string) error {
+	_, err := tx.Update(ctx, nil, `UPDATE albums SET version = version + 1, updated_at = `+tx.dialect().now+` WHERE tenant_id = ? AND id = ?`,
+		tenantFromContext(ctx), id)
+	return err
+}
+
+// lockAlbum locks album id for the rest of tx, returning errAlbumNotFound when it doesn't exist
+func lockAlbum(ctx context.Context, tx DB, id string) error {
+	var locked string
+	err := tx.Select(ctx, &locked, `SELECT id FROM albums WHERE tenant_id = ? AND id = ?`+tx.dialect().forUpdate,
+		tenantFromContext(ctx), id)
+	if err == sql.ErrNoRows {
+		return errAlbumNotFound
+	}
+	return err
+}
+
+// AddAlbumTags creates the tags that don't exist yet and links all of them
+// to the album. It returns every tag of the album afterwards.
+func (r *sqlAlbumRepository) AddAlbumTags(ctx context.Context, id string, tags []string) ([]string, error) {
+	tenant := tenantFromContext(ctx)
+	album := []Album{{ID: id}}
+	err := r.db.RunInTransaction(ctx, func(tx DB) error {
+		if err := lockAlbum(ctx, tx, id); err != nil {
+			return err
+		}
+
+		values := make([]string, len(tags))
+		args := make([]interface{}, 0, 2*len(tags))
+		for i, name := range tags {
+			values[i] = "(?, ?)"
+			args = append(args, tenant, name)
+		}
+		// The no-op update makes RETURNING report the ids of existing tags too
+		var tagIDs []int64
+		_, err := tx.Insert(ctx, &tagIDs, `
+			INSERT INTO tags (tenant_id, name)
+			VALUES `+strings.Join(values, ", ")+`
+			ON CONFLICT (tenant_id, name) DO UPDATE SET name = EXCLUDED.name
+			RETURNING id`,
+			args...)
+		if err != nil {
+			return err
+		}
+
+		linked := 0
+		for _, tagID := range tagIDs {
+			n, err := tx.Insert(ctx, nil, `
+				INSERT INTO album_tags (tenant_id, album_id, tag_id)
+				VALUES (?, ?, ?)
+				ON CONFLICT DO NOTHING`,
+				tenant, id, tagID)
+			if err != nil {
+				return err
+			}
+			linked += n
+		}
+		if linked > 0 {
+			if err := bumpAlbumVersion(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return loadAlbumTags(ctx, tx, album)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return album[0].Tags, nil
+}
+
+// RemoveAlbumTag unlinks tag from the album. The tag itself stays, for other albums.
+func (r *sqlAlbumRepository) RemoveAlbumTag(ctx context.Context, id, tag string) error {
+	return r.db.RunInTransaction(ctx, func(tx DB) error {
+		if err := lockAlbum(ctx, tx, id); err != nil {
+			return err
+		}
+		tenant := tenantFromContext(ctx)
+		n, err := tx.Delete(ctx, nil, `
+			DELETE FROM album_tags
+			WHERE tenant_id = ? AND album_id = ?
+				AND tag_id IN (SELECT id FROM tags WHERE tenant_id = ? AND name = ?)`,
+			tenant, id, tenant, normalizeTag(tag))
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errTagNotFound
+		}
+		return bumpAlbumVersion(ctx, tx, id)
+	})
+}
+
+// sendTagError answers for a failed AddAlbumTags or RemoveAlbumTag
+func sendTagError(w http.ResponseWriter, err error) {
+	switch err {
+	case errAlbumNotFound, errTagNotFound:
+		sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+	default:
+		sendStorageError(w, err)
+	}
+}
+
+// postAlbumTags serves POST /albums/{id}/tags with {"tags": ["jazz", "classic"]},
+// answering with every tag the album has afterwards
+func (s *Server) postAlbumTags(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "body must be {\"tags\": [...]}", Field: "tags"}, http.StatusBadRequest)
+		return
+	}
+	tags, errs := parseTags(body.Tags)
+	if errs != nil {
+		sendError(w, errs.APIError(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	all, err := s.albums.AddAlbumTags(r.Context(), id, tags)
+	if err != nil {
+		sendTagError(w, err)
+		return
+	}
+	s.listCache.invalidate()
+	sendJSON(w, http.StatusOK, map[string]interface{}{"id": id, "tags": all})
+}
+
+// deleteAlbumTag serves DELETE /albums/{id}/tags/{tag}
+func (s *Server) deleteAlbumTag(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !validAlbumID(id) {
+		sendError(w, APIError{Code: ErrValidation, Message: "invalid album id format", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+	if err := s.albums.RemoveAlbumTag(r.Context(), id, chi.URLParam(r, "tag")); err != nil {
+		sendTagError(w, err)
+		return
+	}
+	s.listCache.invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
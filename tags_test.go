@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, errs := parseTags([]string{" Jazz", "jazz", "Modal "})
+	if errs != nil || len(tags) != 2 || tags[0] != "jazz" || tags[1] != "modal" {
+		t.Errorf("parseTags = %v, %v; want [jazz modal]", tags, errs)
+	}
+	for _, raw := range [][]string{
+		nil,
+		{" "},
+		{strings.Repeat("x", maxTagLen+1)},
+		make([]string, maxTagsPerRequest+1),
+	} {
+		if _, errs := parseTags(raw); errs == nil {
+			t.Errorf("parseTags(%q) accepted", raw)
+		}
+	}
+}
+
+func TestAlbumTagsWithFields(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	serve(t, h, http.MethodPost, "/v1/albums/1/tags", map[string][]string{"tags": {"cool"}}, nil, nil)
+
+	var albums []map[string]interface{}
+	serve(t, h, http.MethodGet, "/v1/albums?fields=title,tags", nil, nil, &albums)
+	if len(albums) != 1 || albums[0]["title"] != "Jeru" || albums[0]["tags"] == nil || albums[0]["artist"] != nil {
+		t.Errorf("?fields=title,tags returned %v", albums)
+	}
+	var titles []map[string]interface{}
+	serve(t, h, http.MethodGet, "/v1/albums?fields=title", nil, nil, &titles)
+	if len(titles) != 1 || titles[0]["tags"] != nil {
+		t.Errorf("?fields=title returned tags: %v", titles)
+	}
+}
+
+func TestAlbumTagsRejectBadRequests(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+
+	for _, tt := range []struct {
+		method, path string
+		body         interface{}
+		status       int
+	}{
+		{http.MethodPost, "/v1/albums/42/tags", map[string][]string{"tags": {"cool"}}, http.StatusNotFound},
+		{http.MethodPost, "/v1/albums/1/tags", map[string][]string{"tags": {" "}}, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/v1/albums/1/tags", map[string][]string{"tags": {}}, http.StatusBadRequest},
+		{http.MethodDelete, "/v1/albums/42/tags/cool", nil, http.StatusNotFound},
+	} {
+		if rec := serve(t, h, tt.method, tt.path, tt.body, nil, nil); rec.Code != tt.status {
+			t.Errorf("%s %s %v: status %d, want %d: %s", tt.method, tt.path, tt.body, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ========== Tenants ==========
+
+// defaultTenant owns every album when multi-tenancy is off (TENANT_IDS unset)
+const defaultTenant = "default"
+
+type tenantKey struct{}
+
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFromContext returns the tenant set by tenantMiddleware, or defaultTenant
+func tenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return defaultTenant
+}
+
+// loadTenants reads the comma-separated TENANT_IDS allowlist.
+// It returns nil when multi-tenancy is disabled.
+func loadTenants() map[string]bool {
+	raw := os.Getenv("TENANT_IDS")
+	if raw == "" {
+		return nil
+	}
+
+	tenants := make(map[string]bool)
+	for _, t := range strings.Split(raw, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tenants[t] = true
+		}
+	}
+	if len(tenants) == 0 {
+		log.Fatal("TENANT_IDS must list at least one tenant")
+	}
+	return tenants
+}
+
+// tenantMiddleware resolves the X-Tenant-ID header against the allowlist and
+// stores the tenant in the request context for the repositories to filter on.
+// With a nil allowlist every request belongs to defaultTenant and the header is ignored.
+func tenantMiddleware(tenants map[string]bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tenants == nil {
+				next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), defaultTenant)))
+				return
+			}
+
+			tenant := r.Header.Get("X-Tenant-ID")
+			if tenant == "" {
+				sendError(w, APIError{Code: ErrValidation, Message: "X-Tenant-ID header is required", Field: "X-Tenant-ID"}, http.StatusBadRequest)
+				return
+			}
+			if !tenants[tenant] {
+				sendError(w, APIError{Code: ErrForbidden, Message: "unknown tenant", Field: "X-Tenant-ID"}, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	t.Setenv("TENANT_IDS", " acme, ,globex")
+	tenants := loadTenants()
+	if len(tenants) != 2 || !tenants["acme"] || !tenants["globex"] {
+		t.Fatalf("tenants %v", tenants)
+	}
+
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = tenantFromContext(r.Context())
+	})
+	for _, tt := range []struct {
+		tenants map[string]bool
+		header  string
+		status  int
+		tenant  string
+	}{
+		{tenants, "acme", http.StatusOK, "acme"},
+		{tenants, "", http.StatusBadRequest, ""},
+		{tenants, "initech", http.StatusForbidden, ""},
+		{nil, "acme", http.StatusOK, defaultTenant},
+	} {
+		got = ""
+		req := httptest.NewRequest(http.MethodGet, "/v1/albums", nil)
+		if tt.header != "" {
+			req.Header.Set("X-Tenant-ID", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		tenantMiddleware(tt.tenants)(next).ServeHTTP(rec, req)
+		if rec.Code != tt.status || got != tt.tenant {
+			t.Errorf("X-Tenant-ID %q with %v: status %d, tenant %q; want %d, %q", tt.header, tt.tenants, rec.Code, got, tt.status, tt.tenant)
+		}
+	}
+
+	t.Setenv("TENANT_IDS", "")
+	if loadTenants() != nil {
+		t.Error("tenants without TENANT_IDS")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ========== Top Albums ==========
+
+const (
+	defaultTopAlbums = 10
+	maxTopAlbums     = 50
+
+	// topAlbumsCacheTTL is how long a GET /albums/top answer is reused; the
+	// lists are meant for "premium" and "popular" shelves, which may lag a little
+	topAlbumsCacheTTL  = 5 * time.Minute
+	topAlbumsCacheSize = 50
+)
+
+// getTopAlbums serves GET /albums/top?by=price|reviews&limit=: the most
+// expensive albums, or those with the most reviews. by defaults to price.
+func (s *Server) getTopAlbums(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "", "price":
+		by = "price"
+		// Encrypted prices can't be ordered by the database
+		if encryptionKey != nil {
+			sendError(w, APIError{Code: ErrValidation, Message: "by=price is not available while prices are encrypted", Field: "by"}, http.StatusBadRequest)
+			return
+		}
+	case "reviews":
+	default:
+		sendError(w, APIError{Code: ErrValidation, Message: "by must be price or reviews", Field: "by"}, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultTopAlbums
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopAlbums {
+			sendError(w, APIError{Code: ErrValidation, Message: "limit must be an integer between 1 and " + strconv.Itoa(maxTopAlbums), Field: "limit"}, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	key := tenantFromContext(r.Context()) + "?by=" + by + "&limit=" + strconv.Itoa(limit)
+	albums, ok := s.topAlbums.get(key)
+	if !ok {
+		var err error
+		albums, err = s.albums.TopAlbums(r.Context(), by == "reviews", limit)
+		if err != nil {
+			sendStorageError(w, err)
+			return
+		}
+		if albums == nil {
+			albums = []Album{}
+		}
+		s.topAlbums.put(key, albums)
+	}
+	sendJSON(w, http.StatusOK, albums)
+}
+
+// TopAlbums returns the limit albums with the highest price or, with
+// byReviews, the most reviews, filling in their review statistics. Ties go
+// to the lower id.
+func (r *sqlAlbumRepository) TopAlbums(ctx context.Context, byReviews bool, limit int) (albums []Album, err error) {
+	err = retryDB(ctx, dbRetryAttempts, func() error {
+		db := r.reader(ctx)
+		where := albumWhere(ctx)
+		albums = nil
+		if !byReviews {
+			err := db.Select(ctx, &albums, selectAlbumsSQL+where.String()+" ORDER BY CAST(price AS NUMERIC) DESC, id LIMIT ?",
+				append(where.args, limit)...)
+			if err != nil {
+				return err
+			}
+			return loadAlbumTags(ctx, db, albums)
+		}
+
+		var rows []albumWithReviewStats
+		err := db.Select(ctx, &rows, selectAlbumsWithReviewStatsSQL+where.String()+" ORDER BY review_count DESC, album.id LIMIT ?",
+			append(where.args, limit)...)
+		if err != nil {
+			return err
+		}
+		albums = make([]Album, len(rows))
+		for i := range rows {
+			albums[i] = rows[i].Album
+			albums[i].AverageRating = rows[i].StatsAverage
+			albums[i].ReviewCount = &rows[i].StatsCount
+		}
+		return loadAlbumTags(ctx, db, albums)
+	})
+	return albums, err
+}
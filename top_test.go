@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetTopAlbums(t *testing.T) {
+	h, _ := newSQLiteTestRouter(t)
+	for _, a := range []Album{
+		{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+		{ID: "2", Title: "Time Out", Artist: "Dave Brubeck", Price: 22},
+		{ID: "3", Title: "Kind of Blue", Artist: "Miles Davis", Price: 29.99},
+	} {
+		serve(t, h, http.MethodPost, "/v1/albums", a, nil, nil)
+	}
+
+	var top []Album
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/top?limit=2", nil, nil, &top); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(top) != 2 || top[0].ID != "3" || top[1].ID != "2" {
+		t.Errorf("top by price %+v, want 3 then 2", top)
+	}
+
+	// Answers are cached for a while, so a new album doesn't show up straight away
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 99}, nil, nil)
+	serve(t, h, http.MethodGet, "/v1/albums/top?by=price&limit=2", nil, nil, &top)
+	if top[0].ID != "3" {
+		t.Errorf("cached answer starts with %s, want 3", top[0].ID)
+	}
+
+	for _, query := range []string{"by=rating", "limit=0", "limit=51", "limit=x"} {
+		if rec := serve(t, h, http.MethodGet, "/v1/albums/top?"+query, nil, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status %d, want 400", query, rec.Code)
+		}
+	}
+
+	saved := encryptionKey
+	encryptionKey = make([]byte, 32)
+	defer func() { encryptionKey = saved }()
+	if rec := serve(t, h, http.MethodGet, "/v1/albums/top", nil, nil, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("by price with encrypted prices: status %d, want 400", rec.Code)
+	}
+}
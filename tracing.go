@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-pg/pg/v10"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ========== Tracing ==========
+
+const tracerName = "web-service"
+
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing exports spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT.
+// Without the endpoint, spans are still created but go nowhere. The returned
+// function flushes pending spans and must be called before exiting.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	// W3C traceparent/tracestate, so traces continue across services
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads OTEL_EXPORTER_OTLP_ENDPOINT (and the other OTEL_* settings) itself
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.version", version)))
+	if err != nil {
+		return nil, fmt.Errorf("building trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("Exporting traces to %s", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+	return provider.Shutdown, nil
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// tracingMiddleware starts a server span for every request, continuing the
+// caller's trace when the request carries a traceparent header
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		// The route is only known once chi has matched it
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(attribute.String("http.route", rctx.RoutePattern()))
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// tracingQueryHook is a pg.QueryHook that wraps every database call in a
+// child span of the request's span
+type tracingQueryHook struct{}
+
+var _ pg.QueryHook = tracingQueryHook{}
+
+func (tracingQueryHook) BeforeQuery(ctx context.Context, evt *pg.QueryEvent) (context.Context, error) {
+	ctx, _ = tracer.Start(ctx, "db.query", trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, nil
+}
+
+func (tracingQueryHook) AfterQuery(ctx context.Context, evt *pg.QueryEvent) error {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	// The unformatted query keeps parameter values out of the trace
+	if query, err := evt.UnformattedQuery(); err == nil {
+		span.SetAttributes(attribute.String("db.statement", string(query)))
+	}
+	span.SetAttributes(attribute.String("db.system", "postgresql"))
+	if evt.Result != nil {
+		span.SetAttributes(attribute.Int("db.rows_affected", evt.Result.RowsAffected()))
+	}
+	if evt.Err != nil && evt.Err != pg.ErrNoRows {
+		span.RecordError(evt.Err)
+		span.SetStatus(codes.Error, evt.Err.Error())
+	}
+	return nil
+}
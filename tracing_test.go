@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-pg/pg/v10"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	spans := tracetest.NewSpanRecorder()
+	saved := tracer
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer(tracerName)
+	defer func() { tracer = saved }()
+
+	r := chi.NewRouter()
+	r.Use(tracingMiddleware)
+	r.Get("/albums/{id}", func(w http.ResponseWriter, r *http.Request) {
+		evt := &pg.QueryEvent{Query: "SELECT * FROM albums WHERE id = ?"}
+		ctx, _ := tracingQueryHook{}.BeforeQuery(r.Context(), evt)
+		tracingQueryHook{}.AfterQuery(ctx, evt)
+		if chi.URLParam(r, "id") == "0" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/albums/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/albums/0", nil))
+
+	ended := spans.Ended()
+	if len(ended) != 4 {
+		t.Fatalf("%d spans, want a query and a request span per request", len(ended))
+	}
+	query, request := ended[0], ended[1]
+	if query.Name() != "db.query" || query.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("query span %q isn't a child of the request span", query.Name())
+	}
+	if request.Name() != "GET /albums/{id}" || request.Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("request span %q in trace %s, want the route in the caller's trace", request.Name(), request.Parent().TraceID())
+	}
+	if ended[3].Status().Code != codes.Error {
+		t.Errorf("span of a 500 has status %v, want an error", ended[3].Status())
+	}
+}
+
+func TestSetupTracingExporter(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://127.0.0.1:4318")
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
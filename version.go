@@ -0,0 +1,29 @@
+package main
+
+import "net/http"
+
+// ========== Build Info ==========
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// versionHandler reports which build is running
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, http.StatusOK, versionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== API Versioning ==========
+
+// Supported API versions. v2 is reserved for the next breaking change and
+// serves the same handlers as v1 until then.
+const (
+	apiV1 = 1
+	apiV2 = 2
+
+	latestAPIVersion = apiV2
+	// latestStableAPIVersion is what unversioned requests without Accept-Version get
+	latestStableAPIVersion = apiV1
+)
+
+// apiPrefix is the path the API is mounted under, such as "/api", or "" for
+// the root; set by loadAPIPrefix. /healthz, /openapi.json, /docs and /covers
+// stay at the root either way.
+var apiPrefix string
+
+// apiPrefixPattern is one or more plain path segments
+var apiPrefixPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// loadAPIPrefix reads API_PREFIX. Leading and trailing slashes are optional,
+// so "api", "/api" and "/api/" are the same.
+func loadAPIPrefix() {
+	v := os.Getenv("API_PREFIX")
+	prefix := "/" + strings.Trim(v, "/")
+	if prefix == "/" {
+		apiPrefix = ""
+		return
+	}
+	if !apiPrefixPattern.MatchString(prefix) {
+		log.Fatalf("API_PREFIX must be a URL path such as /api, got %q", v)
+	}
+	apiPrefix = prefix
+	log.Printf("Serving the API under %s", apiPrefix)
+}
+
+type apiVersionKey struct{}
+
+func withAPIVersion(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, apiVersionKey{}, version)
+}
+
+// apiVersionFromContext returns the version chosen by versionMiddleware, or the
+// latest stable one. Handlers can use it to adjust the shape of a response.
+func apiVersionFromContext(ctx context.Context) int {
+	if v, ok := ctx.Value(apiVersionKey{}).(int); ok {
+		return v
+	}
+	return latestStableAPIVersion
+}
+
+// apiPath prefixes path with API_PREFIX and the request's version, e.g.
+// "/albums" -> "/v1/albums", or "/api/v1/albums" with API_PREFIX=/api
+func apiPath(ctx context.Context, path string) string {
+	return apiPrefix + "/v" + strconv.Itoa(apiVersionFromContext(ctx)) + path
+}
+
+// parseAPIVersion turns "v1" into 1, reporting false for anything unsupported
+func parseAPIVersion(s string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "v"))
+	if err != nil || !strings.HasPrefix(s, "v") || n < apiV1 || n > latestAPIVersion {
+		return 0, false
+	}
+	return n, true
+}
+
+// versionMiddleware picks the API version for a request, stores it in the
+// request context and echoes it in the API-Version header. The {version} URL
+// parameter of a route mounted at "/{version}" wins, then the Accept-Version
+// header. Unversioned requests without the header are redirected with 301 to
+// the latest stable version's path. Unknown URL versions are a 404, unknown
+// header values a 400.
+func versionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var version int
+		if v := chi.URLParam(r, "version"); v != "" {
+			var ok bool
+			if version, ok = parseAPIVersion(v); !ok {
+				sendError(w, APIError{Code: ErrNotFound, Message: "unknown API version"}, http.StatusNotFound)
+				return
+			}
+		} else if v := r.Header.Get("Accept-Version"); v != "" {
+			var ok bool
+			if version, ok = parseAPIVersion(v); !ok {
+				sendError(w, APIError{Code: ErrValidation, Message: fmt.Sprintf("unsupported API version %q", v), Field: "Accept-Version"}, http.StatusBadRequest)
+				return
+			}
+		} else {
+			path := apiPrefix + "/v" + strconv.Itoa(latestStableAPIVersion) + strings.TrimPrefix(r.URL.RequestURI(), apiPrefix)
+			http.Redirect(w, r, path, http.StatusMovedPermanently)
+			return
+		}
+
+		if version < latestStableAPIVersion {
+			log.Printf("Client %s requested old API version v%d for %s %s", clientIP(r), version, r.Method, r.URL.Path)
+		}
+		w.Header().Set("API-Version", strconv.Itoa(version))
+		w.Header().Add("Vary", "Accept-Version")
+		next.ServeHTTP(w, r.WithContext(withAPIVersion(r.Context(), version)))
+	})
+}
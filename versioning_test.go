@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestVersionMiddleware(t *testing.T) {
+	srv, _ := newTestServer(testAlbums...)
+	h := newTestRouter(t, srv)
+
+	for _, tt := range []struct {
+		path, acceptVersion string
+		status              int
+		apiVersion          string
+		location            string
+	}{
+		{"/v1/albums", "", http.StatusOK, "1", ""},
+		{"/v2/albums", "", http.StatusOK, "2", ""},
+		{"/albums", "v2", http.StatusOK, "2", ""},
+		{"/albums?limit=1", "", http.StatusMovedPermanently, "", "/v1/albums?limit=1"},
+		{"/albums", "v9", http.StatusBadRequest, "", ""},
+		{"/albums", "2", http.StatusBadRequest, "", ""},
+		{"/v9/albums", "", http.StatusNotFound, "", ""},
+	} {
+		header := http.Header{}
+		if tt.acceptVersion != "" {
+			header.Set("Accept-Version", tt.acceptVersion)
+		}
+		rec := serve(t, h, http.MethodGet, tt.path, nil, header, nil)
+		if rec.Code != tt.status || rec.Header().Get("API-Version") != tt.apiVersion || rec.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s (Accept-Version %q): status %d, API-Version %q, Location %q; want %d, %q, %q",
+				tt.path, tt.acceptVersion, rec.Code, rec.Header().Get("API-Version"), rec.Header().Get("Location"), tt.status, tt.apiVersion, tt.location)
+		}
+	}
+}
+
+func TestLoadAPIPrefix(t *testing.T) {
+	defer func() { apiPrefix = "" }()
+	for _, tt := range []struct{ env, want string }{
+		{"", ""},
+		{"/", ""},
+		{"api", "/api"},
+		{"/api/v/", "/api/v"},
+	} {
+		t.Setenv("API_PREFIX", tt.env)
+		loadAPIPrefix()
+		if apiPrefix != tt.want {
+			t.Errorf("API_PREFIX=%q: prefix %q, want %q", tt.env, apiPrefix, tt.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ========== Webhook Deliveries ==========
+
+// Delivery statuses
+const (
+	deliveryPending   = "pending"   // not attempted yet
+	deliveryFailed    = "failed"    // waiting for a retry at next_retry_at
+	deliveryDelivered = "delivered" // answered with a 2xx
+	deliveryDead      = "dead"      // given up after maxDeliveryAttempts
+)
+
+const (
+	// maxDeliveryAttempts is how often a delivery is tried before it is marked dead
+	maxDeliveryAttempts = 10
+
+	// webhookRetryBase is the wait after the first failure; it doubles with
+	// every further one, up to webhookRetryMax
+	webhookRetryBase = 5 * time.Minute
+	webhookRetryMax  = 24 * time.Hour
+
+	// webhookRetryInterval is how often the retry loop looks for due deliveries
+	webhookRetryInterval = 30 * time.Second
+
+	// webhookRetryBatch deliveries are claimed at a time, for webhookRetryLease;
+	// the batch must be done within the lease, even if every attempt times out
+	webhookRetryBatch = 20
+	webhookRetryLease = 5 * time.Minute
+)
+
+// WebhookDelivery is a row of webhook_deliveries: one event for one webhook
+// and how sending it went
+type WebhookDelivery struct {
+	tableName struct{} `pg:"webhook_deliveries"`
+
+	ID          int64           `json:"id" pg:"id,pk"`
+	TenantID    string          `json:"-" pg:"tenant_id"`
+	WebhookID   int64           `json:"webhook_id" pg:"webhook_id"`
+	Event       string          `json:"event" pg:"event"`
+	Payload     json.RawMessage `json:"payload" pg:"payload,type:jsonb"`
+	Attempts    int             `json:"attempts" pg:"attempts"`
+	LastError   string          `json:"last_error,omitempty" pg:"last_error"`
+	NextRetryAt *time.Time      `json:"next_retry_at,omitempty" pg:"next_retry_at"`
+	Status      string          `json:"status" pg:"status"`
+	CreatedAt   time.Time       `json:"created_at" pg:"created_at,default:now()"`
+	UpdatedAt   time.Time       `json:"updated_at" pg:"updated_at,default:now()"`
+}
+
+// recordAttempt counts an attempt made at now, which failed with err or
+// succeeded when err is nil, and schedules the next one
+func (dl *WebhookDelivery) recordAttempt(err error, now time.Time) {
+	dl.Attempts++
+	dl.NextRetryAt = nil
+	if err == nil {
+		dl.Status, dl.LastError = deliveryDelivered, ""
+		return
+	}
+
+	dl.LastError = err.Error()
+	if dl.Attempts >= maxDeliveryAttempts {
+		dl.Status = deliveryDead
+		return
+	}
+	next := now.Add(webhookRetryDelay(dl.Attempts))
+	dl.Status, dl.NextRetryAt = deliveryFailed, &next
+}
+
+// webhookRetryDelay is how long to wait after the given number of failed attempts
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > webhookRetryMax {
+		return webhookRetryMax
+	}
+	return delay
+}
+
+func (r *sqlWebhookRepository) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	delivery.TenantID = tenantFromContext(ctx)
+	_, err := r.db.Insert(ctx, delivery, `
+		INSERT INTO webhook_deliveries (tenant_id, webhook_id, event, payload, attempts, last_error, next_retry_at, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING *`,
+		delivery.TenantID, delivery.WebhookID, delivery.Event, delivery.Payload, delivery.Attempts,
+		nullString(delivery.LastError), delivery.NextRetryAt, delivery.Status)
+	return err
+}
+
+func (r *sqlWebhookRepository) UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	_, err := r.db.Update(ctx, nil, `
+		UPDATE webhook_deliveries
+		SET attempts = ?, last_error = ?, next_retry_at = ?, status = ?, updated_at = `+r.db.dialect().now+`
+		WHERE id = ?`,
+		delivery.Attempts, nullString(delivery.LastError), delivery.NextRetryAt, delivery.Status, delivery.ID)
+	return err
+}
+
+func (r *sqlWebhookRepository) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	now := time.Now()
+	// SKIP LOCKED lets several server instances claim batches side by side
+	_, err := r.db.Update(ctx, &deliveries, `
+		UPDATE webhook_deliveries SET next_retry_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status IN (?, ?) AND next_retry_at <= ?
+			ORDER BY next_retry_at
+			LIMIT ?`+r.db.dialect().skipLocked+`
+		)
+		RETURNING *`,
+		now.Add(lease), deliveryPending, deliveryFailed, now, limit)
+	return deliveries, err
+}
+
+func (r *sqlWebhookRepository) ListDeliveries(ctx context.Context, webhookID int64, status string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	where := new(whereClause).and("tenant_id = ?", tenantFromContext(ctx)).and("webhook_id = ?", webhookID)
+	if status != "" {
+		where.and("status = ?", status)
+	}
+	err := r.db.Select(ctx, &deliveries, "SELECT * FROM webhook_deliveries"+where.String()+" ORDER BY created_at DESC, id DESC LIMIT ?",
+		append(where.args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// retryLoop retries due deliveries every webhookRetryInterval until Stop
+func (d *webhookDispatcher) retryLoop() {
+	ticker := time.NewTicker(webhookRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.retryDue()
+		}
+	}
+}
+
+// retryDue claims a batch of due deliveries and attempts each once more
+func (d *webhookDispatcher) retryDue() {
+	deliveries, err := d.repo.ClaimDueDeliveries(d.ctx, webhookRetryBatch, webhookRetryLease)
+	if err != nil {
+		if d.ctx.Err() == nil {
+			log.Printf("Failed to load webhook deliveries to retry: %v", err)
+		}
+		return
+	}
+
+	for i := range deliveries {
+		select {
+		case <-d.stop:
+			return // the rest are retried once their lease runs out
+		default:
+		}
+
+		delivery := &deliveries[i]
+		ctx := withTenant(d.ctx, delivery.TenantID)
+		hook, err := d.repo.GetWebhook(ctx, delivery.WebhookID)
+		if err != nil {
+			// A deleted webhook takes its deliveries along, so this is a database error
+			log.Printf("Failed to load webhook %d for delivery %d: %v", delivery.WebhookID, delivery.ID, err)
+			continue
+		}
+		d.attempt(ctx, *hook, delivery)
+	}
+}
+
+// webhookDeliveryStatuses are the values ?status= accepts
+var webhookDeliveryStatuses = map[string]bool{
+	deliveryPending: true, deliveryFailed: true, deliveryDelivered: true, deliveryDead: true,
+}
+
+// listWebhookDeliveries serves GET /admin/webhooks/{webhookID}/deliveries?status=...&limit=...
+func (s *Server) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, fe := parseWebhookID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !webhookDeliveryStatuses[status] {
+		sendError(w, APIError{Code: ErrValidation, Message: "status must be pending, failed, delivered or dead", Field: "status"}, http.StatusBadRequest)
+		return
+	}
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, fe := parseLimit(v)
+		if fe != nil {
+			sendError(w, fe.APIError(), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	// Deliveries of another tenant's webhook are as invisible as the webhook
+	if _, err := s.webhooks.GetWebhook(r.Context(), id); err != nil {
+		sendWebhookError(w, err)
+		return
+	}
+	deliveries, err := s.webhooks.ListDeliveries(r.Context(), id, status, limit)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	sendJSON(w, http.StatusOK, deliveries)
+}
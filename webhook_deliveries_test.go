@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	for _, tt := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 20 * time.Minute},
+		{4, 40 * time.Minute},
+		{5, 80 * time.Minute},
+		{6, 160 * time.Minute},
+		{7, 320 * time.Minute},
+		{8, 640 * time.Minute},
+		{9, 1280 * time.Minute},
+		{10, webhookRetryMax},
+		{11, webhookRetryMax},
+		{12, webhookRetryMax},
+	} {
+		if got := webhookRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("webhookRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+	if got := webhookRetryDelay(1000); got != webhookRetryMax {
+		t.Errorf("webhookRetryDelay(1000) = %v, want the cap %v", got, webhookRetryMax)
+	}
+}
+
+func TestRecordAttempt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("status 500")
+
+	dl := &WebhookDelivery{Status: deliveryPending}
+	for attempt := 1; attempt < maxDeliveryAttempts; attempt++ {
+		dl.recordAttempt(failure, now)
+		if dl.Attempts != attempt || dl.Status != deliveryFailed || dl.LastError != "status 500" {
+			t.Fatalf("after failure %d: %+v", attempt, dl)
+		}
+		if want := now.Add(webhookRetryDelay(attempt)); dl.NextRetryAt == nil || !dl.NextRetryAt.Equal(want) {
+			t.Fatalf("after failure %d: next_retry_at %v, want %v", attempt, dl.NextRetryAt, want)
+		}
+	}
+
+	dl.recordAttempt(failure, now)
+	if dl.Attempts != maxDeliveryAttempts || dl.Status != deliveryDead || dl.NextRetryAt != nil {
+		t.Errorf("after failure %d: %+v, want dead with no next_retry_at", maxDeliveryAttempts, dl)
+	}
+
+	dl = &WebhookDelivery{Status: deliveryFailed, Attempts: 3, LastError: "timeout"}
+	dl.recordAttempt(nil, now)
+	if dl.Attempts != 4 || dl.Status != deliveryDelivered || dl.LastError != "" || dl.NextRetryAt != nil {
+		t.Errorf("after a success: %+v, want delivered", dl)
+	}
+}
+
+func TestListWebhookDeliveriesRejectsBadStatus(t *testing.T) {
+	srv, _ := newTestServer()
+	r := chi.NewRouter()
+	r.Get("/admin/webhooks/{webhookID}/deliveries", srv.listWebhookDeliveries)
+
+	for _, target := range []string{
+		"/admin/webhooks/1/deliveries?status=lost",
+		"/admin/webhooks/1/deliveries?status=DEAD",
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var apiErr APIError
+		decodeJSON(t, rec, &apiErr)
+		if rec.Code != http.StatusBadRequest || apiErr.Field != "status" {
+			t.Errorf("%s: status %d, field %q; want 400 on status", target, rec.Code, apiErr.Field)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ========== Webhooks ==========
+
+// Webhook events
+const (
+	webhookAlbumCreated = "album.created"
+	webhookAlbumDeleted = "album.deleted"
+)
+
+const (
+	// defaultWebhookWorkers applies when WEBHOOK_WORKERS is not set
+	defaultWebhookWorkers = 4
+
+	// webhookQueueSize is how many events may wait for a worker before new ones are dropped
+	webhookQueueSize = 1000
+
+	// webhookTimeout bounds one delivery attempt
+	webhookTimeout = 10 * time.Second
+
+	// minWebhookSecretLen keeps signatures from resting on a guessable secret
+	minWebhookSecretLen = 16
+)
+
+// webhookEvents are the events a webhook can subscribe to
+var webhookEvents = map[string]bool{webhookAlbumCreated: true, webhookAlbumDeleted: true}
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+// Webhook is a row of webhooks: a URL that is sent the album events it
+// subscribed to, signed with its secret. The secret is write-only.
+type Webhook struct {
+	tableName struct{} `pg:"webhooks"`
+
+	ID        int64     `json:"id" pg:"id,pk"`
+	TenantID  string    `json:"-" pg:"tenant_id"`
+	URL       string    `json:"url" pg:"url"`
+	Secret    string    `json:"secret,omitempty" pg:"secret"`
+	Events    []string  `json:"events" pg:"events,array"`
+	CreatedAt time.Time `json:"created_at" pg:"created_at,default:now()"`
+}
+
+// validateWebhook checks a webhook sent to POST or PUT /admin/webhooks
+func validateWebhook(h *Webhook) ValidationErrors {
+	var errs ValidationErrors
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.Add("url", "url must be an absolute http or https URL")
+	}
+	if len(h.Secret) < minWebhookSecretLen {
+		errs.Add("secret", fmt.Sprintf("secret must be at least %d characters", minWebhookSecretLen))
+	}
+	if len(h.Events) == 0 {
+		errs.Add("events", "events must name at least one event")
+	}
+	for _, event := range h.Events {
+		if !webhookEvents[event] {
+			errs.Add("events", fmt.Sprintf("unknown event %q, expected %s or %s", event, webhookAlbumCreated, webhookAlbumDeleted))
+		}
+	}
+	return errs
+}
+
+// webhookWorkers reads WEBHOOK_WORKERS, the number of goroutines delivering webhooks
+func webhookWorkers() int {
+	v := os.Getenv("WEBHOOK_WORKERS")
+	if v == "" {
+		return defaultWebhookWorkers
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("WEBHOOK_WORKERS must be a positive integer, got %q", v)
+	}
+	return n
+}
+
+// WebhookRepository stores the webhooks of the request's tenant.
+// Lookups, updates and deletes of a missing webhook return errWebhookNotFound.
+type WebhookRepository interface {
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	// WebhooksFor returns the webhooks subscribed to event
+	WebhooksFor(ctx context.Context, event string) ([]Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (*Webhook, error)
+	CreateWebhook(ctx context.Context, hook *Webhook) error
+	UpdateWebhook(ctx context.Context, hook *Webhook) error
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// UpdateDelivery saves the attempts, last_error, next_retry_at and status of delivery
+	UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// ClaimDueDeliveries returns up to limit deliveries of any tenant that are
+	// due for a retry, and pushes their next_retry_at back by lease so no
+	// other instance picks them up meanwhile
+	ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error)
+	// ListDeliveries returns the deliveries of webhook id, newest first
+	ListDeliveries(ctx context.Context, webhookID int64, status string, limit int) ([]WebhookDelivery, error)
+}
+
+type sqlWebhookRepository struct {
+	db DB
+}
+
+func newSQLWebhookRepository(db DB) *sqlWebhookRepository {
+	return &sqlWebhookRepository{db: db}
+}
+
+func (r *sqlWebhookRepository) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	var hooks []Webhook
+	err := r.db.Select(ctx, &hooks, `SELECT * FROM webhooks WHERE tenant_id = ? ORDER BY id`, tenantFromContext(ctx))
+	return hooks, err
+}
+
+// WebhooksFor picks the subscribers out of the tenant's webhooks, which are few
+func (r *sqlWebhookRepository) WebhooksFor(ctx context.Context, event string) ([]Webhook, error) {
+	hooks, err := r.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var subscribed []Webhook
+	for _, hook := range hooks {
+		for _, e := range hook.Events {
+			if e == event {
+				subscribed = append(subscribed, hook)
+				break
+			}
+		}
+	}
+	return subscribed, nil
+}
+
+func (r *sqlWebhookRepository) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	hook := new(Webhook)
+	err := r.db.Select(ctx, hook, `SELECT * FROM webhooks WHERE tenant_id = ? AND id = ?`, tenantFromContext(ctx), id)
+	if err == sql.ErrNoRows {
+		return nil, errWebhookNotFound
+	}
+	return hook, err
+}
+
+func (r *sqlWebhookRepository) CreateWebhook(ctx context.Context, hook *Webhook) error {
+	hook.TenantID = tenantFromContext(ctx)
+	_, err := r.db.Insert(ctx, hook, `
+		INSERT INTO webhooks (tenant_id, url, secret, events)
+		VALUES (?, ?, ?, ?)
+		RETURNING *`,
+		hook.TenantID, hook.URL, hook.Secret, stringArray(hook.Events))
+	return err
+}
+
+// UpdateWebhook replaces the url, secret and events of hook
+func (r *sqlWebhookRepository) UpdateWebhook(ctx context.Context, hook *Webhook) error {
+	hook.TenantID = tenantFromContext(ctx)
+	n, err := r.db.Update(ctx, hook, `
+		UPDATE webhooks SET url = ?, secret = ?, events = ?
+		WHERE tenant_id = ? AND id = ?
+		RETURNING *`,
+		hook.URL, hook.Secret, stringArray(hook.Events), hook.TenantID, hook.ID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errWebhookNotFound
+	}
+	return nil
+}
+
+func (r *sqlWebhookRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	n, err := r.db.Delete(ctx, nil, `DELETE FROM webhooks WHERE tenant_id = ? AND id = ?`, tenantFromContext(ctx), id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errWebhookNotFound
+	}
+	return nil
+}
+
+// webhookPayload is the JSON body POSTed to a webhook. Album is the album as
+// created; deletes only carry the id.
+type webhookPayload struct {
+	Event      string    `json:"event"`
+	ID         string    `json:"id"`
+	Album      *Album    `json:"album,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// webhookEvent is a queued album event for the webhooks of tenant
+type webhookEvent struct {
+	tenant  string
+	payload webhookPayload
+}
+
+// webhookDispatcher delivers album events to the subscribed webhooks from a
+// pool of workers, so requests only pay for putting the event on a queue.
+// Each delivery is recorded in webhook_deliveries and retried from there when
+// it fails (see webhook_deliveries.go). Events are lost when the queue is full
+// or the server stops before they are recorded.
+type webhookDispatcher struct {
+	repo   WebhookRepository
+	client *http.Client
+	queue  chan webhookEvent
+	wg     sync.WaitGroup
+	stop   chan struct{} // closed by Stop to end the retry loop
+
+	// ctx is cancelled when Stop stops waiting, to abort deliveries in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newWebhookDispatcher(repo WebhookRepository) *webhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookDispatcher{
+		repo:   repo,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan webhookEvent, webhookQueueSize),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start runs workers goroutines that deliver queued events, and one that
+// retries failed deliveries, until Stop
+func (d *webhookDispatcher) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for event := range d.queue {
+				d.deliver(event)
+			}
+		}()
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.retryLoop()
+	}()
+}
+
+// Stop takes no more events and waits for the queued ones to be delivered,
+// at most until ctx is done; deliveries still running then are cancelled and
+// left for the retry loop of the next start
+func (d *webhookDispatcher) Stop(ctx context.Context) {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+		close(d.stop)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Stopping with %d webhook events unrecorded", len(d.queue))
+		d.cancel()
+		<-done
+	}
+}
+
+// enqueue queues event for the webhooks of the request's tenant without
+// waiting for a worker
+func (d *webhookDispatcher) enqueue(ctx context.Context, event, id string, album *Album) {
+	queued := webhookEvent{
+		tenant:  tenantFromContext(ctx),
+		payload: webhookPayload{Event: event, ID: id, Album: album, OccurredAt: time.Now().UTC()},
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.queue <- queued:
+	default:
+		log.Printf("Webhook queue is full, dropping %s event for album %s", event, id)
+	}
+}
+
+// deliver records a delivery of event for every webhook of its tenant that
+// subscribed to it, and makes the first attempt
+func (d *webhookDispatcher) deliver(event webhookEvent) {
+	ctx := withTenant(d.ctx, event.tenant)
+	hooks, err := d.repo.WebhooksFor(ctx, event.payload.Event)
+	if err != nil {
+		log.Printf("Failed to look up webhooks for %s event: %v", event.payload.Event, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	body, err := encodeJSON(event.payload)
+	if err != nil {
+		log.Printf("Failed to encode %s webhook payload: %v", event.payload.Event, err)
+		return
+	}
+	for _, hook := range hooks {
+		// Picked up by the retry loop should this instance stop before the attempt
+		lease := time.Now().Add(webhookRetryLease)
+		delivery := &WebhookDelivery{
+			WebhookID:   hook.ID,
+			Event:       event.payload.Event,
+			Payload:     body,
+			Status:      deliveryPending,
+			NextRetryAt: &lease,
+		}
+		if err := d.repo.CreateDelivery(ctx, delivery); err != nil {
+			log.Printf("Failed to record %s delivery for webhook %d: %v", delivery.Event, hook.ID, err)
+			continue
+		}
+		d.attempt(ctx, hook, delivery)
+	}
+}
+
+// attempt POSTs delivery to hook once and records the outcome
+func (d *webhookDispatcher) attempt(ctx context.Context, hook Webhook, delivery *WebhookDelivery) {
+	err := d.send(ctx, hook, delivery.Event, delivery.Payload)
+	if err != nil && ctx.Err() != nil {
+		return // shutting down; the lease runs out and the retry loop takes over
+	}
+	delivery.recordAttempt(err, time.Now())
+	if delivery.Status == deliveryDead {
+		log.Printf("Webhook %d delivery %d is dead after %d attempts: %v", hook.ID, delivery.ID, delivery.Attempts, err)
+	}
+	if uerr := d.repo.UpdateDelivery(ctx, delivery); uerr != nil {
+		log.Printf("Failed to record attempt of webhook delivery %d: %v", delivery.ID, uerr)
+	}
+}
+
+// send makes one delivery attempt; any answer but a 2xx is a failure
+func (d *webhookDispatcher) send(ctx context.Context, hook Webhook, event string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Signature-256", signWebhook(hook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("answered %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook is the X-Signature-256 header for body: "sha256=" and the hex
+// HMAC-SHA256 of the body under secret
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookAlbumRepository queues webhook events for the albums another
+// AlbumRepository creates and deletes. Only completed changes are reported,
+// so dry-run imports and failed writes send nothing.
+type webhookAlbumRepository struct {
+	AlbumRepository
+	webhooks *webhookDispatcher
+}
+
+func newWebhookAlbumRepository(next AlbumRepository, webhooks *webhookDispatcher) *webhookAlbumRepository {
+	return &webhookAlbumRepository{AlbumRepository: next, webhooks: webhooks}
+}
+
+func (r *webhookAlbumRepository) CreateAlbum(ctx context.Context, album *Album) error {
+	if err := r.AlbumRepository.CreateAlbum(ctx, album); err != nil {
+		return err
+	}
+	created := *album
+	r.webhooks.enqueue(ctx, webhookAlbumCreated, album.ID, &created)
+	return nil
+}
+
+func (r *webhookAlbumRepository) DuplicateAlbum(ctx context.Context, id, newID, title string) (Album, error) {
+	album, err := r.AlbumRepository.DuplicateAlbum(ctx, id, newID, title)
+	if err != nil {
+		return album, err
+	}
+	created := album
+	r.webhooks.enqueue(ctx, webhookAlbumCreated, album.ID, &created)
+	return album, nil
+}
+
+func (r *webhookAlbumRepository) DeleteAlbum(ctx context.Context, id string) error {
+	if err := r.AlbumRepository.DeleteAlbum(ctx, id); err != nil {
+		return err
+	}
+	r.webhooks.enqueue(ctx, webhookAlbumDeleted, id, nil)
+	return nil
+}
+
+func (r *webhookAlbumRepository) DeleteAlbums(ctx context.Context, ids []string) ([]string, error) {
+	deleted, err := r.AlbumRepository.DeleteAlbums(ctx, ids)
+	for _, id := range deleted {
+		r.webhooks.enqueue(ctx, webhookAlbumDeleted, id, nil)
+	}
+	return deleted, err
+}
+
+func (r *webhookAlbumRepository) ImportAlbums(ctx context.Context, albums []Album, dryRun bool) (map[string]bool, error) {
+	existing, err := r.AlbumRepository.ImportAlbums(ctx, albums, dryRun)
+	if err == nil && !dryRun {
+		for i := range albums {
+			created := albums[i]
+			r.webhooks.enqueue(ctx, webhookAlbumCreated, created.ID, &created)
+		}
+	}
+	return existing, err
+}
+
+// parseWebhookID reads the {webhookID} URL parameter
+func parseWebhookID(r *http.Request) (int64, *FieldError) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "webhookID"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, &FieldError{Field: "id", Message: "invalid webhook id"}
+	}
+	return id, nil
+}
+
+// decodeWebhook reads and validates the body of POST and PUT /admin/webhooks,
+// answering the request itself when it is invalid
+func decodeWebhook(w http.ResponseWriter, r *http.Request) (*Webhook, bool) {
+	defer r.Body.Close()
+	var hook Webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		sendError(w, APIError{Code: ErrValidation, Message: "Invalid request body"}, http.StatusBadRequest)
+		return nil, false
+	}
+	if errs := validateWebhook(&hook); errs != nil {
+		sendError(w, errs.APIError(), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return &hook, true
+}
+
+// sendWebhookError answers for a failed WebhookRepository call
+func sendWebhookError(w http.ResponseWriter, err error) {
+	if err == errWebhookNotFound {
+		sendError(w, APIError{Code: ErrNotFound, Message: err.Error()}, http.StatusNotFound)
+		return
+	}
+	sendStorageError(w, err)
+}
+
+// listWebhooks serves GET /admin/webhooks
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := s.webhooks.ListWebhooks(r.Context())
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	if hooks == nil {
+		hooks = []Webhook{}
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	sendJSON(w, http.StatusOK, hooks)
+}
+
+// createWebhook serves POST /admin/webhooks
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := s.webhooks.CreateWebhook(r.Context(), hook); err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	hook.Secret = ""
+	sendJSON(w, http.StatusCreated, hook)
+}
+
+// getWebhook serves GET /admin/webhooks/{webhookID}
+func (s *Server) getWebhook(w http.ResponseWriter, r *http.Request) {
+	id, fe := parseWebhookID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	hook, err := s.webhooks.GetWebhook(r.Context(), id)
+	if err != nil {
+		sendWebhookError(w, err)
+		return
+	}
+	hook.Secret = ""
+	sendJSON(w, http.StatusOK, hook)
+}
+
+// putWebhook serves PUT /admin/webhooks/{webhookID}, replacing the url, secret and events
+func (s *Server) putWebhook(w http.ResponseWriter, r *http.Request) {
+	id, fe := parseWebhookID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	hook, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+	hook.ID = id
+	if err := s.webhooks.UpdateWebhook(r.Context(), hook); err != nil {
+		sendWebhookError(w, err)
+		return
+	}
+	hook.Secret = ""
+	sendJSON(w, http.StatusOK, hook)
+}
+
+// deleteWebhook serves DELETE /admin/webhooks/{webhookID}
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, fe := parseWebhookID(r)
+	if fe != nil {
+		sendError(w, fe.APIError(), http.StatusBadRequest)
+		return
+	}
+	if err := s.webhooks.DeleteWebhook(r.Context(), id); err != nil {
+		sendWebhookError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
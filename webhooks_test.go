@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// webhookCall is a request a test webhook receiver got
+type webhookCall struct {
+	event, signature string
+	body             []byte
+}
+
+// newWebhookReceiver serves a webhook URL that answers status and passes on
+// every request it gets
+func newWebhookReceiver(t *testing.T, status int) (string, <-chan webhookCall) {
+	t.Helper()
+	calls := make(chan webhookCall, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- webhookCall{event: r.Header.Get("X-Webhook-Event"), signature: r.Header.Get("X-Signature-256"), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, calls
+}
+
+func receiveWebhook(t *testing.T, calls <-chan webhookCall) webhookCall {
+	t.Helper()
+	select {
+	case call := <-calls:
+		return call
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+		return webhookCall{}
+	}
+}
+
+// waitForDeliveries polls path until it lists a delivery in status
+func waitForDeliveries(t *testing.T, h http.Handler, path string, header http.Header, status string) []WebhookDelivery {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var deliveries []WebhookDelivery
+		serve(t, h, http.MethodGet, path+"/deliveries?status="+status, nil, header, &deliveries)
+		if len(deliveries) > 0 {
+			return deliveries
+		}
+	}
+	t.Fatalf("no %s delivery", status)
+	return nil
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	url, calls := newWebhookReceiver(t, http.StatusNoContent)
+
+	hook := Webhook{URL: url, Secret: "0123456789abcdef", Events: []string{webhookAlbumCreated, webhookAlbumDeleted}}
+	if rec := serve(t, h, http.MethodPost, "/v1/admin/webhooks", hook, admin, &hook); rec.Code != http.StatusCreated {
+		t.Fatalf("POST webhook: status %d: %s", rec.Code, rec.Body)
+	}
+	path := "/v1/admin/webhooks/" + strconv.FormatInt(hook.ID, 10)
+
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	call := receiveWebhook(t, calls)
+	if call.event != webhookAlbumCreated || call.signature != signWebhook("0123456789abcdef", call.body) {
+		t.Errorf("got %s signed %s, want %s signed with the secret", call.event, call.signature, webhookAlbumCreated)
+	}
+	serve(t, h, http.MethodPost, "/v1/albums/batch-delete", map[string][]string{"ids": {"1"}}, nil, nil)
+	if call := receiveWebhook(t, calls); call.event != webhookAlbumDeleted {
+		t.Errorf("got %s, want %s", call.event, webhookAlbumDeleted)
+	}
+
+	deliveries := waitForDeliveries(t, h, path, admin, deliveryDelivered)
+	if d := deliveries[len(deliveries)-1]; d.Event != webhookAlbumCreated || d.Attempts != 1 {
+		t.Errorf("first delivery %+v, want album.created delivered at the first attempt", d)
+	}
+
+	var hooks []Webhook
+	serve(t, h, http.MethodGet, "/v1/admin/webhooks", nil, admin, &hooks)
+	if len(hooks) != 1 || hooks[0].URL != url || hooks[0].Secret != "" {
+		t.Errorf("webhooks %+v, want the one created, without its secret", hooks)
+	}
+}
+
+// A failed delivery is retried by the retry loop once it is due
+func TestWebhookRetry(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, db := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	failing, failed := newWebhookReceiver(t, http.StatusInternalServerError)
+
+	hook := Webhook{URL: failing, Secret: "0123456789abcdef", Events: []string{webhookAlbumCreated}}
+	serve(t, h, http.MethodPost, "/v1/admin/webhooks", hook, admin, &hook)
+	path := "/v1/admin/webhooks/" + strconv.FormatInt(hook.ID, 10)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	receiveWebhook(t, failed)
+	delivery := waitForDeliveries(t, h, path, admin, deliveryFailed)[0]
+	if delivery.LastError != "answered 500 Internal Server Error" || delivery.NextRetryAt == nil {
+		t.Fatalf("delivery %+v, want it failed with a retry scheduled", delivery)
+	}
+
+	// Point the webhook somewhere that works, and make the retry due
+	working, calls := newWebhookReceiver(t, http.StatusOK)
+	hook.URL = working
+	hook.Secret = "0123456789abcdef"
+	if rec := serve(t, h, http.MethodPut, path, hook, admin, nil); rec.Code != http.StatusOK {
+		t.Fatalf("PUT webhook: status %d: %s", rec.Code, rec.Body)
+	}
+	repo := newSQLWebhookRepository(db)
+	past := time.Now().Add(-time.Minute)
+	delivery.NextRetryAt = &past
+	if err := repo.UpdateDelivery(context.Background(), &delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := newWebhookDispatcher(repo)
+	dispatcher.retryDue()
+	if call := receiveWebhook(t, calls); call.event != webhookAlbumCreated {
+		t.Errorf("retried %s, want %s", call.event, webhookAlbumCreated)
+	}
+	retried := waitForDeliveries(t, h, path, admin, deliveryDelivered)[0]
+	if retried.ID != delivery.ID || retried.Attempts != 2 || retried.LastError != "" {
+		t.Errorf("retried delivery %+v, want delivery %d delivered at the second attempt", retried, delivery.ID)
+	}
+	dispatcher.Stop(context.Background())
+}
+
+// Once stopped, a dispatcher drops new events instead of panicking on its closed queue
+func TestWebhookDispatcherStop(t *testing.T) {
+	d := newWebhookDispatcher(nil)
+	d.Start(2)
+	d.Stop(context.Background())
+	d.Stop(context.Background())
+	d.enqueue(context.Background(), webhookAlbumCreated, "1", nil)
+}
+
+func TestWebhookWorkers(t *testing.T) {
+	if n := webhookWorkers(); n != defaultWebhookWorkers {
+		t.Errorf("default %d, want %d", n, defaultWebhookWorkers)
+	}
+	t.Setenv("WEBHOOK_WORKERS", "2")
+	if n := webhookWorkers(); n != 2 {
+		t.Errorf("WEBHOOK_WORKERS=2: got %d", n)
+	}
+}
+
+func TestWebhookRoutesRejectBadRequests(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	valid := Webhook{URL: "https://example.com/hook", Secret: "0123456789abcdef", Events: []string{webhookAlbumCreated}}
+
+	for _, tt := range []struct {
+		method, path string
+		body         interface{}
+		status       int
+	}{
+		{http.MethodPost, "/v1/admin/webhooks", Webhook{URL: "ftp://example.com", Secret: "short", Events: []string{"album.updated"}}, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/v1/admin/webhooks", "not a webhook", http.StatusBadRequest},
+		{http.MethodGet, "/v1/admin/webhooks/0", nil, http.StatusBadRequest},
+		{http.MethodGet, "/v1/admin/webhooks/9", nil, http.StatusNotFound},
+		{http.MethodPut, "/v1/admin/webhooks/x", valid, http.StatusBadRequest},
+		{http.MethodPut, "/v1/admin/webhooks/9", valid, http.StatusNotFound},
+		{http.MethodPut, "/v1/admin/webhooks/9", Webhook{}, http.StatusUnprocessableEntity},
+		{http.MethodDelete, "/v1/admin/webhooks/x", nil, http.StatusBadRequest},
+		{http.MethodDelete, "/v1/admin/webhooks/9", nil, http.StatusNotFound},
+		{http.MethodGet, "/v1/admin/webhooks/x/deliveries", nil, http.StatusBadRequest},
+		{http.MethodGet, "/v1/admin/webhooks/9/deliveries?limit=0", nil, http.StatusBadRequest},
+	} {
+		if rec := serve(t, h, tt.method, tt.path, tt.body, admin, nil); rec.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestListWebhooks(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h, _ := newSQLiteTestRouter(t)
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+
+	var hooks []Webhook
+	if rec := serve(t, h, http.MethodGet, "/v1/admin/webhooks", nil, admin, &hooks); rec.Code != http.StatusOK || hooks == nil || len(hooks) != 0 {
+		t.Fatalf("no webhooks: status %d, %v", rec.Code, hooks)
+	}
+	hook := Webhook{URL: "https://example.com/hook", Secret: "0123456789abcdef", Events: []string{webhookAlbumCreated}}
+	serve(t, h, http.MethodPost, "/v1/admin/webhooks", hook, admin, nil)
+	serve(t, h, http.MethodGet, "/v1/admin/webhooks", nil, admin, &hooks)
+	if len(hooks) != 1 || hooks[0].URL != hook.URL || hooks[0].Secret != "" {
+		t.Errorf("webhooks %+v, want the one created without its secret", hooks)
+	}
+}
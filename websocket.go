@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// ========== Album WebSocket ==========
+
+const (
+	// webSocketWriteTimeout bounds each write, so a client that stopped reading
+	// can't hold its goroutine forever
+	webSocketWriteTimeout = 10 * time.Second
+
+	// webSocketPingInterval is how often an idle connection is pinged to notice dead clients
+	webSocketPingInterval = 30 * time.Second
+)
+
+// albumEventFilter is what a WebSocket client sends to narrow down its
+// events, e.g. {"artist": "John Coltrane"}. An empty filter matches everything.
+type albumEventFilter struct {
+	Artist string `json:"artist"`
+}
+
+func (f albumEventFilter) matches(event albumEvent) bool {
+	if f.Artist == "" {
+		return true
+	}
+	return event.Album != nil && normalizeArtist(event.Album.Artist) == normalizeArtist(f.Artist)
+}
+
+// isWebSocket reports whether r asks to be upgraded to a WebSocket
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// albumsWebSocket sends the same album change events as albumEvents over a
+// WebSocket, and lets the client replace its filter at any time by sending one
+func (s *Server) albumsWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return // Accept has already answered with an error
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	events, unsubscribe := s.events.Subscribe(tenantFromContext(ctx))
+	defer unsubscribe()
+
+	// The reader ends when the client goes away or sends something that isn't
+	// a filter; either way it takes the connection down with it
+	filters := make(chan albumEventFilter)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		defer cancel()
+		for {
+			var filter albumEventFilter
+			if err := wsjson.Read(ctx, conn, &filter); err != nil {
+				if websocket.CloseStatus(err) == -1 && ctx.Err() == nil {
+					conn.Close(websocket.StatusUnsupportedData, "send a filter such as {\"artist\": \"...\"}")
+				}
+				return
+			}
+			select {
+			case filters <- filter:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	defer func() {
+		cancel()
+		<-readDone
+	}()
+
+	ping := time.NewTicker(webSocketPingInterval)
+	defer ping.Stop()
+
+	var filter albumEventFilter
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case filter = <-filters:
+		case event, ok := <-events:
+			if !ok {
+				conn.Close(websocket.StatusGoingAway, "server shutting down or client too slow")
+				return
+			}
+			if filter.matches(event) {
+				err = writeWithTimeout(ctx, func(ctx context.Context) error {
+					data, err := encodeJSON(event)
+					if err != nil {
+						return err
+					}
+					return conn.Write(ctx, websocket.MessageText, data)
+				})
+			}
+		case <-ping.C:
+			err = writeWithTimeout(ctx, conn.Ping)
+		}
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				conn.Close(websocket.StatusGoingAway, "write failed")
+			}
+			return
+		}
+	}
+}
+
+func writeWithTimeout(ctx context.Context, write func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, webSocketWriteTimeout)
+	defer cancel()
+	return write(ctx)
+}
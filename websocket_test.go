@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+func dialAlbumsWebSocket(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(url, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+func TestAlbumsWebSocket(t *testing.T) {
+	h, db := newSQLiteTestRouter(t)
+	ts, stop := newEventServer(t, db)
+	conn := dialAlbumsWebSocket(t, ts.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := wsjson.Write(ctx, conn, albumEventFilter{Artist: "dave brubeck"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // for the filter to be read before the events come
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil, nil)
+	serve(t, h, http.MethodPost, "/v1/albums", Album{ID: "2", Title: "Time Out", Artist: "Dave Brubeck", Price: 22}, nil, nil)
+
+	var event albumEvent
+	if err := wsjson.Read(ctx, conn, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "created" || event.ID != "2" || event.Album == nil || event.Album.Title != "Time Out" {
+		t.Errorf("event %+v, want only album 2 created", event)
+	}
+
+	stop()
+	_, _, err := conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusGoingAway {
+		t.Errorf("on shutdown: %v, want %v", err, websocket.StatusGoingAway)
+	}
+}
+
+func TestAlbumsWebSocketRejectsBadFilters(t *testing.T) {
+	_, db := newSQLiteTestRouter(t)
+	ts, _ := newEventServer(t, db)
+	conn := dialAlbumsWebSocket(t, ts.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn.Write(ctx, websocket.MessageText, []byte("artist=Dave Brubeck"))
+	_, _, err := conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusInvalidFramePayloadData {
+		t.Errorf("after a bad filter: %v, want %v", err, websocket.StatusInvalidFramePayloadData)
+	}
+
+	resp, err := http.Get(ts.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired && resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET: status %d", resp.StatusCode)
+	}
+}
+
+func TestAlbumEventFilter(t *testing.T) {
+	created := albumEvent{Type: "created", ID: "1", Album: &Album{Artist: "John  Coltrane"}}
+	deleted := albumEvent{Type: "deleted", ID: "1"}
+	for _, tt := range []struct {
+		filter string
+		event  albumEvent
+		want   bool
+	}{
+		{`{}`, deleted, true},
+		{`{"artist":"john coltrane"}`, created, true},
+		{`{"artist":"Miles Davis"}`, created, false},
+		{`{"artist":"John Coltrane"}`, deleted, false},
+	} {
+		var f albumEventFilter
+		json.Unmarshal([]byte(tt.filter), &f)
+		if got := f.matches(tt.event); got != tt.want {
+			t.Errorf("%s matches %+v = %v, want %v", tt.filter, tt.event, got, tt.want)
+		}
+	}
+}